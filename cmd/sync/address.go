@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net/netip"
+)
+
+// addressSelectionConfig selects the address of an instance that is used for its server. The criteria that are set
+// must all match. Without address_selection, the primary private IP address of the instance is used.
+type addressSelectionConfig struct {
+	NICTags map[string]string `yaml:"nic_tags,omitempty"`
+	// DeviceIndex is the device index of the network interface in AWS.
+	DeviceIndex *int   `yaml:"device_index,omitempty"`
+	SubnetID    string `yaml:"subnet_id,omitempty"`
+	SubnetCIDR  string `yaml:"subnet_cidr,omitempty"`
+	// IPConfiguration is the name of the IP configuration of the network interface in Azure.
+	IPConfiguration string `yaml:"ip_configuration,omitempty"`
+	PublicIP        bool   `yaml:"public_ip,omitempty"`
+}
+
+func validateAddressSelectionConfig(cfg *addressSelectionConfig, upstreamName string) error {
+	if cfg.DeviceIndex != nil && *cfg.DeviceIndex < 0 {
+		return fmt.Errorf(upstreamDeviceIndexErrorMsgFmt, *cfg.DeviceIndex, upstreamName)
+	}
+
+	if cfg.SubnetCIDR != "" {
+		if _, err := netip.ParsePrefix(cfg.SubnetCIDR); err != nil {
+			return fmt.Errorf(upstreamSubnetCIDRErrorMsgFmt, cfg.SubnetCIDR, upstreamName)
+		}
+	}
+
+	return nil
+}
+
+// Address is an IP address of a network interface of an instance.
+type Address struct {
+	Tags               map[string]string
+	NetworkInterfaceID string
+	PrivateIP          string
+	PublicIP           string
+	SubnetID           string
+	IPConfiguration    string
+	DeviceIndex        int
+}
+
+// selectAddress returns the IP address of the instance for the server of the upstream: the first address of the
+// instance that matches address_selection or, without address_selection, the primary private IP address. It returns
+// false if no address of the instance matches.
+func selectAddress(cfg *addressSelectionConfig, ins Instance) (string, bool) {
+	if cfg == nil {
+		return ins.PrivateIP, ins.PrivateIP != ""
+	}
+
+	var subnet netip.Prefix
+	if cfg.SubnetCIDR != "" {
+		subnet, _ = netip.ParsePrefix(cfg.SubnetCIDR)
+	}
+
+	for _, address := range ins.Addresses {
+		if !addressMatches(cfg, subnet, address) {
+			continue
+		}
+		ip := address.PrivateIP
+		if cfg.PublicIP {
+			ip = address.PublicIP
+		}
+		if ip != "" {
+			return ip, true
+		}
+	}
+
+	return "", false
+}
+
+func addressMatches(cfg *addressSelectionConfig, subnet netip.Prefix, address Address) bool {
+	if cfg.DeviceIndex != nil && *cfg.DeviceIndex != address.DeviceIndex {
+		return false
+	}
+	if cfg.SubnetID != "" && cfg.SubnetID != address.SubnetID {
+		return false
+	}
+	if cfg.IPConfiguration != "" && cfg.IPConfiguration != address.IPConfiguration {
+		return false
+	}
+	if subnet.IsValid() {
+		ip, err := netip.ParseAddr(address.PrivateIP)
+		if err != nil || !subnet.Contains(ip) {
+			return false
+		}
+	}
+	for key, value := range cfg.NICTags {
+		if v, ok := address.Tags[key]; !ok || v != value {
+			return false
+		}
+	}
+	return true
+}
+
+// withSelectedAddress returns the instances that have an address for the servers of the upstream.
+func withSelectedAddress(upstream Upstream, instances []Instance) []Instance {
+	if upstream.AddressSelection == nil {
+		return instances
+	}
+
+	result := make([]Instance, 0, len(instances))
+	for _, ins := range instances {
+		if _, ok := selectAddress(upstream.AddressSelection, ins); !ok {
+			slog.Debug("Skipping an instance without an address that matches address_selection", "upstream", upstream.Name,
+				"kind", upstream.Kind, "instance_id", ins.ID)
+			continue
+		}
+		result = append(result, ins)
+	}
+	return result
+}
+
+// isPublicIPSelected reports whether any of the upstreams uses the public IP addresses of the instances.
+func isPublicIPSelected(upstreams []Upstream) bool {
+	for _, ups := range upstreams {
+		if ups.AddressSelection != nil && ups.AddressSelection.PublicIP {
+			return true
+		}
+	}
+	return false
+}
+
+// isNICTagSelected reports whether any of the upstreams selects the addresses by the tags of the network interfaces.
+func isNICTagSelected(upstreams []Upstream) bool {
+	for _, ups := range upstreams {
+		if ups.AddressSelection != nil && len(ups.AddressSelection.NICTags) > 0 {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestValidateAddressSelectionConfig(t *testing.T) {
+	t.Parallel()
+	deviceIndex := 1
+	negativeDeviceIndex := -1
+	tests := []struct {
+		msg     string
+		cfg     addressSelectionConfig
+		wantErr bool
+	}{
+		{cfg: addressSelectionConfig{DeviceIndex: &deviceIndex, SubnetCIDR: "10.0.1.0/24"}, msg: "device index and subnet"},
+		{cfg: addressSelectionConfig{NICTags: map[string]string{"role": "data"}, PublicIP: true}, msg: "nic tags and public ip"},
+		{cfg: addressSelectionConfig{DeviceIndex: &negativeDeviceIndex}, msg: "negative device index", wantErr: true},
+		{cfg: addressSelectionConfig{SubnetCIDR: "10.0.1.0"}, msg: "invalid subnet cidr", wantErr: true},
+	}
+
+	for _, test := range tests {
+		err := validateAddressSelectionConfig(&test.cfg, "backend")
+		if (err != nil) != test.wantErr {
+			t.Errorf("validateAddressSelectionConfig() returned %v for the case of %v", err, test.msg)
+		}
+	}
+}
+
+func TestSelectAddress(t *testing.T) {
+	t.Parallel()
+	deviceIndex := 1
+	ins := Instance{
+		ID:        "i-1",
+		PrivateIP: "10.0.0.1",
+		Addresses: []Address{
+			{PrivateIP: "10.0.0.1", PublicIP: "203.0.113.1", SubnetID: "subnet-a", DeviceIndex: 0, IPConfiguration: "primary"},
+			{PrivateIP: "10.0.1.1", SubnetID: "subnet-b", DeviceIndex: 1, IPConfiguration: "data", Tags: map[string]string{"role": "data"}},
+		},
+	}
+
+	tests := []struct {
+		cfg      *addressSelectionConfig
+		msg      string
+		expected string
+	}{
+		{cfg: nil, msg: "no selection", expected: "10.0.0.1"},
+		{cfg: &addressSelectionConfig{}, msg: "empty selection", expected: "10.0.0.1"},
+		{cfg: &addressSelectionConfig{DeviceIndex: &deviceIndex}, msg: "device index", expected: "10.0.1.1"},
+		{cfg: &addressSelectionConfig{SubnetID: "subnet-b"}, msg: "subnet id", expected: "10.0.1.1"},
+		{cfg: &addressSelectionConfig{SubnetCIDR: "10.0.1.0/24"}, msg: "subnet cidr", expected: "10.0.1.1"},
+		{cfg: &addressSelectionConfig{NICTags: map[string]string{"role": "data"}}, msg: "nic tags", expected: "10.0.1.1"},
+		{cfg: &addressSelectionConfig{IPConfiguration: "data"}, msg: "ip configuration", expected: "10.0.1.1"},
+		{cfg: &addressSelectionConfig{PublicIP: true}, msg: "public ip", expected: "203.0.113.1"},
+		{cfg: &addressSelectionConfig{DeviceIndex: &deviceIndex, PublicIP: true}, msg: "public ip of an address without one"},
+		{cfg: &addressSelectionConfig{SubnetID: "subnet-b", IPConfiguration: "primary"}, msg: "criteria that don't match together"},
+		{cfg: &addressSelectionConfig{NICTags: map[string]string{"role": "web"}}, msg: "nic tags that don't match"},
+	}
+
+	for _, test := range tests {
+		ip, ok := selectAddress(test.cfg, ins)
+		if ip != test.expected || ok != (test.expected != "") {
+			t.Errorf("selectAddress() returned %q, %v for the case of %v, expected %q", ip, ok, test.msg, test.expected)
+		}
+	}
+}
+
+func TestWithSelectedAddress(t *testing.T) {
+	t.Parallel()
+	instances := []Instance{
+		{ID: "i-1", PrivateIP: "10.0.0.1", Addresses: []Address{{PrivateIP: "10.0.0.1"}, {PrivateIP: "10.0.1.1", DeviceIndex: 1}}},
+		{ID: "i-2", PrivateIP: "10.0.0.2", Addresses: []Address{{PrivateIP: "10.0.0.2"}}},
+	}
+	deviceIndex := 1
+	upstream := Upstream{Name: "backend", Kind: "http", AddressSelection: &addressSelectionConfig{DeviceIndex: &deviceIndex}}
+
+	result := withSelectedAddress(upstream, instances)
+	if len(result) != 1 || result[0].ID != "i-1" {
+		t.Errorf("withSelectedAddress() returned %+v, expected only the instance i-1", result)
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+)
+
+// syncRequest asks the sync loop to sync the upstreams immediately. The result of the sync is sent to done.
+type syncRequest struct {
+	done      chan error
+	upstreams []Upstream
+}
+
+// adminHandler serves the admin API:
+//   - GET /status returns the last-known state of every upstream.
+//   - POST /sync/{upstream} syncs the upstreams with the name immediately. The optional kind query parameter selects
+//     the http or stream upstream. A replica that is not the leader responds with 409.
+type adminHandler struct {
+	status       *statusStore
+	syncRequests chan<- syncRequest
+	upstreams    *upstreamList
+}
+
+func newAdminHandler(upstreams *upstreamList, status *statusStore, syncRequests chan<- syncRequest) http.Handler {
+	h := &adminHandler{status: status, syncRequests: syncRequests, upstreams: upstreams}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /status", h.getStatus)
+	mux.HandleFunc("POST /sync/{upstream}", h.syncUpstream)
+	return mux
+}
+
+func (h *adminHandler) getStatus(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{"upstreams": h.status.snapshot()})
+}
+
+func (h *adminHandler) syncUpstream(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("upstream")
+	kind := r.URL.Query().Get("kind")
+
+	var upstreams []Upstream
+	for _, u := range h.upstreams.get() {
+		if u.Name == name && (kind == "" || u.Kind == kind) {
+			upstreams = append(upstreams, u)
+		}
+	}
+	if len(upstreams) == 0 {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": fmt.Sprintf("the upstream %v isn't configured", name)})
+		return
+	}
+
+	req := syncRequest{done: make(chan error, 1), upstreams: upstreams}
+	var err error
+	select {
+	case h.syncRequests <- req:
+	case <-r.Context().Done():
+		return
+	}
+	select {
+	case err = <-req.done:
+	case <-r.Context().Done():
+		return
+	}
+	if errors.Is(err, errNotLeader) {
+		writeJSON(w, http.StatusConflict, map[string]string{"error": err.Error()})
+		return
+	}
+
+	var statuses []upstreamStatus
+	for _, st := range h.status.snapshot() {
+		if st.Name == name && (kind == "" || st.Kind == kind) {
+			statuses = append(statuses, st)
+		}
+	}
+
+	status := http.StatusOK
+	if err != nil {
+		status = http.StatusInternalServerError
+	}
+	writeJSON(w, status, map[string]any{"upstreams": statuses})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Error("Couldn't write the response of the admin API", "error", err)
+	}
+}
+
+// startAdminServer starts the admin API server on the address. The server stops when the context is canceled.
+func startAdminServer(ctx context.Context, address string, handler http.Handler) error {
+	ln, err := net.Listen("tcp", address)
+	if err != nil {
+		return fmt.Errorf("couldn't listen on %v: %w", address, err)
+	}
+
+	server := &http.Server{
+		Handler:           handler,
+		ReadHeaderTimeout: connTimeoutInSecs * time.Second,
+	}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), connTimeoutInSecs*time.Second)
+		defer cancel()
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			slog.Error("Couldn't stop the admin API server", "error", err)
+		}
+	}()
+
+	go func() {
+		if err := server.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("The admin API server stopped", "error", err)
+		}
+	}()
+
+	slog.Info("Started the admin API server", "address", ln.Addr().String())
+	return nil
+}
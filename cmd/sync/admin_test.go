@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAdminHandlerStatus(t *testing.T) {
+	t.Parallel()
+	upstreams := []Upstream{{Name: "backend", Kind: "http", ScalingGroup: "group"}}
+	status := newStatusStore(upstreams)
+	status.setResult(upstreams[0], errors.New("throttled"))
+	handler := newAdminHandler(newUpstreamList(upstreams), status, make(chan syncRequest))
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/status", nil))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET /status returned %d, expected %d", rec.Code, http.StatusOK)
+	}
+	var body struct {
+		Upstreams []upstreamStatus `json:"upstreams"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("GET /status returned invalid JSON: %v", err)
+	}
+	if len(body.Upstreams) != 1 || body.Upstreams[0].Name != "backend" || body.Upstreams[0].LastError != "throttled" {
+		t.Errorf("GET /status returned %s", rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/status", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("POST /status returned %d, expected %d", rec.Code, http.StatusMethodNotAllowed)
+	}
+}
+
+func TestAdminHandlerSync(t *testing.T) {
+	t.Parallel()
+	upstreams := []Upstream{
+		{Name: "backend", Kind: "http", ScalingGroup: "group"},
+		{Name: "backend", Kind: "stream", ScalingGroup: "group"},
+	}
+	status := newStatusStore(upstreams)
+	syncRequests := make(chan syncRequest)
+	handler := newAdminHandler(newUpstreamList(upstreams), status, syncRequests)
+
+	synced := make(chan []Upstream, 1)
+	go func() {
+		req := <-syncRequests
+		for _, u := range req.upstreams {
+			status.setResult(u, nil)
+		}
+		synced <- req.upstreams
+		req.done <- nil
+	}()
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/sync/backend?kind=stream", nil))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("POST /sync/backend returned %d, expected %d: %s", rec.Code, http.StatusOK, rec.Body.String())
+	}
+	if got := <-synced; len(got) != 1 || got[0].Kind != "stream" {
+		t.Errorf("POST /sync/backend?kind=stream synced %+v, expected the stream upstream", got)
+	}
+	var body struct {
+		Upstreams []upstreamStatus `json:"upstreams"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("POST /sync/backend returned invalid JSON: %v", err)
+	}
+	if len(body.Upstreams) != 1 || body.Upstreams[0].LastSuccess == nil {
+		t.Errorf("POST /sync/backend returned %s, expected the status of the synced upstream", rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/sync/unknown", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("POST /sync/unknown returned %d, expected %d", rec.Code, http.StatusNotFound)
+	}
+}
+
+func TestAdminHandlerSyncNotLeader(t *testing.T) {
+	t.Parallel()
+	upstreams := []Upstream{{Name: "backend", Kind: "http", ScalingGroup: "group"}}
+	syncRequests := make(chan syncRequest)
+	handler := newAdminHandler(newUpstreamList(upstreams), newStatusStore(upstreams), syncRequests)
+
+	go func() {
+		req := <-syncRequests
+		req.done <- errNotLeader
+	}()
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/sync/backend", nil))
+	if rec.Code != http.StatusConflict {
+		t.Errorf("POST /sync/backend returned %d on a standby replica, expected %d", rec.Code, http.StatusConflict)
+	}
+}
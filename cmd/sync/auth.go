@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+)
+
+const (
+	authorizationHeader = "Authorization"
+	// tokenRefreshMargin is how long before its expiry a token is refreshed.
+	tokenRefreshMargin = 5 * time.Minute
+)
+
+// apiAuthConfig configures how nginx-asg-sync authenticates to the NGINX Plus API.
+type apiAuthConfig struct {
+	EntraID *entraIDAuthConfig `yaml:"entra_id,omitempty"`
+}
+
+// entraIDAuthConfig configures the Microsoft Entra ID tokens for the API, for example the NGINXaaS dataplane API.
+type entraIDAuthConfig struct {
+	Scope    string `yaml:"scope"`
+	ClientID string `yaml:"client_id,omitempty"`
+}
+
+func validateAPIAuthConfig(cfg *apiAuthConfig) error {
+	if cfg.EntraID == nil {
+		return errors.New(apiAuthErrorMsg)
+	}
+	if cfg.EntraID.Scope == "" {
+		return fmt.Errorf(errorMsgFormat, "scope of api_auth.entra_id")
+	}
+	return nil
+}
+
+// hasAuthorizationHeader reports whether the custom headers of the config set the Authorization header.
+func hasAuthorizationHeader(cfg *commonConfig) bool {
+	for key := range cfg.CustomHeaders {
+		if http.CanonicalHeaderKey(key) == authorizationHeader {
+			return true
+		}
+	}
+	for key := range cfg.CustomHeaderSecrets {
+		if http.CanonicalHeaderKey(key) == authorizationHeader {
+			return true
+		}
+	}
+	return false
+}
+
+// newEntraIDTokenSource creates the source of the Authorization header with the Entra ID tokens. The tokens are
+// acquired with the user-assigned managed identity with the client ID, if set, otherwise with the default Azure
+// credential, which includes the system-assigned managed identity.
+func newEntraIDTokenSource(cfg *entraIDAuthConfig) (*tokenHeaderValue, error) {
+	var cred azcore.TokenCredential
+	var err error
+	if cfg.ClientID != "" {
+		cred, err = azidentity.NewManagedIdentityCredential(&azidentity.ManagedIdentityCredentialOptions{
+			ID: azidentity.ClientID(cfg.ClientID),
+		})
+	} else {
+		cred, err = azidentity.NewDefaultAzureCredential(nil)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("couldn't create the Azure credential: %w", err)
+	}
+
+	return &tokenHeaderValue{cred: cred, scopes: []string{cfg.Scope}}, nil
+}
+
+// tokenHeaderValue provides the Authorization header with a bearer token. The token is cached and refreshed before
+// it expires.
+type tokenHeaderValue struct {
+	cred   azcore.TokenCredential
+	token  azcore.AccessToken
+	scopes []string
+	mu     sync.Mutex
+}
+
+func (t *tokenHeaderValue) Value(ctx context.Context) (string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	if t.token.Token != "" && now.Add(tokenRefreshMargin).Before(t.token.ExpiresOn) {
+		return "Bearer " + t.token.Token, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, connTimeoutInSecs*time.Second)
+	defer cancel()
+
+	token, err := t.cred.GetToken(ctx, policy.TokenRequestOptions{Scopes: t.scopes})
+	if err != nil {
+		if t.token.Token != "" && now.Before(t.token.ExpiresOn) {
+			slog.Warn("Couldn't refresh the token for the NGINX Plus API, using the current one", "error", err)
+			return "Bearer " + t.token.Token, nil
+		}
+		return "", fmt.Errorf("couldn't get a token for the scope %v: %w", strings.Join(t.scopes, " "), err)
+	}
+
+	t.token = token
+	return "Bearer " + t.token.Token, nil
+}
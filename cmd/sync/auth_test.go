@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+)
+
+type fakeTokenCredential struct {
+	err       error
+	expiresIn time.Duration
+	calls     int
+}
+
+func (c *fakeTokenCredential) GetToken(_ context.Context, opts policy.TokenRequestOptions) (azcore.AccessToken, error) {
+	c.calls++
+	if c.err != nil {
+		return azcore.AccessToken{}, c.err
+	}
+	if len(opts.Scopes) != 1 || opts.Scopes[0] != "api://dataplane/.default" {
+		return azcore.AccessToken{}, errors.New("unexpected scopes")
+	}
+	return azcore.AccessToken{Token: fmt.Sprintf("token-%d", c.calls), ExpiresOn: time.Now().Add(c.expiresIn)}, nil
+}
+
+func TestTokenHeaderValue(t *testing.T) {
+	t.Parallel()
+	cred := &fakeTokenCredential{expiresIn: time.Hour}
+	src := &tokenHeaderValue{cred: cred, scopes: []string{"api://dataplane/.default"}}
+
+	for range 2 {
+		value, err := src.Value(t.Context())
+		if err != nil {
+			t.Fatalf("Value() failed: %v", err)
+		}
+		if value != "Bearer token-1" {
+			t.Errorf("Value() returned %q, expected %q", value, "Bearer token-1")
+		}
+	}
+	if cred.calls != 1 {
+		t.Errorf("Value() requested %d tokens for a valid token, expected 1", cred.calls)
+	}
+
+	// a token that expires within the refresh margin is refreshed
+	src.token.ExpiresOn = time.Now().Add(tokenRefreshMargin / 2)
+	if value, _ := src.Value(t.Context()); value != "Bearer token-2" {
+		t.Errorf("Value() returned %q for a token close to the expiry, expected %q", value, "Bearer token-2")
+	}
+
+	// the current token is used while it is valid if the refresh fails
+	src.token.ExpiresOn = time.Now().Add(tokenRefreshMargin / 2)
+	cred.err = errors.New("identity endpoint unavailable")
+	if value, err := src.Value(t.Context()); err != nil || value != "Bearer token-2" {
+		t.Errorf("Value() returned %q and %v when the refresh failed, expected the current token", value, err)
+	}
+
+	src.token.ExpiresOn = time.Now().Add(-time.Minute)
+	if _, err := src.Value(t.Context()); err == nil {
+		t.Error("Value() didn't fail for an expired token that couldn't be refreshed")
+	}
+}
+
+func TestValidateAPIAuthConfig(t *testing.T) {
+	t.Parallel()
+	if err := validateAPIAuthConfig(&apiAuthConfig{EntraID: &entraIDAuthConfig{Scope: "api://dataplane/.default"}}); err != nil {
+		t.Errorf("validateAPIAuthConfig() failed for the valid config: %v", err)
+	}
+	if err := validateAPIAuthConfig(&apiAuthConfig{}); err == nil {
+		t.Error("validateAPIAuthConfig() didn't fail for the config without entra_id")
+	}
+	if err := validateAPIAuthConfig(&apiAuthConfig{EntraID: &entraIDAuthConfig{ClientID: "client"}}); err == nil {
+		t.Error("validateAPIAuthConfig() didn't fail for the config without scope")
+	}
+
+	cfg := &commonConfig{
+		APIEndpoint:   "http://127.0.0.1:8080/api",
+		SyncInterval:  time.Second,
+		CustomHeaders: map[string]string{"authorization": "ApiKey key"},
+		APIAuth:       &apiAuthConfig{EntraID: &entraIDAuthConfig{Scope: "api://dataplane/.default"}},
+	}
+	if err := validateCommonConfig(cfg); err == nil {
+		t.Error("validateCommonConfig() didn't fail for api_auth with the Authorization custom header")
+	}
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"log/slog"
+	"maps"
+	"slices"
+	"strconv"
+	"sync"
+)
+
+// The keys of the tags of a scaling group that define an upstream, after the tag_prefix of upstream_discovery.
+const (
+	upstreamTag            = "upstream"
+	upstreamPortTag        = "port"
+	upstreamKindTag        = "kind"
+	upstreamMaxConnsTag    = "max_conns"
+	upstreamMaxFailsTag    = "max_fails"
+	upstreamFailTimeoutTag = "fail_timeout"
+	upstreamSlowStartTag   = "slow_start"
+)
+
+// upstreamDiscoveryConfig configures the discovery of the upstreams from the tags of the scaling groups, in addition
+// to the upstreams of the config.
+type upstreamDiscoveryConfig struct {
+	TagPrefix string `yaml:"tag_prefix"`
+	Kind      string `yaml:"kind"`
+}
+
+func validateUpstreamDiscoveryConfig(cfg *upstreamDiscoveryConfig) error {
+	if cfg.Kind != "" && cfg.Kind != "http" && cfg.Kind != "stream" {
+		return fmt.Errorf(upstreamDiscoveryKindErrorMsgFmt, cfg.Kind)
+	}
+	return nil
+}
+
+// withDefaults returns a copy of the config with the defaults set, using the default tag prefix of the cloud provider.
+func (cfg *upstreamDiscoveryConfig) withDefaults(tagPrefix string) *upstreamDiscoveryConfig {
+	if cfg == nil {
+		return nil
+	}
+	c := *cfg
+	if c.TagPrefix == "" {
+		c.TagPrefix = tagPrefix
+	}
+	if c.Kind == "" {
+		c.Kind = "http"
+	}
+	return &c
+}
+
+// upstreamFromTags returns the upstream defined by the tags of the scaling group.
+func upstreamFromTags(cfg *upstreamDiscoveryConfig, group string, tags map[string]string) (Upstream, error) {
+	tag := func(key string) string {
+		return tags[cfg.TagPrefix+key]
+	}
+
+	upstream := Upstream{
+		Name:         tag(upstreamTag),
+		Kind:         cmp.Or(tag(upstreamKindTag), cfg.Kind),
+		ScalingGroup: group,
+		FailTimeout:  getFailTimeoutOrDefault(tag(upstreamFailTimeoutTag)),
+		SlowStart:    getSlowStartOrDefault(tag(upstreamSlowStartTag)),
+	}
+	if upstream.Name == "" {
+		return upstream, fmt.Errorf("the tag %v is empty", cfg.TagPrefix+upstreamTag)
+	}
+	if upstream.Kind != "http" && upstream.Kind != "stream" {
+		return upstream, fmt.Errorf("the tag %v has invalid value %v, valid values are http and stream", cfg.TagPrefix+upstreamKindTag, upstream.Kind)
+	}
+
+	port, err := strconv.Atoi(tag(upstreamPortTag))
+	if err != nil || port < 1 || port > 65535 {
+		return upstream, fmt.Errorf("the tag %v has invalid value %q", cfg.TagPrefix+upstreamPortTag, tag(upstreamPortTag))
+	}
+	upstream.Port = port
+
+	values := make([]int, 2)
+	for i, key := range []string{upstreamMaxConnsTag, upstreamMaxFailsTag} {
+		if tag(key) == "" {
+			continue
+		}
+		values[i], err = strconv.Atoi(tag(key))
+		if err != nil || values[i] < 0 {
+			return upstream, fmt.Errorf("the tag %v has invalid value %q", cfg.TagPrefix+key, tag(key))
+		}
+	}
+	upstream.MaxConns = &values[0]
+	upstream.MaxFails = &values[1]
+
+	for _, key := range []string{upstreamFailTimeoutTag, upstreamSlowStartTag} {
+		if !isValidTime(tag(key)) {
+			return upstream, fmt.Errorf("the tag %v has invalid value %q", cfg.TagPrefix+key, tag(key))
+		}
+	}
+
+	return upstream, nil
+}
+
+// upstreamChecker checks that the upstream exists in NGINX Plus.
+type upstreamChecker func(ctx context.Context, upstream Upstream) error
+
+// upstreamDiscovery adds the upstreams discovered from the tags of the scaling groups to the upstreams of the config.
+// An upstream is added after its upstream zone is found in NGINX Plus and removed when its scaling group is no longer
+// tagged. The servers of a removed upstream are left in NGINX Plus as they are, so the removal is logged as a warning
+// and sent to the notifier.
+type upstreamDiscovery struct {
+	discoverer upstreamDiscoverer
+	check      upstreamChecker
+	notifier   changeNotifier
+	// discovered are the discovered upstreams that are synced.
+	discovered map[upstreamKey]Upstream
+	// skipped are the reasons why the scaling groups aren't synced, so that every reason is logged once.
+	skipped map[string]string
+	static  []Upstream
+}
+
+func newUpstreamDiscovery(discoverer upstreamDiscoverer, static []Upstream, check upstreamChecker) *upstreamDiscovery {
+	return &upstreamDiscovery{
+		discoverer: discoverer,
+		check:      check,
+		discovered: make(map[upstreamKey]Upstream),
+		skipped:    make(map[string]string),
+		static:     static,
+	}
+}
+
+// refresh discovers the upstreams and returns the upstreams of the config followed by the discovered ones. If the
+// discovery fails, the upstreams discovered before are kept.
+func (d *upstreamDiscovery) refresh(ctx context.Context) []Upstream {
+	cfg := d.discoverer.UpstreamDiscovery()
+	ctx, span := startSpan(ctx, "GetTaggedScalingGroups", "tag", cfg.TagPrefix+upstreamTag)
+	groups, err := d.discoverer.GetTaggedScalingGroups(ctx, cfg.TagPrefix+upstreamTag)
+	endSpan(span, err)
+	if err != nil {
+		slog.Error("Couldn't discover the upstreams, keeping the discovered upstreams", "error", err)
+		return d.upstreams()
+	}
+
+	configured := make(map[upstreamKey]bool, len(d.static))
+	for _, u := range d.static {
+		configured[upstreamKey{name: u.Name, kind: u.Kind}] = true
+	}
+
+	skipped := make(map[string]string)
+	discovered := make(map[upstreamKey]Upstream, len(groups))
+	for _, group := range slices.Sorted(maps.Keys(groups)) {
+		upstream, err := upstreamFromTags(cfg, group, groups[group])
+		key := upstreamKey{name: upstream.Name, kind: upstream.Kind}
+		switch {
+		case err != nil:
+			skipped[group] = err.Error()
+		case configured[key]:
+			skipped[group] = fmt.Sprintf("the upstream %v of kind %v is defined in the config file", upstream.Name, upstream.Kind)
+		case discovered[key].ScalingGroup != "":
+			skipped[group] = fmt.Sprintf("the upstream %v of kind %v is defined by the scaling group %v", upstream.Name, upstream.Kind, discovered[key].ScalingGroup)
+		default:
+			if _, ok := d.discovered[key]; !ok {
+				if err := d.check(ctx, upstream); err != nil {
+					skipped[group] = err.Error()
+					continue
+				}
+			}
+			discovered[key] = upstream
+		}
+	}
+
+	for group, reason := range skipped {
+		if d.skipped[group] != reason {
+			slog.Warn("Skipping the tagged scaling group", "scaling_group", group, "reason", reason)
+		}
+	}
+	for key, upstream := range discovered {
+		previous, ok := d.discovered[key]
+		if !ok {
+			slog.Info("Discovered an upstream", "upstream", upstream.Name, "kind", upstream.Kind, "scaling_group", upstream.ScalingGroup)
+		} else if previous.ScalingGroup != upstream.ScalingGroup {
+			slog.Info("Updated a discovered upstream", "upstream", upstream.Name, "kind", upstream.Kind, "scaling_group", upstream.ScalingGroup)
+		}
+	}
+	for key, upstream := range d.discovered {
+		if _, ok := discovered[key]; !ok {
+			slog.Warn("Stopped syncing a discovered upstream, its servers are left in NGINX Plus as they are",
+				"upstream", upstream.Name, "kind", upstream.Kind, "scaling_group", upstream.ScalingGroup)
+			if d.notifier != nil {
+				d.notifier.Notify(newUpstreamRemovedEvent(upstream, reasonUpstreamUntagged))
+			}
+		}
+	}
+	d.skipped = skipped
+	d.discovered = discovered
+
+	return d.upstreams()
+}
+
+func (d *upstreamDiscovery) upstreams() []Upstream {
+	discovered := slices.SortedFunc(maps.Values(d.discovered), func(a, b Upstream) int {
+		return cmp.Or(cmp.Compare(a.Name, b.Name), cmp.Compare(a.Kind, b.Kind))
+	})
+	return append(slices.Clone(d.static), discovered...)
+}
+
+// upstreamList is the list of the upstreams, shared by the sync loop and the admin API.
+type upstreamList struct {
+	upstreams []Upstream
+	mu        sync.Mutex
+}
+
+func newUpstreamList(upstreams []Upstream) *upstreamList {
+	return &upstreamList{upstreams: upstreams}
+}
+
+func (l *upstreamList) get() []Upstream {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.upstreams
+}
+
+func (l *upstreamList) set(upstreams []Upstream) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.upstreams = upstreams
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestUpstreamFromTags(t *testing.T) {
+	t.Parallel()
+	cfg := (&upstreamDiscoveryConfig{}).withDefaults("nginx-asg-sync/")
+	tags := func(kv ...string) map[string]string {
+		result := make(map[string]string)
+		for i := 0; i+1 < len(kv); i += 2 {
+			result["nginx-asg-sync/"+kv[i]] = kv[i+1]
+		}
+		return result
+	}
+
+	upstream, err := upstreamFromTags(cfg, "group", tags("upstream", "backend", "port", "8080", "max_fails", "3", "slow_start", "30s"))
+	if err != nil {
+		t.Fatalf("upstreamFromTags() failed: %v", err)
+	}
+	if upstream.Name != "backend" || upstream.Kind != "http" || upstream.ScalingGroup != "group" || upstream.Port != 8080 ||
+		*upstream.MaxFails != 3 || *upstream.MaxConns != 0 || upstream.SlowStart != "30s" || upstream.FailTimeout != defaultFailTimeout {
+		t.Errorf("upstreamFromTags() returned %+v", upstream)
+	}
+
+	invalid := []struct {
+		tags map[string]string
+		msg  string
+	}{
+		{tags: tags("port", "8080"), msg: "no upstream tag"},
+		{tags: tags("upstream", "backend"), msg: "no port tag"},
+		{tags: tags("upstream", "backend", "port", "80000"), msg: "invalid port"},
+		{tags: tags("upstream", "backend", "port", "80", "kind", "grpc"), msg: "invalid kind"},
+		{tags: tags("upstream", "backend", "port", "80", "max_conns", "-1"), msg: "invalid max_conns"},
+		{tags: tags("upstream", "backend", "port", "80", "fail_timeout", "soon"), msg: "invalid fail_timeout"},
+	}
+	for _, test := range invalid {
+		if _, err := upstreamFromTags(cfg, "group", test.tags); err == nil {
+			t.Errorf("upstreamFromTags() didn't fail for the case of %v", test.msg)
+		}
+	}
+}
+
+type fakeUpstreamDiscoverer struct {
+	err    error
+	groups map[string]map[string]string
+}
+
+func (d *fakeUpstreamDiscoverer) UpstreamDiscovery() *upstreamDiscoveryConfig {
+	return (&upstreamDiscoveryConfig{}).withDefaults("nginx-asg-sync/")
+}
+
+func (d *fakeUpstreamDiscoverer) GetTaggedScalingGroups(context.Context, string) (map[string]map[string]string, error) {
+	return d.groups, d.err
+}
+
+func TestUpstreamDiscoveryRefresh(t *testing.T) {
+	t.Parallel()
+	group := func(name, kind string) map[string]string {
+		return map[string]string{"nginx-asg-sync/upstream": name, "nginx-asg-sync/kind": kind, "nginx-asg-sync/port": "80"}
+	}
+	discoverer := &fakeUpstreamDiscoverer{groups: map[string]map[string]string{
+		"web-group":       group("web", "http"),
+		"web-copy-group":  group("web", "http"),
+		"dns-group":       group("dns", "stream"),
+		"static-group":    group("static", "http"),
+		"missing-group":   group("missing", "http"),
+		"untagged-group":  {"nginx-asg-sync/upstream": "invalid"},
+		"web-other-group": group("web", "stream"),
+	}}
+	checks := 0
+	check := func(_ context.Context, upstream Upstream) error {
+		checks++
+		if upstream.Name == "missing" {
+			return fmt.Errorf("upstream %v not found", upstream.Name)
+		}
+		return nil
+	}
+	static := []Upstream{{Name: "static", Kind: "http", ScalingGroup: "configured-group", Port: 80}}
+	d := newUpstreamDiscovery(discoverer, static, check)
+	notifier := &fakeChangeNotifier{}
+	d.notifier = notifier
+	ctx := context.Background()
+
+	refresh := func(msg string, expected []string) {
+		t.Helper()
+		var got []string
+		for _, u := range d.refresh(ctx) {
+			got = append(got, fmt.Sprintf("%v/%v/%v", u.Name, u.Kind, u.ScalingGroup))
+		}
+		if !reflect.DeepEqual(got, expected) {
+			t.Errorf("refresh() returned %v %v, expected %v", got, msg, expected)
+		}
+	}
+
+	refresh("after the first discovery", []string{
+		"static/http/configured-group", "dns/stream/dns-group", "web/http/web-copy-group", "web/stream/web-other-group",
+	})
+	if checks != 4 {
+		t.Errorf("refresh() checked %d upstreams in NGINX Plus, expected 4", checks)
+	}
+
+	// the known upstreams aren't checked again
+	checks = 0
+	refresh("after the second discovery", []string{
+		"static/http/configured-group", "dns/stream/dns-group", "web/http/web-copy-group", "web/stream/web-other-group",
+	})
+	if checks != 1 {
+		t.Errorf("refresh() checked %d upstreams in NGINX Plus, expected only the missing one", checks)
+	}
+
+	discoverer.err = errors.New("throttled")
+	refresh("after a failed discovery", []string{
+		"static/http/configured-group", "dns/stream/dns-group", "web/http/web-copy-group", "web/stream/web-other-group",
+	})
+
+	discoverer.err = nil
+	delete(discoverer.groups, "web-copy-group")
+	delete(discoverer.groups, "dns-group")
+	refresh("after the groups were untagged", []string{
+		"static/http/configured-group", "web/http/web-group", "web/stream/web-other-group",
+	})
+
+	// only the upstream that is no longer synced is reported, not the one defined by another group
+	if len(notifier.events) != 1 || notifier.events[0].Upstream != "dns" || notifier.events[0].Reason != reasonUpstreamUntagged {
+		t.Errorf("refresh() sent the events %+v, expected an event for the removed upstream dns", notifier.events)
+	}
+}
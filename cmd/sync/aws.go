@@ -4,7 +4,11 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"reflect"
+	"io"
+	"maps"
+	"regexp"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
@@ -12,6 +16,7 @@ import (
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/feature/ec2/imds"
 	"github.com/aws/aws-sdk-go-v2/service/autoscaling"
+	autoscalingtypes "github.com/aws/aws-sdk-go-v2/service/autoscaling/types"
 	"github.com/aws/aws-sdk-go-v2/service/ec2"
 	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
 	yaml "gopkg.in/yaml.v3"
@@ -21,7 +26,9 @@ import (
 type AWSClient struct {
 	svcEC2         *ec2.Client
 	svcAutoscaling *autoscaling.Client
+	imdsClient     *imds.Client
 	config         *awsConfig
+	upstreamSource func() []Upstream
 }
 
 // NewAWSClient creates and configures an AWSClient.
@@ -46,21 +53,42 @@ func (client *AWSClient) GetUpstreams() []Upstream {
 	upstreams := make([]Upstream, 0, len(client.config.Upstreams))
 	for i := range len(client.config.Upstreams) {
 		u := Upstream{
-			Name:         client.config.Upstreams[i].Name,
-			Port:         client.config.Upstreams[i].Port,
-			Kind:         client.config.Upstreams[i].Kind,
-			ScalingGroup: client.config.Upstreams[i].AutoscalingGroup,
-			MaxConns:     &client.config.Upstreams[i].MaxConns,
-			MaxFails:     &client.config.Upstreams[i].MaxFails,
-			FailTimeout:  getFailTimeoutOrDefault(client.config.Upstreams[i].FailTimeout),
-			SlowStart:    getSlowStartOrDefault(client.config.Upstreams[i].SlowStart),
-			InService:    client.config.Upstreams[i].InService,
+			Name:               client.config.Upstreams[i].Name,
+			Port:               client.config.Upstreams[i].Port,
+			Kind:               client.config.Upstreams[i].Kind,
+			ScalingGroup:       client.config.Upstreams[i].AutoscalingGroup,
+			BackupScalingGroup: client.config.Upstreams[i].BackupAutoscalingGroup,
+			MaxConns:           &client.config.Upstreams[i].MaxConns,
+			MaxFails:           &client.config.Upstreams[i].MaxFails,
+			FailTimeout:        getFailTimeoutOrDefault(client.config.Upstreams[i].FailTimeout),
+			SlowStart:          getSlowStartOrDefault(client.config.Upstreams[i].SlowStart),
+			DrainTimeout:       client.config.Upstreams[i].DrainTimeout,
+			MinInstanceAge:     client.config.Upstreams[i].MinInstanceAge,
+			InService:          client.config.Upstreams[i].InService,
+			ZoneAwareness:      client.config.Upstreams[i].ZoneAwareness,
+			HealthProbe:        client.config.Upstreams[i].HealthProbe,
+			UnhealthyFeedback:  client.config.Upstreams[i].UnhealthyFeedback,
+			AddressSelection:   client.config.Upstreams[i].AddressSelection,
+			InstanceTags:       client.config.Upstreams[i].InstanceTags,
 		}
 		upstreams = append(upstreams, u)
 	}
 	return upstreams
 }
 
+// SetUpstreamSource sets the function that returns all the synced upstreams, including the discovered ones.
+func (client *AWSClient) SetUpstreamSource(source func() []Upstream) {
+	client.upstreamSource = source
+}
+
+// syncedUpstreams returns all the synced upstreams, or the upstreams of the config if the upstream source isn't set.
+func (client *AWSClient) syncedUpstreams() []Upstream {
+	if client.upstreamSource != nil {
+		return client.upstreamSource()
+	}
+	return client.GetUpstreams()
+}
+
 // configure configures the AWSClient with necessary parameters.
 func (client *AWSClient) configure() error {
 	httpClient := http.NewBuildableClient().WithTimeout(connTimeoutInSecs * time.Second)
@@ -94,9 +122,17 @@ func (client *AWSClient) configure() error {
 		return fmt.Errorf("unable to load default AWS config: %w", err)
 	}
 
-	client.svcEC2 = ec2.NewFromConfig(cfg)
+	// the calls to EC2 and Auto Scaling are retried by the retry policy of the config. The retries of the SDK are
+	// disabled for them, so that the attempts don't multiply and max_attempts is the total number of attempts
+	client.svcEC2 = ec2.NewFromConfig(cfg, func(o *ec2.Options) {
+		o.Retryer = aws.NopRetryer{}
+	})
 
-	client.svcAutoscaling = autoscaling.NewFromConfig(cfg)
+	client.svcAutoscaling = autoscaling.NewFromConfig(cfg, func(o *autoscaling.Options) {
+		o.Retryer = aws.NopRetryer{}
+	})
+
+	client.imdsClient = imds.NewFromConfig(cfg)
 
 	return nil
 }
@@ -117,111 +153,418 @@ func parseAWSConfig(data []byte) (*awsConfig, error) {
 	return cfg, nil
 }
 
-// CheckIfScalingGroupExists checks if the Auto Scaling group exists.
-func (client *AWSClient) CheckIfScalingGroupExists(name string) (bool, error) {
-	params := &ec2.DescribeInstancesInput{
-		Filters: []types.Filter{
-			{
-				Name: aws.String("tag:aws:autoscaling:groupName"),
-				Values: []string{
-					name,
-				},
-			},
-		},
+// maxFilterValues is the maximum number of values of a filter of the EC2 API.
+const maxFilterValues = 200
+
+// CheckIfScalingGroupExists checks if the Auto Scaling group exists. A name with wildcards exists if a group matches it.
+func (client *AWSClient) CheckIfScalingGroupExists(ctx context.Context, name string) (bool, error) {
+	if hasWildcard(name) {
+		groups, err := client.describeScalingGroups(ctx, []string{name})
+		if err != nil {
+			return false, fmt.Errorf("couldn't check if an AutoScaling group exists: %w", err)
+		}
+
+		pattern := groupNamePattern(name)
+		return slices.ContainsFunc(slices.Collect(maps.Keys(groups)), pattern.MatchString), nil
 	}
 
-	response, err := client.svcEC2.DescribeInstances(context.Background(), params)
+	params := &autoscaling.DescribeAutoScalingGroupsInput{
+		AutoScalingGroupNames: []string{name},
+		IncludeInstances:      aws.Bool(false),
+	}
+
+	callCtx, done := startCloudAPICall(ctx, "DescribeAutoScalingGroups", "scaling_group", name)
+	response, err := client.svcAutoscaling.DescribeAutoScalingGroups(callCtx, params)
+	done(err)
 	if err != nil {
 		return false, fmt.Errorf("couldn't check if an AutoScaling group exists: %w", err)
 	}
 
-	return len(response.Reservations) > 0, nil
+	return len(response.AutoScalingGroups) > 0, nil
 }
 
-// GetPrivateIPsForScalingGroup returns the list of IP addresses of instances of the Auto Scaling group.
-func (client *AWSClient) GetPrivateIPsForScalingGroup(name string) ([]string, error) {
-	var onlyInService bool
-	for _, u := range client.GetUpstreams() {
-		if u.ScalingGroup == name && u.InService {
-			onlyInService = true
-			break
-		}
+// GetInstancesForScalingGroup returns the list of instances of the Auto Scaling group.
+func (client *AWSClient) GetInstancesForScalingGroup(ctx context.Context, name string) ([]Instance, error) {
+	groups, err := client.GetInstancesForScalingGroups(ctx, []string{name})
+	if err != nil {
+		return nil, err
 	}
-	params := &ec2.DescribeInstancesInput{
-		Filters: []types.Filter{
-			{
-				Name: aws.String("tag:aws:autoscaling:groupName"),
-				Values: []string{
-					name,
-				},
-			},
-		},
+
+	instances, ok := groups[name]
+	if !ok {
+		return nil, fmt.Errorf("autoscaling group %v doesn't exist", name)
 	}
 
-	response, err := client.svcEC2.DescribeInstances(context.Background(), params)
+	return instances, nil
+}
+
+// GetInstancesForScalingGroups returns the instances of the Auto Scaling groups by the names of the groups, which can
+// have wildcards. The groups are described with one paginated DescribeAutoScalingGroups call, which returns the
+// lifecycle state and the health status of their instances, and the instances with DescribeInstances calls by their
+// IDs, in batches of maxFilterValues. The instances with the Unhealthy health status, which their groups are about to
+// replace, are left out. The groups that don't exist or have no instances are left out, so that, as before the batch
+// discovery, an empty group is reported as a group that doesn't exist and its upstream isn't emptied.
+func (client *AWSClient) GetInstancesForScalingGroups(ctx context.Context, names []string) (map[string][]Instance, error) {
+	names = slices.Compact(slices.Sorted(slices.Values(names)))
+
+	groups, err := client.describeScalingGroups(ctx, names)
 	if err != nil {
-		return nil, fmt.Errorf("couldn't describe instances: %w", err)
+		return nil, err
 	}
 
-	if len(response.Reservations) == 0 {
-		return nil, fmt.Errorf("autoscaling group %v doesn't exist", name)
+	var ids []string
+	for _, instances := range groups {
+		for _, ins := range instances {
+			if !isUnhealthy(ins) {
+				ids = append(ids, aws.ToString(ins.InstanceId))
+			}
+		}
+	}
+
+	instances, err := client.describeInstances(ctx, "instance-id", slices.Compact(slices.Sorted(slices.Values(ids))))
+	if err != nil {
+		return nil, err
+	}
+
+	upstreams := client.syncedUpstreams()
+	if isNICTagSelected(upstreams) {
+		if err := client.addNetworkInterfaceTags(ctx, instances); err != nil {
+			return nil, err
+		}
+	}
+
+	onlyInService := make(map[string]bool)
+	for _, u := range upstreams {
+		if u.InService {
+			onlyInService[u.ScalingGroup] = true
+			if u.BackupScalingGroup != "" {
+				onlyInService[u.BackupScalingGroup] = true
+			}
+		}
 	}
 
-	var result []string
-	insIDtoIP := make(map[string]string)
+	return instancesByScalingGroup(names, groups, instances, onlyInService), nil
+}
 
-	for _, res := range response.Reservations {
-		for _, ins := range res.Instances {
-			if len(ins.NetworkInterfaces) > 0 && ins.NetworkInterfaces[0].PrivateIpAddress != nil {
-				if onlyInService {
-					insIDtoIP[*ins.InstanceId] = *ins.NetworkInterfaces[0].PrivateIpAddress
-				} else {
-					result = append(result, *ins.NetworkInterfaces[0].PrivateIpAddress)
+// instancesByScalingGroup returns the instances of the Auto Scaling groups by the names of the groups, which can have
+// wildcards, given the instances of the Auto Scaling groups by the names of the groups and the described instances by
+// their IDs. The Unhealthy instances and the instances that weren't described, for example without a private IP
+// address, are left out. With onlyInService, only the instances in the InService lifecycle state are kept. The names
+// that match no group with instances are left out.
+func instancesByScalingGroup(names []string, groups map[string][]autoscalingtypes.Instance, instances map[string]Instance, onlyInService map[string]bool) map[string][]Instance {
+	result := make(map[string][]Instance, len(names))
+	for _, name := range names {
+		pattern := groupNamePattern(name)
+		found := false
+		matched := make([]Instance, 0)
+		for group, groupInstances := range groups {
+			if !pattern.MatchString(group) {
+				continue
+			}
+			for _, ins := range groupInstances {
+				found = true
+				if isUnhealthy(ins) || (onlyInService[name] && ins.LifecycleState != autoscalingtypes.LifecycleStateInService) {
+					continue
+				}
+				if instance, ok := instances[aws.ToString(ins.InstanceId)]; ok {
+					matched = append(matched, instance)
 				}
 			}
 		}
+
+		if !found {
+			continue
+		}
+		slices.SortFunc(matched, func(a, b Instance) int {
+			return strings.Compare(a.ID, b.ID)
+		})
+		result[name] = matched
 	}
-	if onlyInService {
-		result, err = client.getInstancesInService(insIDtoIP)
-		if err != nil {
-			return nil, err
+	return result
+}
+
+// isUnhealthy reports whether the instance of an Auto Scaling group has the Unhealthy health status.
+func isUnhealthy(ins autoscalingtypes.Instance) bool {
+	return aws.ToString(ins.HealthStatus) == "Unhealthy"
+}
+
+// hasWildcard reports whether the name of an Auto Scaling group has wildcards.
+func hasWildcard(name string) bool {
+	return strings.ContainsAny(name, "*?")
+}
+
+// groupNamePattern returns the regular expression that matches the names of the Auto Scaling groups like the name
+// matches the values of EC2 filters: * matches any characters, ? matches one character and \ escapes them.
+func groupNamePattern(name string) *regexp.Regexp {
+	var b strings.Builder
+	b.WriteString("^(?s:")
+	escaped := false
+	for _, r := range name {
+		switch {
+		case escaped:
+			b.WriteString(regexp.QuoteMeta(string(r)))
+			escaped = false
+		case r == '\\':
+			escaped = true
+		case r == '*':
+			b.WriteString(".*")
+		case r == '?':
+			b.WriteString(".")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	b.WriteString(")$")
+	return regexp.MustCompile(b.String())
+}
+
+// describeScalingGroups returns the instances of the Auto Scaling groups by the names of the groups. The groups are
+// described by their names in batches of maxGroups, usually a single paginated call, or, if a name has wildcards,
+// all the groups are described, because DescribeAutoScalingGroups doesn't support wildcards. The groups that don't
+// exist are left out.
+func (client *AWSClient) describeScalingGroups(ctx context.Context, names []string) (map[string][]autoscalingtypes.Instance, error) {
+	const maxGroups = 100
+	batches := prepareBatches(maxGroups, names)
+	if slices.ContainsFunc(names, hasWildcard) {
+		batches = [][]string{nil}
+	}
+
+	result := make(map[string][]autoscalingtypes.Instance)
+	for _, batch := range batches {
+		params := &autoscaling.DescribeAutoScalingGroupsInput{
+			AutoScalingGroupNames: batch,
+			MaxRecords:            aws.Int32(maxGroups),
+		}
+		paginator := autoscaling.NewDescribeAutoScalingGroupsPaginator(client.svcAutoscaling, params)
+		for paginator.HasMorePages() {
+			callCtx, done := startCloudAPICall(ctx, "DescribeAutoScalingGroups", "scaling_groups", len(batch))
+			response, err := paginator.NextPage(callCtx)
+			done(err)
+			if err != nil {
+				return nil, fmt.Errorf("couldn't describe AutoScaling groups: %w", err)
+			}
+
+			for _, group := range response.AutoScalingGroups {
+				result[aws.ToString(group.AutoScalingGroupName)] = group.Instances
+			}
 		}
 	}
 
 	return result, nil
 }
 
-// getInstancesInService returns the list of instances that have LifecycleState == InService.
-func (client *AWSClient) getInstancesInService(insIDtoIP map[string]string) ([]string, error) {
-	const maxItems = 50
-	var result []string
-	keys := reflect.ValueOf(insIDtoIP).MapKeys()
-	instanceIDs := make([]string, len(keys))
+// describeInstances returns the instances with a private IP address that match any of the values of the filter, by
+// their IDs. The values are sent in batches of maxFilterValues, the limit of the EC2 API.
+func (client *AWSClient) describeInstances(ctx context.Context, filter string, values []string) (map[string]Instance, error) {
+	const maxItems = 1000
+	result := make(map[string]Instance)
+
+	for _, batch := range prepareBatches(maxFilterValues, values) {
+		params := &ec2.DescribeInstancesInput{
+			Filters: []types.Filter{
+				{
+					Name:   aws.String(filter),
+					Values: batch,
+				},
+			},
+			MaxResults: aws.Int32(maxItems),
+		}
+		paginator := ec2.NewDescribeInstancesPaginator(client.svcEC2, params)
+		for paginator.HasMorePages() {
+			callCtx, done := startCloudAPICall(ctx, "DescribeInstances", "filter_values", len(batch))
+			response, err := paginator.NextPage(callCtx)
+			done(err)
+			if err != nil {
+				return nil, fmt.Errorf("couldn't describe instances: %w", err)
+			}
 
-	for i := range keys {
-		instanceIDs[i] = keys[i].String()
+			for _, res := range response.Reservations {
+				for _, ins := range res.Instances {
+					if len(ins.NetworkInterfaces) == 0 || ins.NetworkInterfaces[0].PrivateIpAddress == nil {
+						continue
+					}
+					instance := Instance{
+						ID:        aws.ToString(ins.InstanceId),
+						PrivateIP: *ins.NetworkInterfaces[0].PrivateIpAddress,
+					}
+					if ins.Placement != nil {
+						instance.Zone = aws.ToString(ins.Placement.AvailabilityZone)
+					}
+					if ins.LaunchTime != nil {
+						instance.LaunchTime = *ins.LaunchTime
+					}
+					instance.Addresses = instanceAddresses(ins.NetworkInterfaces)
+					instance.Tags = instanceTags(ins.Tags)
+					result[instance.ID] = instance
+				}
+			}
+		}
 	}
 
-	batches := prepareBatches(maxItems, instanceIDs)
-	for _, batch := range batches {
-		params := &autoscaling.DescribeAutoScalingInstancesInput{
-			InstanceIds: batch,
+	return result, nil
+}
+
+// instanceTags returns the tags of an instance or a network interface as a map.
+func instanceTags(tags []types.Tag) map[string]string {
+	result := make(map[string]string, len(tags))
+	for _, tag := range tags {
+		result[aws.ToString(tag.Key)] = aws.ToString(tag.Value)
+	}
+	return result
+}
+
+// instanceAddresses returns the addresses of the network interfaces of an instance, ordered by their device index.
+func instanceAddresses(nics []types.InstanceNetworkInterface) []Address {
+	addresses := make([]Address, 0, len(nics))
+	for _, nic := range nics {
+		if nic.PrivateIpAddress == nil {
+			continue
+		}
+		address := Address{
+			NetworkInterfaceID: aws.ToString(nic.NetworkInterfaceId),
+			PrivateIP:          *nic.PrivateIpAddress,
+			SubnetID:           aws.ToString(nic.SubnetId),
+		}
+		if nic.Attachment != nil {
+			address.DeviceIndex = int(aws.ToInt32(nic.Attachment.DeviceIndex))
+		}
+		if nic.Association != nil {
+			address.PublicIP = aws.ToString(nic.Association.PublicIp)
+		}
+		addresses = append(addresses, address)
+	}
+
+	slices.SortStableFunc(addresses, func(a, b Address) int {
+		return a.DeviceIndex - b.DeviceIndex
+	})
+	return addresses
+}
+
+// addNetworkInterfaceTags adds the tags of the network interfaces to the addresses of the instances. The network
+// interfaces are described in batches of maxFilterValues IDs, because DescribeInstances doesn't return their tags.
+func (client *AWSClient) addNetworkInterfaceTags(ctx context.Context, instances map[string]Instance) error {
+	const maxItems = 1000
+	var nicIDs []string
+	for _, instance := range instances {
+		for _, address := range instance.Addresses {
+			nicIDs = append(nicIDs, address.NetworkInterfaceID)
+		}
+	}
+
+	tags := make(map[string]map[string]string, len(nicIDs))
+	for _, batch := range prepareBatches(maxFilterValues, slices.Sorted(slices.Values(nicIDs))) {
+		params := &ec2.DescribeNetworkInterfacesInput{
+			Filters: []types.Filter{
+				{
+					Name:   aws.String("network-interface-id"),
+					Values: batch,
+				},
+			},
+			MaxResults: aws.Int32(maxItems),
 		}
-		response, err := client.svcAutoscaling.DescribeAutoScalingInstances(context.Background(), params)
+		paginator := ec2.NewDescribeNetworkInterfacesPaginator(client.svcEC2, params)
+		for paginator.HasMorePages() {
+			callCtx, done := startCloudAPICall(ctx, "DescribeNetworkInterfaces", "network_interfaces", len(batch))
+			response, err := paginator.NextPage(callCtx)
+			done(err)
+			if err != nil {
+				return fmt.Errorf("couldn't describe network interfaces: %w", err)
+			}
+
+			for _, nic := range response.NetworkInterfaces {
+				tags[aws.ToString(nic.NetworkInterfaceId)] = instanceTags(nic.TagSet)
+			}
+		}
+	}
+
+	for _, instance := range instances {
+		for i, address := range instance.Addresses {
+			instance.Addresses[i].Tags = tags[address.NetworkInterfaceID]
+		}
+	}
+
+	return nil
+}
+
+// UpstreamDiscovery returns the upstream_discovery config. The default tag prefix is nginx-asg-sync/.
+func (client *AWSClient) UpstreamDiscovery() *upstreamDiscoveryConfig {
+	return client.config.UpstreamDiscovery.withDefaults("nginx-asg-sync/")
+}
+
+// GetTaggedScalingGroups returns the tags of the Auto Scaling groups that have the tag key, by the names of the
+// groups. The groups are found with the tag-key filter of DescribeAutoScalingGroups.
+func (client *AWSClient) GetTaggedScalingGroups(ctx context.Context, key string) (map[string]map[string]string, error) {
+	const maxGroups = 100
+	params := &autoscaling.DescribeAutoScalingGroupsInput{
+		Filters: []autoscalingtypes.Filter{
+			{
+				Name:   aws.String("tag-key"),
+				Values: []string{key},
+			},
+		},
+		IncludeInstances: aws.Bool(false),
+		MaxRecords:       aws.Int32(maxGroups),
+	}
+
+	result := make(map[string]map[string]string)
+	paginator := autoscaling.NewDescribeAutoScalingGroupsPaginator(client.svcAutoscaling, params)
+	for paginator.HasMorePages() {
+		callCtx, done := startCloudAPICall(ctx, "DescribeAutoScalingGroups", "tag", key)
+		response, err := paginator.NextPage(callCtx)
+		done(err)
 		if err != nil {
-			return nil, fmt.Errorf("couldn't describe AutoScaling instances: %w", err)
+			return nil, fmt.Errorf("couldn't describe the tagged AutoScaling groups: %w", err)
 		}
 
-		for _, ins := range response.AutoScalingInstances {
-			if *ins.LifecycleState == "InService" {
-				result = append(result, insIDtoIP[*ins.InstanceId])
+		for _, group := range response.AutoScalingGroups {
+			tags := make(map[string]string, len(group.Tags))
+			for _, tag := range group.Tags {
+				tags[aws.ToString(tag.Key)] = aws.ToString(tag.Value)
 			}
+			result[aws.ToString(group.AutoScalingGroupName)] = tags
 		}
 	}
 
 	return result, nil
 }
 
+// GetLocalZone returns the Availability Zone of the instance nginx-asg-sync runs on, using the EC2 Metadata service.
+func (client *AWSClient) GetLocalZone(ctx context.Context) (string, error) {
+	callCtx, done := startCloudAPICall(ctx, "GetMetadata", "path", "placement/availability-zone")
+	response, err := client.imdsClient.GetMetadata(callCtx, &imds.GetMetadataInput{Path: "placement/availability-zone"})
+	done(err)
+	if err != nil {
+		return "", fmt.Errorf("unable to retrieve availability zone from ec2metadata: %w", err)
+	}
+	defer response.Content.Close()
+
+	zone, err := io.ReadAll(response.Content)
+	if err != nil {
+		return "", fmt.Errorf("unable to read availability zone from ec2metadata: %w", err)
+	}
+
+	return strings.TrimSpace(string(zone)), nil
+}
+
+// ReportUnhealthyInstance sets the health status of the instance to Unhealthy, so that the Auto Scaling group replaces
+// it. The health check grace period of the group is respected.
+func (client *AWSClient) ReportUnhealthyInstance(ctx context.Context, _ string, id string) error {
+	params := &autoscaling.SetInstanceHealthInput{
+		InstanceId:               aws.String(id),
+		HealthStatus:             aws.String("Unhealthy"),
+		ShouldRespectGracePeriod: aws.Bool(true),
+	}
+
+	callCtx, done := startCloudAPICall(ctx, "SetInstanceHealth", "instance_id", id)
+	_, err := client.svcAutoscaling.SetInstanceHealth(callCtx, params)
+	done(err)
+	if err != nil {
+		return fmt.Errorf("couldn't set the health of the instance %v: %w", id, err)
+	}
+
+	return nil
+}
+
 func prepareBatches(maxItems int, items []string) [][]string {
 	totalBatches := (len(items) + maxItems - 1) / maxItems
 	batches := make([][]string, 0, totalBatches)
@@ -239,58 +582,122 @@ func prepareBatches(maxItems int, items []string) [][]string {
 
 // Configuration for AWS Cloud Provider.
 type awsConfig struct {
-	Region    string        `yaml:"region"`
-	Profile   string        `yaml:"profile"`
-	Upstreams []awsUpstream `yaml:"upstreams"`
+	UpstreamDiscovery *upstreamDiscoveryConfig `yaml:"upstream_discovery"`
+	Region            string                   `yaml:"region"`
+	Profile           string                   `yaml:"profile"`
+	Upstreams         []awsUpstream            `yaml:"upstreams"`
 }
 
 type awsUpstream struct {
-	Name             string `yaml:"name"`
-	AutoscalingGroup string `yaml:"autoscaling_group"`
-	Kind             string `yaml:"kind"`
-	FailTimeout      string `yaml:"fail_timeout"`
-	SlowStart        string `yaml:"slow_start"`
-	Port             int    `yaml:"port"`
-	MaxConns         int    `yaml:"max_conns"`
-	MaxFails         int    `yaml:"max_fails"`
-	InService        bool   `yaml:"in_service"`
+	ZoneAwareness          *zoneAwarenessConfig     `yaml:"zone_awareness"`
+	HealthProbe            *healthProbeConfig       `yaml:"health_probe"`
+	UnhealthyFeedback      *unhealthyFeedbackConfig `yaml:"unhealthy_feedback"`
+	AddressSelection       *addressSelectionConfig  `yaml:"address_selection"`
+	InstanceTags           *instanceTagsConfig      `yaml:"instance_tags"`
+	Name                   string                   `yaml:"name"`
+	AutoscalingGroup       string                   `yaml:"autoscaling_group"`
+	BackupAutoscalingGroup string                   `yaml:"backup_autoscaling_group"`
+	Kind                   string                   `yaml:"kind"`
+	LoadBalancingMethod    string                   `yaml:"load_balancing_method"`
+	FailTimeout            string                   `yaml:"fail_timeout"`
+	SlowStart              string                   `yaml:"slow_start"`
+	DrainTimeout           time.Duration            `yaml:"drain_timeout"`
+	MinInstanceAge         time.Duration            `yaml:"min_instance_age"`
+	Port                   int                      `yaml:"port"`
+	MaxConns               int                      `yaml:"max_conns"`
+	MaxFails               int                      `yaml:"max_fails"`
+	InService              bool                     `yaml:"in_service"`
 }
 
 func validateAWSConfig(cfg *awsConfig) error {
+	var errs []error
+
 	if cfg.Region == "" {
-		return fmt.Errorf(errorMsgFormat, "region")
+		errs = append(errs, newConfigError("region", errorMsgFormat, "region"))
 	}
 
-	if len(cfg.Upstreams) == 0 {
-		return errors.New("there are no upstreams found in the config file")
+	if len(cfg.Upstreams) == 0 && cfg.UpstreamDiscovery == nil {
+		errs = append(errs, newConfigError("upstreams", upstreamsErrorMsg))
 	}
 
-	for _, ups := range cfg.Upstreams {
+	if cfg.UpstreamDiscovery != nil {
+		if err := validateUpstreamDiscoveryConfig(cfg.UpstreamDiscovery); err != nil {
+			errs = append(errs, &configError{field: "upstream_discovery", err: err})
+		}
+	}
+
+	seen := make(map[upstreamKey]bool, len(cfg.Upstreams))
+	for i, ups := range cfg.Upstreams {
 		if ups.Name == "" {
-			return errors.New(upstreamNameErrorMsg)
+			errs = append(errs, newConfigError(upstreamField(i, "name"), upstreamNameErrorMsg))
+		}
+		key := upstreamKey{name: ups.Name, kind: ups.Kind}
+		if ups.Name != "" && seen[key] {
+			errs = append(errs, newConfigError(upstreamField(i, "name"), upstreamDuplicateErrorMsgFmt, ups.Name, ups.Kind))
 		}
+		seen[key] = true
 		if ups.AutoscalingGroup == "" {
-			return fmt.Errorf(upstreamErrorMsgFormat, "autoscaling_group", ups.Name)
+			errs = append(errs, newConfigError(upstreamField(i, "autoscaling_group"), upstreamErrorMsgFormat, "autoscaling_group", ups.Name))
 		}
 		if ups.Port == 0 {
-			return fmt.Errorf(upstreamPortErrorMsgFormat, ups.Name)
+			errs = append(errs, newConfigError(upstreamField(i, "port"), upstreamPortErrorMsgFormat, ups.Name))
 		}
 		if ups.Kind == "" || (ups.Kind != "http" && ups.Kind != "stream") {
-			return fmt.Errorf(upstreamKindErrorMsgFormat, ups.Name)
+			errs = append(errs, newConfigError(upstreamField(i, "kind"), upstreamKindErrorMsgFormat, ups.Name))
 		}
 		if ups.MaxConns < 0 {
-			return fmt.Errorf(upstreamMaxConnsErrorMsgFmt, ups.MaxConns)
+			errs = append(errs, newConfigError(upstreamField(i, "max_conns"), upstreamMaxConnsErrorMsgFmt, ups.MaxConns))
 		}
 		if ups.MaxFails < 0 {
-			return fmt.Errorf(upstreamMaxFailsErrorMsgFmt, ups.MaxFails)
+			errs = append(errs, newConfigError(upstreamField(i, "max_fails"), upstreamMaxFailsErrorMsgFmt, ups.MaxFails))
 		}
 		if !isValidTime(ups.FailTimeout) {
-			return fmt.Errorf(upstreamFailTimeoutErrorMsgFmt, ups.FailTimeout)
+			errs = append(errs, newConfigError(upstreamField(i, "fail_timeout"), upstreamFailTimeoutErrorMsgFmt, ups.FailTimeout))
 		}
 		if !isValidTime(ups.SlowStart) {
-			return fmt.Errorf(upstreamSlowStartErrorMsgFmt, ups.SlowStart)
+			errs = append(errs, newConfigError(upstreamField(i, "slow_start"), upstreamSlowStartErrorMsgFmt, ups.SlowStart))
+		}
+		if !isValidLoadBalancingMethod(ups.Kind, ups.LoadBalancingMethod) {
+			errs = append(errs, newConfigError(upstreamField(i, "load_balancing_method"), upstreamLBMethodErrorMsgFmt, ups.LoadBalancingMethod, ups.Name))
+		}
+		if ups.BackupAutoscalingGroup != "" && !allowsBackupServers(ups.LoadBalancingMethod) {
+			errs = append(errs, newConfigError(upstreamField(i, "load_balancing_method"), upstreamBackupErrorMsgFmt, ups.LoadBalancingMethod, ups.Name))
+		}
+		if err := validateDrainTimeout(ups.DrainTimeout, ups.Kind, ups.Name); err != nil {
+			errs = append(errs, &configError{field: upstreamField(i, "drain_timeout"), err: err})
+		}
+		if ups.MinInstanceAge < 0 {
+			errs = append(errs, newConfigError(upstreamField(i, "min_instance_age"), upstreamMinInstanceAgeErrorMsgFmt, ups.MinInstanceAge, ups.Name))
+		}
+		if ups.ZoneAwareness != nil {
+			if err := validateZoneAwarenessConfig(ups.ZoneAwareness, ups.Name, ups.LoadBalancingMethod); err != nil {
+				errs = append(errs, &configError{field: upstreamField(i, "zone_awareness"), err: err})
+			}
+		}
+		if ups.HealthProbe != nil {
+			if err := validateHealthProbeConfig(ups.HealthProbe, ups.Name); err != nil {
+				errs = append(errs, &configError{field: upstreamField(i, "health_probe"), err: err})
+			}
+		}
+		if ups.UnhealthyFeedback != nil {
+			if err := validateUnhealthyFeedbackConfig(ups.UnhealthyFeedback, ups.Name); err != nil {
+				errs = append(errs, &configError{field: upstreamField(i, "unhealthy_feedback"), err: err})
+			}
+		}
+		if ups.InstanceTags != nil {
+			if err := validateInstanceTagsConfig(ups.InstanceTags, ups.Name); err != nil {
+				errs = append(errs, &configError{field: upstreamField(i, "instance_tags"), err: err})
+			}
+		}
+		if ups.AddressSelection != nil {
+			if err := validateAddressSelectionConfig(ups.AddressSelection, ups.Name); err != nil {
+				errs = append(errs, &configError{field: upstreamField(i, "address_selection"), err: err})
+			}
+			if ups.AddressSelection.IPConfiguration != "" {
+				errs = append(errs, newConfigError(upstreamField(i, "address_selection"), upstreamAddressSelectionErrorMsgFmt, "ip_configuration", ups.Name))
+			}
 		}
 	}
 
-	return nil
+	return errors.Join(errs...)
 }
@@ -1,7 +1,16 @@
 package main
 
 import (
+	"maps"
+	"reflect"
+	"slices"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	autoscalingtypes "github.com/aws/aws-sdk-go-v2/service/autoscaling/types"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
 )
 
 type testInputAWS struct {
@@ -29,7 +38,7 @@ func getValidAWSConfig() *awsConfig {
 }
 
 func getInvalidAWSConfigInput() []*testInputAWS {
-	input := make([]*testInputAWS, 0, 10)
+	input := make([]*testInputAWS, 0, 17)
 
 	invalidRegionCfg := getValidAWSConfig()
 	invalidRegionCfg.Region = ""
@@ -71,6 +80,52 @@ func getInvalidAWSConfigInput() []*testInputAWS {
 	invalidUpstreamSlowStartCfg.Upstreams[0].SlowStart = "-10s"
 	input = append(input, &testInputAWS{invalidUpstreamSlowStartCfg, "invalid slow_start of the upstream"})
 
+	invalidUpstreamLBMethodCfg := getValidAWSConfig()
+	invalidUpstreamLBMethodCfg.Upstreams[0].LoadBalancingMethod = "fastest"
+	input = append(input, &testInputAWS{invalidUpstreamLBMethodCfg, "invalid load_balancing_method of the upstream"})
+
+	invalidUpstreamBackupCfg := getValidAWSConfig()
+	invalidUpstreamBackupCfg.Upstreams[0].BackupAutoscalingGroup = "backup-group"
+	invalidUpstreamBackupCfg.Upstreams[0].LoadBalancingMethod = "hash"
+	input = append(input, &testInputAWS{invalidUpstreamBackupCfg, "backup_autoscaling_group with the hash load balancing method"})
+
+	invalidUpstreamDrainTimeoutCfg := getValidAWSConfig()
+	invalidUpstreamDrainTimeoutCfg.Upstreams[0].DrainTimeout = -10 * time.Second
+	input = append(input, &testInputAWS{invalidUpstreamDrainTimeoutCfg, "invalid drain_timeout of the upstream"})
+
+	invalidStreamDrainTimeoutCfg := getValidAWSConfig()
+	invalidStreamDrainTimeoutCfg.Upstreams[0].Kind = "stream"
+	invalidStreamDrainTimeoutCfg.Upstreams[0].DrainTimeout = 10 * time.Second
+	input = append(input, &testInputAWS{invalidStreamDrainTimeoutCfg, "drain_timeout of a stream upstream"})
+
+	invalidUpstreamMinInstanceAgeCfg := getValidAWSConfig()
+	invalidUpstreamMinInstanceAgeCfg.Upstreams[0].MinInstanceAge = -time.Minute
+	input = append(input, &testInputAWS{invalidUpstreamMinInstanceAgeCfg, "invalid min_instance_age of the upstream"})
+
+	invalidUpstreamHealthProbeCfg := getValidAWSConfig()
+	invalidUpstreamHealthProbeCfg.Upstreams[0].HealthProbe = &healthProbeConfig{Type: "udp"}
+	input = append(input, &testInputAWS{invalidUpstreamHealthProbeCfg, "invalid health_probe of the upstream"})
+
+	invalidUpstreamDiscoveryCfg := getValidAWSConfig()
+	invalidUpstreamDiscoveryCfg.UpstreamDiscovery = &upstreamDiscoveryConfig{Kind: "grpc"}
+	input = append(input, &testInputAWS{invalidUpstreamDiscoveryCfg, "invalid kind of upstream_discovery"})
+
+	invalidUpstreamInstanceTagsCfg := getValidAWSConfig()
+	invalidUpstreamInstanceTagsCfg.Upstreams[0].InstanceTags = &instanceTagsConfig{}
+	input = append(input, &testInputAWS{invalidUpstreamInstanceTagsCfg, "invalid instance_tags of the upstream"})
+
+	invalidUpstreamAddressSelectionCfg := getValidAWSConfig()
+	invalidUpstreamAddressSelectionCfg.Upstreams[0].AddressSelection = &addressSelectionConfig{SubnetCIDR: "10.0.0.0"}
+	input = append(input, &testInputAWS{invalidUpstreamAddressSelectionCfg, "invalid address_selection of the upstream"})
+
+	unsupportedUpstreamAddressSelectionCfg := getValidAWSConfig()
+	unsupportedUpstreamAddressSelectionCfg.Upstreams[0].AddressSelection = &addressSelectionConfig{IPConfiguration: "ipconfig1"}
+	input = append(input, &testInputAWS{unsupportedUpstreamAddressSelectionCfg, "unsupported address_selection of the upstream"})
+
+	duplicateUpstreamCfg := getValidAWSConfig()
+	duplicateUpstreamCfg.Upstreams = append(duplicateUpstreamCfg.Upstreams, duplicateUpstreamCfg.Upstreams[0])
+	input = append(input, &testInputAWS{duplicateUpstreamCfg, "duplicate upstreams"})
+
 	return input
 }
 
@@ -96,6 +151,18 @@ func TestValidateAWSConfigValid(t *testing.T) {
 	}
 }
 
+func TestValidateAWSConfigValidUpstreamDiscovery(t *testing.T) {
+	t.Parallel()
+	cfg := getValidAWSConfig()
+	cfg.Upstreams = nil
+	cfg.UpstreamDiscovery = &upstreamDiscoveryConfig{}
+
+	err := validateAWSConfig(cfg)
+	if err != nil {
+		t.Errorf("validateAWSConfig() failed for the config with upstream_discovery and no upstreams: %v", err)
+	}
+}
+
 func TestGetUpstreamsAWS(t *testing.T) {
 	t.Parallel()
 	cfg := getValidAWSConfig()
@@ -188,3 +255,119 @@ func TestPrepareBatches(t *testing.T) {
 		}
 	}
 }
+
+func TestInstanceAddresses(t *testing.T) {
+	t.Parallel()
+	nics := []types.InstanceNetworkInterface{
+		{
+			NetworkInterfaceId: aws.String("eni-data"),
+			PrivateIpAddress:   aws.String("10.0.1.1"),
+			SubnetId:           aws.String("subnet-data"),
+			Attachment:         &types.InstanceNetworkInterfaceAttachment{DeviceIndex: aws.Int32(1)},
+		},
+		{
+			NetworkInterfaceId: aws.String("eni-primary"),
+			PrivateIpAddress:   aws.String("10.0.0.1"),
+			SubnetId:           aws.String("subnet-primary"),
+			Attachment:         &types.InstanceNetworkInterfaceAttachment{DeviceIndex: aws.Int32(0)},
+			Association:        &types.InstanceNetworkInterfaceAssociation{PublicIp: aws.String("203.0.113.1")},
+		},
+		{
+			// no private IP address
+			NetworkInterfaceId: aws.String("eni-detached"),
+		},
+	}
+
+	expected := []Address{
+		{NetworkInterfaceID: "eni-primary", PrivateIP: "10.0.0.1", PublicIP: "203.0.113.1", SubnetID: "subnet-primary"},
+		{NetworkInterfaceID: "eni-data", PrivateIP: "10.0.1.1", SubnetID: "subnet-data", DeviceIndex: 1},
+	}
+	if addresses := instanceAddresses(nics); !reflect.DeepEqual(addresses, expected) {
+		t.Errorf("instanceAddresses() returned %+v, expected %+v", addresses, expected)
+	}
+}
+
+func TestInstancesByScalingGroup(t *testing.T) {
+	t.Parallel()
+	asgInstance := func(id string, state autoscalingtypes.LifecycleState, health string) autoscalingtypes.Instance {
+		return autoscalingtypes.Instance{InstanceId: aws.String(id), LifecycleState: state, HealthStatus: aws.String(health)}
+	}
+	groups := map[string][]autoscalingtypes.Instance{
+		"backend-blue": {asgInstance("i-1", autoscalingtypes.LifecycleStateInService, "Healthy")},
+		"backend-green": {
+			asgInstance("i-4", autoscalingtypes.LifecycleStatePending, "Healthy"),
+			asgInstance("i-2", autoscalingtypes.LifecycleStateInService, "Healthy"),
+			asgInstance("i-5", autoscalingtypes.LifecycleStateInService, "Unhealthy"),
+		},
+		"frontend": {
+			asgInstance("i-3", autoscalingtypes.LifecycleStateInService, "Healthy"),
+			asgInstance("i-6", autoscalingtypes.LifecycleStateInService, "Healthy"),
+		},
+		"unhealthy": {asgInstance("i-7", autoscalingtypes.LifecycleStateInService, "Unhealthy")},
+		"empty":     {},
+	}
+	// i-6 has no private IP address, so it isn't described
+	instances := make(map[string]Instance)
+	for _, id := range []string{"i-1", "i-2", "i-3", "i-4", "i-5", "i-7"} {
+		instances[id] = Instance{ID: id, PrivateIP: "10.0.0." + strings.TrimPrefix(id, "i-")}
+	}
+
+	result := instancesByScalingGroup(
+		[]string{"backend-*", "backend-gr??n", "empty", "frontend", "missing", "missing-*", "unhealthy"},
+		groups, instances, map[string]bool{"backend-gr??n": true},
+	)
+
+	expected := map[string][]string{
+		"backend-*":     {"i-1", "i-2", "i-4"},
+		"backend-gr??n": {"i-2"},
+		"frontend":      {"i-3"},
+		"unhealthy":     {},
+	}
+	if len(result) != len(expected) {
+		t.Errorf("instancesByScalingGroup() returned the groups %v, expected %v", slices.Sorted(maps.Keys(result)), slices.Sorted(maps.Keys(expected)))
+	}
+	for name, ids := range expected {
+		got := make([]string, 0, len(result[name]))
+		for _, ins := range result[name] {
+			got = append(got, ins.ID)
+		}
+		if !reflect.DeepEqual(got, ids) {
+			t.Errorf("instancesByScalingGroup() returned the instances %v for %v, expected %v", got, name, ids)
+		}
+	}
+}
+
+func TestInstancesByScalingGroupWithoutInstances(t *testing.T) {
+	t.Parallel()
+	// a group without instances is left out, so that it is reported as a group that doesn't exist, as the discovery of
+	// a single group with DescribeInstances did
+	groups := map[string][]autoscalingtypes.Instance{"empty": nil, "empty-too": {}}
+	result := instancesByScalingGroup([]string{"empty", "empty-*"}, groups, nil, nil)
+	if len(result) != 0 {
+		t.Errorf("instancesByScalingGroup() returned the instances %v of the groups without instances", result)
+	}
+}
+
+func TestGroupNamePattern(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name  string
+		group string
+		match bool
+	}{
+		{name: "backend", group: "backend", match: true},
+		{name: "backend", group: "backend-1", match: false},
+		{name: "backend-*", group: "backend-", match: true},
+		{name: "backend-*", group: "backend-blue/1", match: true},
+		{name: "backend-?", group: "backend-12", match: false},
+		{name: "backend.*", group: "backend-1", match: false},
+		{name: `backend-\*`, group: "backend-*", match: true},
+		{name: `backend-\*`, group: "backend-1", match: false},
+	}
+
+	for _, test := range tests {
+		if match := groupNamePattern(test.name).MatchString(test.group); match != test.match {
+			t.Errorf("groupNamePattern(%q) matched %q: %v, expected %v", test.name, test.group, match, test.match)
+		}
+	}
+}
@@ -4,24 +4,36 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"log"
+	"io"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
 
 	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
 	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute/v8"
 	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/network/armnetwork/v9"
 
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
 
 	yaml "gopkg.in/yaml.v3"
 )
 
+const azureIMDSZoneURL = "http://169.254.169.254/metadata/instance/compute/zone?api-version=2021-02-01&format=text"
+
 type VMSSClient interface {
+	NewListPager(rg string, opts *armcompute.VirtualMachineScaleSetsClientListOptions) *runtime.Pager[armcompute.VirtualMachineScaleSetsClientListResponse]
 	Get(ctx context.Context, rg, name string, opts *armcompute.VirtualMachineScaleSetsClientGetOptions) (armcompute.VirtualMachineScaleSetsClientGetResponse, error)
 }
 
 type VMSSVMsClient interface {
 	NewListPager(rg, name string, opts *armcompute.VirtualMachineScaleSetVMsClientListOptions) *runtime.Pager[armcompute.VirtualMachineScaleSetVMsClientListResponse]
+	Get(ctx context.Context, rg, name, instanceID string, opts *armcompute.VirtualMachineScaleSetVMsClientGetOptions) (armcompute.VirtualMachineScaleSetVMsClientGetResponse, error)
+	BeginDelete(ctx context.Context, rg, name, instanceID string, opts *armcompute.VirtualMachineScaleSetVMsClientBeginDeleteOptions) (*runtime.Poller[armcompute.VirtualMachineScaleSetVMsClientDeleteResponse], error)
 }
 
 type VMsClient interface {
@@ -33,6 +45,11 @@ type InterfacesClient interface {
 	Get(ctx context.Context, rg, name string, opts *armnetwork.InterfacesClientGetOptions) (armnetwork.InterfacesClientGetResponse, error)
 }
 
+type PublicIPAddressesClient interface {
+	NewListVirtualMachineScaleSetPublicIPAddressesPager(rg, vmss string, opts *armnetwork.PublicIPAddressesClientListVirtualMachineScaleSetPublicIPAddressesOptions) *runtime.Pager[armnetwork.PublicIPAddressesClientListVirtualMachineScaleSetPublicIPAddressesResponse]
+	Get(ctx context.Context, rg, name string, opts *armnetwork.PublicIPAddressesClientGetOptions) (armnetwork.PublicIPAddressesClientGetResponse, error)
+}
+
 // AzureClient allows you to get the list of IP addresses of VirtualMachines of a VirtualMachine Scale Set. It implements the CloudProvider interface.
 type AzureClient struct {
 	config                 *azureConfig
@@ -40,6 +57,8 @@ type AzureClient struct {
 	vmssVMClient           VMSSVMsClient
 	individualvmssVMClient VMsClient
 	iFaceClient            InterfacesClient
+	publicIPClient         PublicIPAddressesClient
+	upstreamSource         func() []Upstream
 }
 
 // NewAzureClient creates an AzureClient.
@@ -80,7 +99,9 @@ func (client *AzureClient) listScaleSetsNetworkInterfaces(ctx context.Context, r
 	var result []*armnetwork.Interface
 	pager := client.iFaceClient.NewListVirtualMachineScaleSetNetworkInterfacesPager(resourceGroupName, vmssName, nil)
 	for pager.More() {
-		resp, err := pager.NextPage(ctx)
+		callCtx, done := startCloudAPICall(ctx, "ListVirtualMachineScaleSetNetworkInterfaces", "scaling_group", vmssName)
+		resp, err := pager.NextPage(callCtx)
+		done(err)
 		if err != nil {
 			return nil, fmt.Errorf("listing network interfaces: %w", err)
 		}
@@ -127,13 +148,15 @@ func (client *AzureClient) getInterfacesFromIndividualVMs(ctx context.Context, v
 
 // getNetworkInterfacesForVM retrieves network interfaces for a single VM.
 func (client *AzureClient) getNetworkInterfacesForVM(ctx context.Context, vmName string) ([]*armnetwork.Interface, error) {
-	vmDetails, err := client.individualvmssVMClient.Get(ctx, client.config.ResourceGroupName, vmName, nil)
+	callCtx, done := startCloudAPICall(ctx, "GetVirtualMachine", "vm", vmName)
+	vmDetails, err := client.individualvmssVMClient.Get(callCtx, client.config.ResourceGroupName, vmName, nil)
+	done(err)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get VM details: %w", err)
 	}
 
 	if vmDetails.Properties == nil || vmDetails.Properties.NetworkProfile == nil || vmDetails.Properties.NetworkProfile.NetworkInterfaces == nil {
-		log.Printf("VM %s has no network interfaces", vmName)
+		slog.Info("VM has no network interfaces", "vm", vmName)
 		return nil, nil // VM has no network interfaces
 	}
 
@@ -149,7 +172,9 @@ func (client *AzureClient) getNetworkInterfacesForVM(ctx context.Context, vmName
 			return nil, fmt.Errorf("invalid NIC ID format: %w", err)
 		}
 
-		nic, err := client.iFaceClient.Get(ctx, client.config.ResourceGroupName, rID.Name, nil)
+		callCtx, done := startCloudAPICall(ctx, "GetNetworkInterface", "vm", vmName, "network_interface", rID.Name)
+		nic, err := client.iFaceClient.Get(callCtx, client.config.ResourceGroupName, rID.Name, nil)
+		done(err)
 		if err != nil {
 			return nil, fmt.Errorf("failed to get network interface %s: %w", rID.Name, err)
 		}
@@ -160,17 +185,17 @@ func (client *AzureClient) getNetworkInterfacesForVM(ctx context.Context, vmName
 	return interfaces, nil
 }
 
-// GetPrivateIPsForScalingGroup returns the list of IP addresses of instances of the Virtual Machine Scale Set.
-func (client *AzureClient) GetPrivateIPsForScalingGroup(name string) ([]string, error) {
-	ctx := context.TODO()
-
+// GetInstancesForScalingGroup returns the list of instances of the Virtual Machine Scale Set.
+func (client *AzureClient) GetInstancesForScalingGroup(ctx context.Context, name string) ([]Instance, error) {
 	// Validate input
 	if name == "" {
 		return nil, errors.New("VMSS name cannot be empty")
 	}
 
 	// Get scale set details to determine orchestration mode
-	vmss, err := client.vMSSClient.Get(ctx, client.config.ResourceGroupName, name, nil)
+	callCtx, done := startCloudAPICall(ctx, "GetVirtualMachineScaleSet", "scaling_group", name)
+	vmss, err := client.vMSSClient.Get(callCtx, client.config.ResourceGroupName, name, nil)
+	done(err)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get scale set %s: %w", name, err)
 	}
@@ -188,34 +213,51 @@ func (client *AzureClient) GetPrivateIPsForScalingGroup(name string) ([]string,
 	// Route to appropriate handler based on orchestration mode
 	switch orchestrationMode {
 	case armcompute.OrchestrationModeUniform:
-		return client.getIPsFromUniformVMSS(ctx, name)
+		return client.getInstancesFromUniformVMSS(ctx, name)
 	case armcompute.OrchestrationModeFlexible:
-		return client.getIPsFromFlexibleVMSS(ctx, name)
+		return client.getInstancesFromFlexibleVMSS(ctx, name)
 	default:
 		return nil, fmt.Errorf("unsupported orchestration mode: %s", orchestrationMode)
 	}
 }
 
-// getIPsFromUniformVMSS handles uniform orchestration mode using scale set level APIs.
-func (client *AzureClient) getIPsFromUniformVMSS(ctx context.Context, name string) ([]string, error) {
+// getInstancesFromUniformVMSS handles uniform orchestration mode using scale set level APIs.
+// The VMs are only listed when an upstream needs their details, such as the availability zone.
+func (client *AzureClient) getInstancesFromUniformVMSS(ctx context.Context, name string) ([]Instance, error) {
 	interfaces, err := client.listScaleSetsNetworkInterfaces(ctx, client.config.ResourceGroupName, name)
 	if err != nil {
 		return nil, fmt.Errorf("failed to list network interfaces for uniform VMSS: %w", err)
 	}
 
-	return client.extractPrivateIPsFromInterfaces(interfaces)
+	var vmList []*armcompute.VirtualMachineScaleSetVM
+	if vmDetailsRequired(client.syncedUpstreams()) {
+		vmList, err = client.listVMsInScaleSet(ctx, name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list VMs in uniform VMSS: %w", err)
+		}
+	}
+
+	var publicIPs map[string]string
+	if isPublicIPSelected(client.syncedUpstreams()) {
+		publicIPs, err = client.listScaleSetPublicIPAddresses(ctx, name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list public IP addresses for uniform VMSS: %w", err)
+		}
+	}
+
+	return instancesFromInterfaces(interfaces, vmList, publicIPs), nil
 }
 
-// getIPsFromFlexibleVMSS handles flexible orchestration mode using individual VM APIs.
-func (client *AzureClient) getIPsFromFlexibleVMSS(ctx context.Context, name string) ([]string, error) {
+// getInstancesFromFlexibleVMSS handles flexible orchestration mode using individual VM APIs.
+func (client *AzureClient) getInstancesFromFlexibleVMSS(ctx context.Context, name string) ([]Instance, error) {
 	vmList, err := client.listVMsInScaleSet(ctx, name)
 	if err != nil {
 		return nil, fmt.Errorf("failed to list VMs in flexible VMSS: %w", err)
 	}
 
 	if len(vmList) == 0 {
-		log.Printf("Scale set %s has no VMs", name)
-		return []string{}, nil // Empty scale set
+		slog.Info("Scale set has no VMs", "scaling_group", name)
+		return []Instance{}, nil // Empty scale set
 	}
 
 	interfaces, err := client.getInterfacesFromIndividualVMs(ctx, vmList)
@@ -223,7 +265,73 @@ func (client *AzureClient) getIPsFromFlexibleVMSS(ctx context.Context, name stri
 		return nil, fmt.Errorf("failed to get network interfaces from VMs: %w", err)
 	}
 
-	return client.extractPrivateIPsFromInterfaces(interfaces)
+	var publicIPs map[string]string
+	if isPublicIPSelected(client.syncedUpstreams()) {
+		publicIPs, err = client.getPublicIPAddresses(ctx, interfaces)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get public IP addresses of VMs: %w", err)
+		}
+	}
+
+	return instancesFromInterfaces(interfaces, vmList, publicIPs), nil
+}
+
+// listScaleSetPublicIPAddresses returns the public IP addresses of the VMs of a scale set in uniform orchestration mode
+// by the lowercase IDs of their resources.
+func (client *AzureClient) listScaleSetPublicIPAddresses(ctx context.Context, name string) (map[string]string, error) {
+	result := make(map[string]string)
+	pager := client.publicIPClient.NewListVirtualMachineScaleSetPublicIPAddressesPager(client.config.ResourceGroupName, name, nil)
+	for pager.More() {
+		callCtx, done := startCloudAPICall(ctx, "ListVirtualMachineScaleSetPublicIPAddresses", "scaling_group", name)
+		resp, err := pager.NextPage(callCtx)
+		done(err)
+		if err != nil {
+			return nil, fmt.Errorf("listing public IP addresses: %w", err)
+		}
+		for _, pip := range resp.Value {
+			if pip.ID != nil && pip.Properties != nil && pip.Properties.IPAddress != nil {
+				result[strings.ToLower(*pip.ID)] = *pip.Properties.IPAddress
+			}
+		}
+	}
+	return result, nil
+}
+
+// getPublicIPAddresses gets the public IP addresses that the IP configurations of the network interfaces reference
+// one by one, and returns them by the lowercase IDs of their resources.
+func (client *AzureClient) getPublicIPAddresses(ctx context.Context, interfaces []*armnetwork.Interface) (map[string]string, error) {
+	result := make(map[string]string)
+	for _, iface := range interfaces {
+		if iface.Properties == nil {
+			continue
+		}
+		for _, ipConfig := range iface.Properties.IPConfigurations {
+			if ipConfig.Properties == nil || ipConfig.Properties.PublicIPAddress == nil || ipConfig.Properties.PublicIPAddress.ID == nil {
+				continue
+			}
+			id := *ipConfig.Properties.PublicIPAddress.ID
+			if _, ok := result[strings.ToLower(id)]; ok {
+				continue
+			}
+
+			rID, err := arm.ParseResourceID(id)
+			if err != nil {
+				return nil, fmt.Errorf("invalid public IP address ID format: %w", err)
+			}
+
+			callCtx, done := startCloudAPICall(ctx, "GetPublicIPAddress", "public_ip_address", rID.Name)
+			pip, err := client.publicIPClient.Get(callCtx, rID.ResourceGroupName, rID.Name, nil)
+			done(err)
+			if err != nil {
+				return nil, fmt.Errorf("failed to get public IP address %s: %w", rID.Name, err)
+			}
+
+			if pip.Properties != nil && pip.Properties.IPAddress != nil {
+				result[strings.ToLower(id)] = *pip.Properties.IPAddress
+			}
+		}
+	}
+	return result, nil
 }
 
 // listVMsInScaleSet lists all VMs in a scale set.
@@ -232,7 +340,9 @@ func (client *AzureClient) listVMsInScaleSet(ctx context.Context, name string) (
 
 	pager := client.vmssVMClient.NewListPager(client.config.ResourceGroupName, name, nil)
 	for pager.More() {
-		resp, err := pager.NextPage(ctx)
+		callCtx, done := startCloudAPICall(ctx, "ListVirtualMachineScaleSetVMs", "scaling_group", name)
+		resp, err := pager.NextPage(callCtx)
+		done(err)
 		if err != nil {
 			return nil, fmt.Errorf("failed to list VMs: %w", err)
 		}
@@ -242,27 +352,134 @@ func (client *AzureClient) listVMsInScaleSet(ctx context.Context, name string) (
 	return vmList, nil
 }
 
-// extractPrivateIPsFromInterfaces extracts private IP addresses from a list of network interfaces.
-func (client *AzureClient) extractPrivateIPsFromInterfaces(interfaces []*armnetwork.Interface) ([]string, error) {
+// vmDetailsRequired reports whether any upstream needs details of the VMs that network interfaces don't provide, such
+// as the availability zone, the creation time or the tags.
+func vmDetailsRequired(upstreams []Upstream) bool {
+	for _, ups := range upstreams {
+		if ups.ZoneAwareness != nil || ups.MinInstanceAge > 0 || ups.InstanceTags != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// instancesFromInterfaces returns an instance for the primary private IP address of every network interface attached
+// to a VM, with the addresses of all the IP configurations of the interface. The details of the instance, such as the
+// availability zone, are taken from the VM of vmList it belongs to. The public IP addresses are taken from publicIPs by
+// the lowercase IDs of their resources.
+func instancesFromInterfaces(interfaces []*armnetwork.Interface, vmList []*armcompute.VirtualMachineScaleSetVM, publicIPs map[string]string) []Instance {
 	if len(interfaces) == 0 {
-		return []string{}, nil
+		return []Instance{}
+	}
+
+	vmsByID := make(map[string]*armcompute.VirtualMachineScaleSetVM, len(vmList))
+	vmsByName := make(map[string]*armcompute.VirtualMachineScaleSetVM, len(vmList))
+	for _, vm := range vmList {
+		if vm.ID != nil {
+			vmsByID[strings.ToLower(*vm.ID)] = vm
+		}
+		if vm.Name != nil {
+			vmsByName[*vm.Name] = vm
+		}
 	}
 
-	var ips []string
+	var instances []Instance
 	for _, iface := range interfaces {
 		// Check if the interface is attached to a VM
-		if iface.Properties != nil && iface.Properties.VirtualMachine != nil && iface.Properties.VirtualMachine.ID != nil {
-			for _, n := range iface.Properties.IPConfigurations {
-				ip := getPrimaryIPFromInterfaceIPConfiguration(n)
-				if ip != "" {
-					ips = append(ips, ip)
-					break
-				}
+		if iface.Properties == nil || iface.Properties.VirtualMachine == nil || iface.Properties.VirtualMachine.ID == nil {
+			continue
+		}
+
+		var ip string
+		for _, n := range iface.Properties.IPConfigurations {
+			ip = getPrimaryIPFromInterfaceIPConfiguration(n)
+			if ip != "" {
+				break
 			}
 		}
+		if ip == "" {
+			continue
+		}
+
+		vmID := *iface.Properties.VirtualMachine.ID
+		instance := Instance{ID: vmID, PrivateIP: ip, Addresses: interfaceAddresses(iface, publicIPs)}
+		if rID, err := arm.ParseResourceID(vmID); err == nil {
+			instance.ID = rID.Name
+		}
+
+		vm, ok := vmsByID[strings.ToLower(vmID)]
+		if !ok {
+			vm, ok = vmsByName[instance.ID]
+		}
+		if ok {
+			if vm.Name != nil {
+				instance.ID = *vm.Name
+			}
+			if len(vm.Zones) > 0 && vm.Zones[0] != nil {
+				instance.Zone = *vm.Zones[0]
+			}
+			if vm.Properties != nil && vm.Properties.TimeCreated != nil {
+				instance.LaunchTime = *vm.Properties.TimeCreated
+			}
+			instance.Tags = resourceTags(vm.Tags)
+		}
+
+		instances = append(instances, instance)
+	}
+
+	return instances
+}
+
+// interfaceAddresses returns the addresses of the IP configurations of the network interface, the primary one first.
+func interfaceAddresses(iface *armnetwork.Interface, publicIPs map[string]string) []Address {
+	tags := resourceTags(iface.Tags)
+	addresses := make([]Address, 0, len(iface.Properties.IPConfigurations))
+	for _, ipConfig := range iface.Properties.IPConfigurations {
+		if ipConfig.Properties == nil || ipConfig.Properties.PrivateIPAddress == nil {
+			continue
+		}
+		address := Address{
+			Tags:               tags,
+			NetworkInterfaceID: stringValue(iface.ID),
+			PrivateIP:          *ipConfig.Properties.PrivateIPAddress,
+			IPConfiguration:    stringValue(ipConfig.Name),
+		}
+		if ipConfig.Properties.Subnet != nil {
+			address.SubnetID = stringValue(ipConfig.Properties.Subnet.ID)
+		}
+		if pip := ipConfig.Properties.PublicIPAddress; pip != nil {
+			if pip.Properties != nil && pip.Properties.IPAddress != nil {
+				address.PublicIP = *pip.Properties.IPAddress
+			} else if pip.ID != nil {
+				address.PublicIP = publicIPs[strings.ToLower(*pip.ID)]
+			}
+		}
+		if getPrimaryIPFromInterfaceIPConfiguration(ipConfig) != "" {
+			addresses = slices.Insert(addresses, 0, address)
+		} else {
+			addresses = append(addresses, address)
+		}
+	}
+
+	return addresses
+}
+
+// resourceTags returns the tags of an Azure resource without the nil values.
+func resourceTags(tags map[string]*string) map[string]string {
+	result := make(map[string]string, len(tags))
+	for key, value := range tags {
+		if value != nil {
+			result[key] = *value
+		}
 	}
+	return result
+}
 
-	return ips, nil
+func stringValue(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
 }
 
 func getPrimaryIPFromInterfaceIPConfiguration(ipConfig *armnetwork.InterfaceIPConfiguration) string {
@@ -286,14 +503,15 @@ func getPrimaryIPFromInterfaceIPConfiguration(ipConfig *armnetwork.InterfaceIPCo
 }
 
 // CheckIfScalingGroupExists checks if the Virtual Machine Scale Set exists.
-func (client *AzureClient) CheckIfScalingGroupExists(name string) (bool, error) {
+func (client *AzureClient) CheckIfScalingGroupExists(ctx context.Context, name string) (bool, error) {
 	if name == "" {
 		return false, errors.New("VMSS name cannot be empty")
 	}
 
-	ctx := context.TODO()
 	expandType := armcompute.ExpandTypesForGetVMScaleSetsUserData
-	vmss, err := client.vMSSClient.Get(ctx, client.config.ResourceGroupName, name, &armcompute.VirtualMachineScaleSetsClientGetOptions{Expand: &expandType})
+	callCtx, done := startCloudAPICall(ctx, "GetVirtualMachineScaleSet", "scaling_group", name)
+	vmss, err := client.vMSSClient.Get(callCtx, client.config.ResourceGroupName, name, &armcompute.VirtualMachineScaleSetsClientGetOptions{Expand: &expandType})
+	done(err)
 	if err != nil {
 		return false, fmt.Errorf("couldn't check if a Virtual Machine Scale Set with name %s exists: %w", name, err)
 	}
@@ -301,13 +519,121 @@ func (client *AzureClient) CheckIfScalingGroupExists(name string) (bool, error)
 	return vmss.ID != nil, nil
 }
 
+// GetLocalZone returns the availability zone of the VM nginx-asg-sync runs on, using the Azure Instance Metadata Service.
+func (client *AzureClient) GetLocalZone(ctx context.Context) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, connTimeoutInSecs*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, azureIMDSZoneURL, nil)
+	if err != nil {
+		return "", fmt.Errorf("couldn't create the Instance Metadata Service request: %w", err)
+	}
+	req.Header.Set("Metadata", "true")
+
+	// The Instance Metadata Service must be reached directly, without a proxy.
+	imdsClient := &http.Client{Transport: &http.Transport{Proxy: nil}}
+	callCtx, done := startCloudAPICall(ctx, "GetInstanceMetadata", "path", "compute/zone")
+	resp, err := imdsClient.Do(req.WithContext(callCtx))
+	done(err)
+	if err != nil {
+		return "", fmt.Errorf("unable to retrieve availability zone from the Instance Metadata Service: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("unable to read availability zone from the Instance Metadata Service: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("the Instance Metadata Service returned status %v: %s", resp.StatusCode, body)
+	}
+
+	zone := strings.TrimSpace(string(body))
+	if zone == "" {
+		return "", errors.New("the VM isn't deployed in an availability zone")
+	}
+
+	return zone, nil
+}
+
+// UpstreamDiscovery returns the upstream_discovery config. The default tag prefix is nginx-asg-sync-, because the
+// names of Azure tags can't contain a slash.
+func (client *AzureClient) UpstreamDiscovery() *upstreamDiscoveryConfig {
+	return client.config.UpstreamDiscovery.withDefaults("nginx-asg-sync-")
+}
+
+// GetTaggedScalingGroups returns the tags of the Virtual Machine Scale Sets of the resource group that have the tag
+// key, by the names of the scale sets.
+func (client *AzureClient) GetTaggedScalingGroups(ctx context.Context, key string) (map[string]map[string]string, error) {
+	result := make(map[string]map[string]string)
+	pager := client.vMSSClient.NewListPager(client.config.ResourceGroupName, nil)
+	for pager.More() {
+		callCtx, done := startCloudAPICall(ctx, "ListVirtualMachineScaleSets", "tag", key)
+		resp, err := pager.NextPage(callCtx)
+		done(err)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list scale sets: %w", err)
+		}
+
+		for _, vmss := range resp.Value {
+			if vmss.Name == nil || vmss.Tags[key] == nil {
+				continue
+			}
+			result[*vmss.Name] = resourceTags(vmss.Tags)
+		}
+	}
+
+	return result, nil
+}
+
+// ReportUnhealthyInstance deletes the VM of the scale set, so that the scale set replaces it when its capacity is
+// restored. The deletion runs in the background. Only the scale sets in the Uniform orchestration mode are supported,
+// where the VM is identified by its instance ID.
+func (client *AzureClient) ReportUnhealthyInstance(ctx context.Context, group string, id string) error {
+	callCtx, done := startCloudAPICall(ctx, "GetVirtualMachineScaleSet", "scaling_group", group)
+	vmss, err := client.vMSSClient.Get(callCtx, client.config.ResourceGroupName, group, nil)
+	done(err)
+	if err != nil {
+		return fmt.Errorf("failed to get scale set %s: %w", group, err)
+	}
+	if vmss.Properties == nil || vmss.Properties.OrchestrationMode == nil ||
+		*vmss.Properties.OrchestrationMode != armcompute.OrchestrationModeUniform {
+		return fmt.Errorf("the VMs of the scale set %v can't be reported, only the Uniform orchestration mode is supported", group)
+	}
+
+	callCtx, done = startCloudAPICall(ctx, "GetVirtualMachineScaleSetVM", "scaling_group", group, "instance_id", id)
+	_, err = client.vmssVMClient.Get(callCtx, client.config.ResourceGroupName, group, id, nil)
+	done(err)
+	var respErr *azcore.ResponseError
+	if errors.As(err, &respErr) && respErr.StatusCode == http.StatusNotFound {
+		return errInstanceNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("couldn't get the VM %v: %w", id, err)
+	}
+
+	callCtx, done = startCloudAPICall(ctx, "DeleteVirtualMachineScaleSetVM", "scaling_group", group, "instance_id", id)
+	_, err = client.vmssVMClient.BeginDelete(callCtx, client.config.ResourceGroupName, group, id, nil)
+	done(err)
+	if err != nil {
+		return fmt.Errorf("couldn't delete the VM %v: %w", id, err)
+	}
+
+	return nil
+}
+
 func (client *AzureClient) configure() error {
 	cred, err := azidentity.NewDefaultAzureCredential(nil)
 	if err != nil {
 		return fmt.Errorf("couldn't create authorizer: %w", err)
 	}
 
-	computeClientFactory, err := armcompute.NewClientFactory(client.config.SubscriptionID, cred, nil)
+	// the calls to Azure are retried by the retry policy of the config. The retries of the SDK are disabled, so that
+	// the attempts don't multiply and max_attempts is the total number of attempts
+	opts := &arm.ClientOptions{ClientOptions: policy.ClientOptions{Retry: policy.RetryOptions{MaxRetries: -1}}}
+
+	computeClientFactory, err := armcompute.NewClientFactory(client.config.SubscriptionID, cred, opts)
 	if err != nil {
 		return fmt.Errorf("couldn't create client factory: %w", err)
 	}
@@ -315,12 +641,18 @@ func (client *AzureClient) configure() error {
 	client.vmssVMClient = computeClientFactory.NewVirtualMachineScaleSetVMsClient()
 	client.individualvmssVMClient = computeClientFactory.NewVirtualMachinesClient()
 
-	iclient, err := armnetwork.NewInterfacesClient(client.config.SubscriptionID, cred, nil)
+	iclient, err := armnetwork.NewInterfacesClient(client.config.SubscriptionID, cred, opts)
 	if err != nil {
 		return fmt.Errorf("couldn't create interfaces client: %w", err)
 	}
 	client.iFaceClient = iclient
 
+	pclient, err := armnetwork.NewPublicIPAddressesClient(client.config.SubscriptionID, cred, opts)
+	if err != nil {
+		return fmt.Errorf("couldn't create public IP addresses client: %w", err)
+	}
+	client.publicIPClient = pclient
+
 	return nil
 }
 
@@ -329,75 +661,161 @@ func (client *AzureClient) GetUpstreams() []Upstream {
 	upstreams := make([]Upstream, 0, len(client.config.Upstreams))
 	for i := range len(client.config.Upstreams) {
 		u := Upstream{
-			Name:         client.config.Upstreams[i].Name,
-			Port:         client.config.Upstreams[i].Port,
-			Kind:         client.config.Upstreams[i].Kind,
-			ScalingGroup: client.config.Upstreams[i].VMScaleSet,
-			MaxConns:     &client.config.Upstreams[i].MaxConns,
-			MaxFails:     &client.config.Upstreams[i].MaxFails,
-			FailTimeout:  getFailTimeoutOrDefault(client.config.Upstreams[i].FailTimeout),
-			SlowStart:    getSlowStartOrDefault(client.config.Upstreams[i].SlowStart),
+			Name:               client.config.Upstreams[i].Name,
+			Port:               client.config.Upstreams[i].Port,
+			Kind:               client.config.Upstreams[i].Kind,
+			ScalingGroup:       client.config.Upstreams[i].VMScaleSet,
+			BackupScalingGroup: client.config.Upstreams[i].BackupVMScaleSet,
+			MaxConns:           &client.config.Upstreams[i].MaxConns,
+			MaxFails:           &client.config.Upstreams[i].MaxFails,
+			FailTimeout:        getFailTimeoutOrDefault(client.config.Upstreams[i].FailTimeout),
+			SlowStart:          getSlowStartOrDefault(client.config.Upstreams[i].SlowStart),
+			DrainTimeout:       client.config.Upstreams[i].DrainTimeout,
+			MinInstanceAge:     client.config.Upstreams[i].MinInstanceAge,
+			ZoneAwareness:      client.config.Upstreams[i].ZoneAwareness,
+			HealthProbe:        client.config.Upstreams[i].HealthProbe,
+			UnhealthyFeedback:  client.config.Upstreams[i].UnhealthyFeedback,
+			AddressSelection:   client.config.Upstreams[i].AddressSelection,
+			InstanceTags:       client.config.Upstreams[i].InstanceTags,
 		}
 		upstreams = append(upstreams, u)
 	}
 	return upstreams
 }
 
+// SetUpstreamSource sets the function that returns all the synced upstreams, including the discovered ones.
+func (client *AzureClient) SetUpstreamSource(source func() []Upstream) {
+	client.upstreamSource = source
+}
+
+// syncedUpstreams returns all the synced upstreams, or the upstreams of the config if the upstream source isn't set.
+func (client *AzureClient) syncedUpstreams() []Upstream {
+	if client.upstreamSource != nil {
+		return client.upstreamSource()
+	}
+	return client.GetUpstreams()
+}
+
 type azureConfig struct {
-	SubscriptionID    string          `yaml:"subscription_id"`
-	ResourceGroupName string          `yaml:"resource_group_name"`
-	Upstreams         []azureUpstream `yaml:"upstreams"`
+	UpstreamDiscovery *upstreamDiscoveryConfig `yaml:"upstream_discovery"`
+	SubscriptionID    string                   `yaml:"subscription_id"`
+	ResourceGroupName string                   `yaml:"resource_group_name"`
+	Upstreams         []azureUpstream          `yaml:"upstreams"`
 }
 
 type azureUpstream struct {
-	Name        string `yaml:"name"`
-	VMScaleSet  string `yaml:"virtual_machine_scale_set"`
-	Kind        string `yaml:"kind"`
-	FailTimeout string `yaml:"fail_timeout"`
-	SlowStart   string `yaml:"slow_start"`
-	Port        int    `yaml:"port"`
-	MaxConns    int    `yaml:"max_conns"`
-	MaxFails    int    `yaml:"max_fails"`
+	ZoneAwareness       *zoneAwarenessConfig     `yaml:"zone_awareness"`
+	HealthProbe         *healthProbeConfig       `yaml:"health_probe"`
+	UnhealthyFeedback   *unhealthyFeedbackConfig `yaml:"unhealthy_feedback"`
+	AddressSelection    *addressSelectionConfig  `yaml:"address_selection"`
+	InstanceTags        *instanceTagsConfig      `yaml:"instance_tags"`
+	Name                string                   `yaml:"name"`
+	VMScaleSet          string                   `yaml:"virtual_machine_scale_set"`
+	BackupVMScaleSet    string                   `yaml:"backup_virtual_machine_scale_set"`
+	Kind                string                   `yaml:"kind"`
+	LoadBalancingMethod string                   `yaml:"load_balancing_method"`
+	FailTimeout         string                   `yaml:"fail_timeout"`
+	SlowStart           string                   `yaml:"slow_start"`
+	DrainTimeout        time.Duration            `yaml:"drain_timeout"`
+	MinInstanceAge      time.Duration            `yaml:"min_instance_age"`
+	Port                int                      `yaml:"port"`
+	MaxConns            int                      `yaml:"max_conns"`
+	MaxFails            int                      `yaml:"max_fails"`
 }
 
 func validateAzureConfig(cfg *azureConfig) error {
+	var errs []error
+
 	if cfg.SubscriptionID == "" {
-		return fmt.Errorf(errorMsgFormat, "subscription_id")
+		errs = append(errs, newConfigError("subscription_id", errorMsgFormat, "subscription_id"))
 	}
 
 	if cfg.ResourceGroupName == "" {
-		return fmt.Errorf(errorMsgFormat, "resource_group_name")
+		errs = append(errs, newConfigError("resource_group_name", errorMsgFormat, "resource_group_name"))
 	}
 
-	if len(cfg.Upstreams) == 0 {
-		return errors.New("there are no upstreams found in the config file")
+	if len(cfg.Upstreams) == 0 && cfg.UpstreamDiscovery == nil {
+		errs = append(errs, newConfigError("upstreams", upstreamsErrorMsg))
 	}
 
-	for _, ups := range cfg.Upstreams {
+	if cfg.UpstreamDiscovery != nil {
+		if err := validateUpstreamDiscoveryConfig(cfg.UpstreamDiscovery); err != nil {
+			errs = append(errs, &configError{field: "upstream_discovery", err: err})
+		}
+	}
+
+	seen := make(map[upstreamKey]bool, len(cfg.Upstreams))
+	for i, ups := range cfg.Upstreams {
 		if ups.Name == "" {
-			return errors.New(upstreamNameErrorMsg)
+			errs = append(errs, newConfigError(upstreamField(i, "name"), upstreamNameErrorMsg))
+		}
+		key := upstreamKey{name: ups.Name, kind: ups.Kind}
+		if ups.Name != "" && seen[key] {
+			errs = append(errs, newConfigError(upstreamField(i, "name"), upstreamDuplicateErrorMsgFmt, ups.Name, ups.Kind))
 		}
+		seen[key] = true
 		if ups.VMScaleSet == "" {
-			return fmt.Errorf(upstreamErrorMsgFormat, "virtual_machine_scale_set", ups.Name)
+			errs = append(errs, newConfigError(upstreamField(i, "virtual_machine_scale_set"), upstreamErrorMsgFormat, "virtual_machine_scale_set", ups.Name))
 		}
 		if ups.Port == 0 {
-			return fmt.Errorf(upstreamPortErrorMsgFormat, ups.Name)
+			errs = append(errs, newConfigError(upstreamField(i, "port"), upstreamPortErrorMsgFormat, ups.Name))
 		}
 		if ups.Kind == "" || (ups.Kind != "http" && ups.Kind != "stream") {
-			return fmt.Errorf(upstreamKindErrorMsgFormat, ups.Name)
+			errs = append(errs, newConfigError(upstreamField(i, "kind"), upstreamKindErrorMsgFormat, ups.Name))
 		}
 		if ups.MaxConns < 0 {
-			return fmt.Errorf(upstreamMaxConnsErrorMsgFmt, ups.MaxConns)
+			errs = append(errs, newConfigError(upstreamField(i, "max_conns"), upstreamMaxConnsErrorMsgFmt, ups.MaxConns))
 		}
 		if ups.MaxFails < 0 {
-			return fmt.Errorf(upstreamMaxFailsErrorMsgFmt, ups.MaxFails)
+			errs = append(errs, newConfigError(upstreamField(i, "max_fails"), upstreamMaxFailsErrorMsgFmt, ups.MaxFails))
 		}
 		if !isValidTime(ups.FailTimeout) {
-			return fmt.Errorf(upstreamFailTimeoutErrorMsgFmt, ups.FailTimeout)
+			errs = append(errs, newConfigError(upstreamField(i, "fail_timeout"), upstreamFailTimeoutErrorMsgFmt, ups.FailTimeout))
 		}
 		if !isValidTime(ups.SlowStart) {
-			return fmt.Errorf(upstreamSlowStartErrorMsgFmt, ups.SlowStart)
+			errs = append(errs, newConfigError(upstreamField(i, "slow_start"), upstreamSlowStartErrorMsgFmt, ups.SlowStart))
+		}
+		if !isValidLoadBalancingMethod(ups.Kind, ups.LoadBalancingMethod) {
+			errs = append(errs, newConfigError(upstreamField(i, "load_balancing_method"), upstreamLBMethodErrorMsgFmt, ups.LoadBalancingMethod, ups.Name))
+		}
+		if ups.BackupVMScaleSet != "" && !allowsBackupServers(ups.LoadBalancingMethod) {
+			errs = append(errs, newConfigError(upstreamField(i, "load_balancing_method"), upstreamBackupErrorMsgFmt, ups.LoadBalancingMethod, ups.Name))
+		}
+		if err := validateDrainTimeout(ups.DrainTimeout, ups.Kind, ups.Name); err != nil {
+			errs = append(errs, &configError{field: upstreamField(i, "drain_timeout"), err: err})
+		}
+		if ups.MinInstanceAge < 0 {
+			errs = append(errs, newConfigError(upstreamField(i, "min_instance_age"), upstreamMinInstanceAgeErrorMsgFmt, ups.MinInstanceAge, ups.Name))
+		}
+		if ups.ZoneAwareness != nil {
+			if err := validateZoneAwarenessConfig(ups.ZoneAwareness, ups.Name, ups.LoadBalancingMethod); err != nil {
+				errs = append(errs, &configError{field: upstreamField(i, "zone_awareness"), err: err})
+			}
+		}
+		if ups.HealthProbe != nil {
+			if err := validateHealthProbeConfig(ups.HealthProbe, ups.Name); err != nil {
+				errs = append(errs, &configError{field: upstreamField(i, "health_probe"), err: err})
+			}
+		}
+		if ups.UnhealthyFeedback != nil {
+			if err := validateUnhealthyFeedbackConfig(ups.UnhealthyFeedback, ups.Name); err != nil {
+				errs = append(errs, &configError{field: upstreamField(i, "unhealthy_feedback"), err: err})
+			}
+		}
+		if ups.InstanceTags != nil {
+			if err := validateInstanceTagsConfig(ups.InstanceTags, ups.Name); err != nil {
+				errs = append(errs, &configError{field: upstreamField(i, "instance_tags"), err: err})
+			}
+		}
+		if ups.AddressSelection != nil {
+			if err := validateAddressSelectionConfig(ups.AddressSelection, ups.Name); err != nil {
+				errs = append(errs, &configError{field: upstreamField(i, "address_selection"), err: err})
+			}
+			if ups.AddressSelection.DeviceIndex != nil {
+				errs = append(errs, newConfigError(upstreamField(i, "address_selection"), upstreamAddressSelectionErrorMsgFmt, "device_index", ups.Name))
+			}
 		}
 	}
-	return nil
+
+	return errors.Join(errs...)
 }
@@ -3,9 +3,13 @@ package main
 import (
 	"context"
 	"errors"
+	"net/http"
 	"reflect"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
 	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute/v8"
 	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/network/armnetwork/v9"
@@ -17,7 +21,27 @@ type testInputAzure struct {
 }
 
 type mockVMSSClient struct {
-	getFunc func(ctx context.Context, rg, name string, opts *armcompute.VirtualMachineScaleSetsClientGetOptions) (armcompute.VirtualMachineScaleSetsClientGetResponse, error)
+	getFunc   func(ctx context.Context, rg, name string, opts *armcompute.VirtualMachineScaleSetsClientGetOptions) (armcompute.VirtualMachineScaleSetsClientGetResponse, error)
+	scaleSets []*armcompute.VirtualMachineScaleSet
+}
+
+func (m *mockVMSSClient) NewListPager(
+	_ string, _ *armcompute.VirtualMachineScaleSetsClientListOptions,
+) *runtime.Pager[armcompute.VirtualMachineScaleSetsClientListResponse] {
+	return runtime.NewPager(
+		runtime.PagingHandler[armcompute.VirtualMachineScaleSetsClientListResponse]{
+			More: func(armcompute.VirtualMachineScaleSetsClientListResponse) bool {
+				return false
+			},
+			Fetcher: func(
+				context.Context, *armcompute.VirtualMachineScaleSetsClientListResponse,
+			) (armcompute.VirtualMachineScaleSetsClientListResponse, error) {
+				resp := armcompute.VirtualMachineScaleSetsClientListResponse{}
+				resp.Value = m.scaleSets
+				return resp, nil
+			},
+		},
+	)
 }
 
 func (m *mockVMSSClient) Get(ctx context.Context, rg, name string, opts *armcompute.VirtualMachineScaleSetsClientGetOptions) (armcompute.VirtualMachineScaleSetsClientGetResponse, error) {
@@ -26,6 +50,8 @@ func (m *mockVMSSClient) Get(ctx context.Context, rg, name string, opts *armcomp
 
 type mockVMSSVMsClient struct {
 	newListPagerFunc func(rg, name string, opts *armcompute.VirtualMachineScaleSetVMsClientListOptions) *mockPagerVMSSVMs
+	getFunc          func(ctx context.Context, rg, name, instanceID string, opts *armcompute.VirtualMachineScaleSetVMsClientGetOptions) (armcompute.VirtualMachineScaleSetVMsClientGetResponse, error)
+	deleted          []string
 }
 
 func (m *mockVMSSVMsClient) NewListPager(
@@ -52,6 +78,17 @@ func (m *mockVMSSVMsClient) NewListPager(
 	)
 }
 
+func (m *mockVMSSVMsClient) Get(ctx context.Context, rg, name, instanceID string, opts *armcompute.VirtualMachineScaleSetVMsClientGetOptions) (armcompute.VirtualMachineScaleSetVMsClientGetResponse, error) {
+	return m.getFunc(ctx, rg, name, instanceID, opts)
+}
+
+func (m *mockVMSSVMsClient) BeginDelete(
+	_ context.Context, _, name, instanceID string, _ *armcompute.VirtualMachineScaleSetVMsClientBeginDeleteOptions,
+) (*runtime.Poller[armcompute.VirtualMachineScaleSetVMsClientDeleteResponse], error) {
+	m.deleted = append(m.deleted, name+"/"+instanceID)
+	return nil, nil
+}
+
 type mockVMsClient struct {
 	getFunc func(ctx context.Context, rg, name string, opts *armcompute.VirtualMachinesClientGetOptions) (armcompute.VirtualMachinesClientGetResponse, error)
 }
@@ -138,7 +175,7 @@ func (m *mockPagerVMSSVMs) NextPage(_ context.Context) (armcompute.VirtualMachin
 	return resp, nil
 }
 
-func TestAzureClient_GetPrivateIPsForScalingGroup(t *testing.T) {
+func TestAzureClient_GetInstancesForScalingGroup(t *testing.T) {
 	t.Parallel()
 	uniformVMSS := armcompute.VirtualMachineScaleSetsClientGetResponse{
 		VirtualMachineScaleSet: armcompute.VirtualMachineScaleSet{
@@ -329,7 +366,14 @@ func TestAzureClient_GetPrivateIPsForScalingGroup(t *testing.T) {
 				},
 			}
 
-			ips, err := ac.GetPrivateIPsForScalingGroup("testvmss")
+			instances, err := ac.GetInstancesForScalingGroup(context.Background(), "testvmss")
+			var ips []string
+			if instances != nil {
+				ips = make([]string, 0, len(instances))
+				for _, ins := range instances {
+					ips = append(ips, ins.PrivateIP)
+				}
+			}
 			if (err != nil) != tt.wantErr {
 				t.Fatalf("expected error: %v, got: %v", tt.wantErr, err)
 			}
@@ -362,7 +406,7 @@ func getValidAzureConfig() *azureConfig {
 }
 
 func getInvalidAzureConfigInput() []*testInputAzure {
-	input := make([]*testInputAzure, 0, 11)
+	input := make([]*testInputAzure, 0, 18)
 
 	invalidSubscriptionCfg := getValidAzureConfig()
 	invalidSubscriptionCfg.SubscriptionID = ""
@@ -408,6 +452,54 @@ func getInvalidAzureConfigInput() []*testInputAzure {
 	invalidUpstreamSlowStartCfg.Upstreams[0].SlowStart = "-10s"
 	input = append(input, &testInputAzure{invalidUpstreamSlowStartCfg, "invalid slow_start of the upstream"})
 
+	invalidUpstreamLBMethodCfg := getValidAzureConfig()
+	invalidUpstreamLBMethodCfg.Upstreams[0].Kind = "stream"
+	invalidUpstreamLBMethodCfg.Upstreams[0].LoadBalancingMethod = "ip_hash"
+	input = append(input, &testInputAzure{invalidUpstreamLBMethodCfg, "invalid load_balancing_method of the stream upstream"})
+
+	invalidUpstreamBackupCfg := getValidAzureConfig()
+	invalidUpstreamBackupCfg.Upstreams[0].BackupVMScaleSet = "backup-group"
+	invalidUpstreamBackupCfg.Upstreams[0].LoadBalancingMethod = "random"
+	input = append(input, &testInputAzure{invalidUpstreamBackupCfg, "backup_virtual_machine_scale_set with the random load balancing method"})
+
+	invalidUpstreamDrainTimeoutCfg := getValidAzureConfig()
+	invalidUpstreamDrainTimeoutCfg.Upstreams[0].DrainTimeout = -10 * time.Second
+	input = append(input, &testInputAzure{invalidUpstreamDrainTimeoutCfg, "invalid drain_timeout of the upstream"})
+
+	invalidStreamDrainTimeoutCfg := getValidAzureConfig()
+	invalidStreamDrainTimeoutCfg.Upstreams[0].Kind = "stream"
+	invalidStreamDrainTimeoutCfg.Upstreams[0].DrainTimeout = 10 * time.Second
+	input = append(input, &testInputAzure{invalidStreamDrainTimeoutCfg, "drain_timeout of a stream upstream"})
+
+	invalidUpstreamMinInstanceAgeCfg := getValidAzureConfig()
+	invalidUpstreamMinInstanceAgeCfg.Upstreams[0].MinInstanceAge = -time.Minute
+	input = append(input, &testInputAzure{invalidUpstreamMinInstanceAgeCfg, "invalid min_instance_age of the upstream"})
+
+	invalidUpstreamHealthProbeCfg := getValidAzureConfig()
+	invalidUpstreamHealthProbeCfg.Upstreams[0].HealthProbe = &healthProbeConfig{Type: "udp"}
+	input = append(input, &testInputAzure{invalidUpstreamHealthProbeCfg, "invalid health_probe of the upstream"})
+
+	invalidUpstreamDiscoveryCfg := getValidAzureConfig()
+	invalidUpstreamDiscoveryCfg.UpstreamDiscovery = &upstreamDiscoveryConfig{Kind: "grpc"}
+	input = append(input, &testInputAzure{invalidUpstreamDiscoveryCfg, "invalid kind of upstream_discovery"})
+
+	invalidUpstreamInstanceTagsCfg := getValidAzureConfig()
+	invalidUpstreamInstanceTagsCfg.Upstreams[0].InstanceTags = &instanceTagsConfig{}
+	input = append(input, &testInputAzure{invalidUpstreamInstanceTagsCfg, "invalid instance_tags of the upstream"})
+
+	invalidUpstreamAddressSelectionCfg := getValidAzureConfig()
+	invalidUpstreamAddressSelectionCfg.Upstreams[0].AddressSelection = &addressSelectionConfig{SubnetCIDR: "10.0.0.0"}
+	input = append(input, &testInputAzure{invalidUpstreamAddressSelectionCfg, "invalid address_selection of the upstream"})
+
+	deviceIndex := 1
+	unsupportedUpstreamAddressSelectionCfg := getValidAzureConfig()
+	unsupportedUpstreamAddressSelectionCfg.Upstreams[0].AddressSelection = &addressSelectionConfig{DeviceIndex: &deviceIndex}
+	input = append(input, &testInputAzure{unsupportedUpstreamAddressSelectionCfg, "unsupported address_selection of the upstream"})
+
+	duplicateUpstreamCfg := getValidAzureConfig()
+	duplicateUpstreamCfg.Upstreams = append(duplicateUpstreamCfg.Upstreams, duplicateUpstreamCfg.Upstreams[0])
+	input = append(input, &testInputAzure{duplicateUpstreamCfg, "duplicate upstreams"})
+
 	return input
 }
 
@@ -433,6 +525,18 @@ func TestValidateAzureConfigValid(t *testing.T) {
 	}
 }
 
+func TestValidateAzureConfigValidUpstreamDiscovery(t *testing.T) {
+	t.Parallel()
+	cfg := getValidAzureConfig()
+	cfg.Upstreams = nil
+	cfg.UpstreamDiscovery = &upstreamDiscoveryConfig{}
+
+	err := validateAzureConfig(cfg)
+	if err != nil {
+		t.Errorf("validateAzureConfig() failed for the config with upstream_discovery and no upstreams: %v", err)
+	}
+}
+
 func TestGetPrimaryIPFromInterfaceIPConfiguration(t *testing.T) {
 	t.Parallel()
 	primary := true
@@ -558,3 +662,144 @@ func areEqualUpstreamsAzure(u1 azureUpstream, u2 Upstream) bool {
 
 	return true
 }
+
+func TestInstancesFromInterfaces(t *testing.T) {
+	t.Parallel()
+	vmID := "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.Compute/virtualMachineScaleSets/vmss/virtualMachines/0"
+	pipID := "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.Network/publicIPAddresses/pip-0"
+	interfaces := []*armnetwork.Interface{
+		{
+			ID:   ptrStr("nic-0"),
+			Tags: map[string]*string{"role": ptrStr("data")},
+			Properties: &armnetwork.InterfacePropertiesFormat{
+				VirtualMachine: &armnetwork.SubResource{ID: ptrStr(vmID)},
+				IPConfigurations: []*armnetwork.InterfaceIPConfiguration{
+					{
+						Name: ptrStr("secondary"),
+						Properties: &armnetwork.InterfaceIPConfigurationPropertiesFormat{
+							Primary:          ptrBool(false),
+							PrivateIPAddress: ptrStr("10.0.1.1"),
+							Subnet:           &armnetwork.Subnet{ID: ptrStr("subnet-1")},
+							PublicIPAddress:  &armnetwork.PublicIPAddress{ID: ptrStr(pipID)},
+						},
+					},
+					{
+						Name: ptrStr("primary"),
+						Properties: &armnetwork.InterfaceIPConfigurationPropertiesFormat{
+							Primary:          ptrBool(true),
+							PrivateIPAddress: ptrStr("10.0.0.1"),
+						},
+					},
+				},
+			},
+		},
+		{
+			// not attached to a VM
+			Properties: &armnetwork.InterfacePropertiesFormat{
+				IPConfigurations: []*armnetwork.InterfaceIPConfiguration{{
+					Properties: &armnetwork.InterfaceIPConfigurationPropertiesFormat{
+						Primary:          ptrBool(true),
+						PrivateIPAddress: ptrStr("10.0.0.2"),
+					},
+				}},
+			},
+		},
+	}
+	created := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	vmList := []*armcompute.VirtualMachineScaleSetVM{{
+		ID:         ptrStr(strings.ToUpper(vmID)),
+		Name:       ptrStr("vmss_0"),
+		Zones:      []*string{ptrStr("2")},
+		Tags:       map[string]*string{"nginx-exclude": ptrStr("true")},
+		Properties: &armcompute.VirtualMachineScaleSetVMProperties{TimeCreated: &created},
+	}}
+
+	addresses := []Address{
+		{Tags: map[string]string{"role": "data"}, NetworkInterfaceID: "nic-0", PrivateIP: "10.0.0.1", IPConfiguration: "primary"},
+		{
+			Tags: map[string]string{"role": "data"}, NetworkInterfaceID: "nic-0", PrivateIP: "10.0.1.1", PublicIP: "203.0.113.1",
+			SubnetID: "subnet-1", IPConfiguration: "secondary",
+		},
+	}
+	instances := instancesFromInterfaces(interfaces, vmList, map[string]string{strings.ToLower(pipID): "203.0.113.1"})
+	expected := []Instance{{
+		ID: "vmss_0", PrivateIP: "10.0.0.1", Zone: "2", LaunchTime: created, Tags: map[string]string{"nginx-exclude": "true"},
+		Addresses: addresses,
+	}}
+	if !reflect.DeepEqual(instances, expected) {
+		t.Errorf("instancesFromInterfaces() returned %+v, expected %+v", instances, expected)
+	}
+
+	addresses[1].PublicIP = ""
+	instances = instancesFromInterfaces(interfaces, nil, nil)
+	expected = []Instance{{ID: "0", PrivateIP: "10.0.0.1", Addresses: addresses}}
+	if !reflect.DeepEqual(instances, expected) {
+		t.Errorf("instancesFromInterfaces() without VMs returned %+v, expected %+v", instances, expected)
+	}
+}
+
+func TestAzureClientGetTaggedScalingGroups(t *testing.T) {
+	t.Parallel()
+	client := &AzureClient{
+		config: getValidAzureConfig(),
+		vMSSClient: &mockVMSSClient{scaleSets: []*armcompute.VirtualMachineScaleSet{
+			{Name: ptrStr("backend-one"), Tags: map[string]*string{"nginx-asg-sync-upstream": ptrStr("backend-one"), "nginx-asg-sync-port": ptrStr("8080")}},
+			{Name: ptrStr("untagged"), Tags: map[string]*string{"team": ptrStr("web")}},
+		}},
+	}
+
+	groups, err := client.GetTaggedScalingGroups(context.Background(), "nginx-asg-sync-upstream")
+	if err != nil {
+		t.Fatalf("GetTaggedScalingGroups() failed: %v", err)
+	}
+	expected := map[string]map[string]string{
+		"backend-one": {"nginx-asg-sync-upstream": "backend-one", "nginx-asg-sync-port": "8080"},
+	}
+	if !reflect.DeepEqual(groups, expected) {
+		t.Errorf("GetTaggedScalingGroups() returned %v, expected %v", groups, expected)
+	}
+}
+
+func TestAzureClientReportUnhealthyInstance(t *testing.T) {
+	t.Parallel()
+	modes := map[string]armcompute.OrchestrationMode{
+		"uniform":  armcompute.OrchestrationModeUniform,
+		"flexible": armcompute.OrchestrationModeFlexible,
+	}
+	vmssVMs := &mockVMSSVMsClient{
+		getFunc: func(_ context.Context, _, _, instanceID string, _ *armcompute.VirtualMachineScaleSetVMsClientGetOptions) (armcompute.VirtualMachineScaleSetVMsClientGetResponse, error) {
+			if instanceID != "1" {
+				return armcompute.VirtualMachineScaleSetVMsClientGetResponse{}, &azcore.ResponseError{StatusCode: http.StatusNotFound}
+			}
+			return armcompute.VirtualMachineScaleSetVMsClientGetResponse{}, nil
+		},
+	}
+	client := &AzureClient{
+		config: &azureConfig{ResourceGroupName: "rg"},
+		vMSSClient: &mockVMSSClient{
+			getFunc: func(_ context.Context, _, name string, _ *armcompute.VirtualMachineScaleSetsClientGetOptions) (armcompute.VirtualMachineScaleSetsClientGetResponse, error) {
+				mode := modes[name]
+				resp := armcompute.VirtualMachineScaleSetsClientGetResponse{}
+				resp.Properties = &armcompute.VirtualMachineScaleSetProperties{OrchestrationMode: &mode}
+				return resp, nil
+			},
+		},
+		vmssVMClient: vmssVMs,
+	}
+	ctx := context.Background()
+
+	if err := client.ReportUnhealthyInstance(ctx, "uniform", "1"); err != nil {
+		t.Errorf("ReportUnhealthyInstance() failed: %v", err)
+	}
+	if err := client.ReportUnhealthyInstance(ctx, "uniform", "2"); !errors.Is(err, errInstanceNotFound) {
+		t.Errorf("ReportUnhealthyInstance() returned %v for a missing VM, expected %v", err, errInstanceNotFound)
+	}
+	if err := client.ReportUnhealthyInstance(ctx, "flexible", "1"); err == nil {
+		t.Error("ReportUnhealthyInstance() didn't fail for a scale set in the Flexible orchestration mode")
+	}
+
+	want := []string{"uniform/1"}
+	if !reflect.DeepEqual(vmssVMs.deleted, want) {
+		t.Errorf("ReportUnhealthyInstance() deleted %v, expected %v", vmssVMs.deleted, want)
+	}
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"errors"
 	"fmt"
+	"net"
 	"time"
 
 	yaml "gopkg.in/yaml.v3"
@@ -10,10 +11,25 @@ import (
 
 // commonConfig stores the configuration parameters common to all providers.
 type commonConfig struct {
-	CustomHeaders map[string]string `yaml:"custom_headers,omitempty"`
-	APIEndpoint   string            `yaml:"api_endpoint"`
-	CloudProvider string            `yaml:"cloud_provider"`
-	SyncInterval  time.Duration     `yaml:"sync_interval"`
+	CustomHeaders       map[string]string             `yaml:"custom_headers,omitempty"`
+	CustomHeaderSecrets map[string]headerSecretConfig `yaml:"custom_header_secrets,omitempty"`
+	Ownership           *ownershipConfig              `yaml:"ownership,omitempty"`
+	MassRemoval         *massRemovalConfig            `yaml:"mass_removal,omitempty"`
+	LeaderElection      *leaderElectionConfig         `yaml:"leader_election,omitempty"`
+	APITLS              *apiTLSConfig                 `yaml:"api_tls,omitempty"`
+	APIAuth             *apiAuthConfig                `yaml:"api_auth,omitempty"`
+	Retry               *retryConfig                  `yaml:"retry,omitempty"`
+	Tracing             *tracingConfig                `yaml:"tracing,omitempty"`
+	APIEndpoint         string                        `yaml:"api_endpoint"`
+	CloudProvider       string                        `yaml:"cloud_provider"`
+	Zone                string                        `yaml:"zone,omitempty"`
+	AdminListen         string                        `yaml:"admin_listen,omitempty"`
+	StatePath           string                        `yaml:"state_path,omitempty"`
+	LogFormat           string                        `yaml:"log_format,omitempty"`
+	LogLevel            string                        `yaml:"log_level,omitempty"`
+	Webhooks            []webhookConfig               `yaml:"webhooks,omitempty"`
+	SyncInterval        time.Duration                 `yaml:"sync_interval"`
+	DiscoveryCacheTTL   time.Duration                 `yaml:"discovery_cache_ttl,omitempty"`
 }
 
 func parseCommonConfig(data []byte) (*commonConfig, error) {
@@ -32,12 +48,18 @@ func parseCommonConfig(data []byte) (*commonConfig, error) {
 }
 
 func validateCommonConfig(cfg *commonConfig) error {
+	var errs []error
+
 	if cfg.APIEndpoint == "" {
-		return fmt.Errorf(errorMsgFormat, "api_endpoint")
+		errs = append(errs, newConfigError("api_endpoint", errorMsgFormat, "api_endpoint"))
 	}
 
 	if cfg.SyncInterval <= 0 {
-		return errors.New(intervalErrorMsg)
+		errs = append(errs, newConfigError("sync_interval", intervalErrorMsg))
+	}
+
+	if cfg.DiscoveryCacheTTL < 0 {
+		errs = append(errs, newConfigError("discovery_cache_ttl", discoveryCacheTTLErrorMsgFmt, cfg.DiscoveryCacheTTL))
 	}
 
 	if cfg.CloudProvider == "" {
@@ -45,21 +67,137 @@ func validateCommonConfig(cfg *commonConfig) error {
 	}
 
 	if !validateCloudProvider(cfg.CloudProvider) {
-		return fmt.Errorf(cloudProviderErrorMsg, cfg.CloudProvider)
+		errs = append(errs, newConfigError("cloud_provider", cloudProviderErrorMsg, cfg.CloudProvider))
+	}
+
+	for header, secret := range cfg.CustomHeaderSecrets {
+		if err := validateHeaderSecretConfig(secret, header); err != nil {
+			errs = append(errs, &configError{field: "custom_header_secrets." + header, err: err})
+		}
+	}
+
+	if !isValidLogFormat(cfg.LogFormat) {
+		errs = append(errs, newConfigError("log_format", logFormatErrorMsgFmt, cfg.LogFormat))
+	}
+
+	if !isValidLogLevel(cfg.LogLevel) {
+		errs = append(errs, newConfigError("log_level", logLevelErrorMsgFmt, cfg.LogLevel))
+	}
+
+	if cfg.Retry != nil {
+		if err := validateRetryConfig(cfg.Retry); err != nil {
+			errs = append(errs, &configError{field: "retry", err: err})
+		}
+	}
+
+	if cfg.APIAuth != nil {
+		if err := validateAPIAuthConfig(cfg.APIAuth); err != nil {
+			errs = append(errs, &configError{field: "api_auth", err: err})
+		} else if hasAuthorizationHeader(cfg) {
+			errs = append(errs, newConfigError("api_auth", apiAuthHeaderErrorMsg))
+		}
+	}
+
+	if cfg.APITLS != nil {
+		if err := validateAPITLSConfig(cfg.APITLS); err != nil {
+			errs = append(errs, &configError{field: "api_tls", err: err})
+		}
+	}
+
+	if cfg.AdminListen != "" {
+		if _, _, err := net.SplitHostPort(cfg.AdminListen); err != nil {
+			errs = append(errs, newConfigError("admin_listen", adminListenErrorMsgFmt, cfg.AdminListen))
+		}
+	}
+
+	if cfg.Tracing != nil {
+		if err := validateTracingConfig(cfg.Tracing); err != nil {
+			errs = append(errs, &configError{field: "tracing", err: err})
+		}
+	}
+
+	for i, webhook := range cfg.Webhooks {
+		if err := validateWebhookConfig(webhook, i); err != nil {
+			errs = append(errs, err)
+		}
 	}
 
+	if cfg.Ownership != nil {
+		if err := validateOwnershipConfig(cfg.Ownership, cfg.StatePath); err != nil {
+			errs = append(errs, &configError{field: "ownership", err: err})
+		}
+	}
+
+	if cfg.MassRemoval != nil {
+		if err := validateMassRemovalConfig(cfg.MassRemoval); err != nil {
+			errs = append(errs, &configError{field: "mass_removal", err: err})
+		}
+	}
+
+	if cfg.LeaderElection != nil {
+		if err := validateLeaderElectionConfig(cfg.LeaderElection, cfg.SyncInterval); err != nil {
+			errs = append(errs, &configError{field: "leader_election", err: err})
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// configError is a problem with a field of the config file. The field is identified by its path in the config file,
+// for example upstreams.0.port, so that the validate command can report the line of the field.
+type configError struct {
+	err   error
+	field string
+}
+
+func newConfigError(field string, format string, a ...any) *configError {
+	return &configError{field: field, err: fmt.Errorf(format, a...)}
+}
+
+func (e *configError) Error() string {
+	return e.err.Error()
+}
+
+func (e *configError) Unwrap() error {
+	return e.err
+}
+
+// upstreamField returns the path of a field of the upstream with the index i in the config file.
+func upstreamField(i int, field string) string {
+	return fmt.Sprintf("upstreams.%d.%s", i, field)
+}
+
+// validateDrainTimeout checks the drain_timeout of an upstream. NGINX Plus supports draining only for http upstreams.
+func validateDrainTimeout(drainTimeout time.Duration, kind string, upstreamName string) error {
+	if drainTimeout < 0 || (drainTimeout > 0 && kind == "stream") {
+		return fmt.Errorf(upstreamDrainTimeoutErrorMsgFmt, drainTimeout, upstreamName)
+	}
 	return nil
 }
 
+// upstreamKey identifies an upstream in NGINX Plus, where http and stream upstreams can have the same name.
+type upstreamKey struct {
+	name string
+	kind string
+}
+
 // Upstream is the cloud agnostic representation of an Upstream (eg, common fields for every cloud provider).
 type Upstream struct {
-	MaxConns     *int
-	MaxFails     *int
-	Name         string
-	ScalingGroup string
-	Kind         string
-	FailTimeout  string
-	SlowStart    string
-	Port         int
-	InService    bool
+	MaxConns           *int
+	MaxFails           *int
+	ZoneAwareness      *zoneAwarenessConfig
+	HealthProbe        *healthProbeConfig
+	UnhealthyFeedback  *unhealthyFeedbackConfig
+	AddressSelection   *addressSelectionConfig
+	InstanceTags       *instanceTagsConfig
+	Name               string
+	ScalingGroup       string
+	BackupScalingGroup string
+	Kind               string
+	FailTimeout        string
+	SlowStart          string
+	Port               int
+	DrainTimeout       time.Duration
+	MinInstanceAge     time.Duration
+	InService          bool
 }
@@ -21,7 +21,7 @@ func getValidCommonConfig() *commonConfig {
 }
 
 func getInvalidCommonConfigInput() []*testInputCommon {
-	input := make([]*testInputCommon, 0, 2)
+	input := make([]*testInputCommon, 0, 4)
 
 	invalidAPIEndpointCfg := getValidCommonConfig()
 	invalidAPIEndpointCfg.APIEndpoint = ""
@@ -31,6 +31,14 @@ func getInvalidCommonConfigInput() []*testInputCommon {
 	invalidSyncIntervalCfg.SyncInterval = 0
 	input = append(input, &testInputCommon{invalidSyncIntervalCfg, "invalid sync_interval"})
 
+	invalidLogFormatCfg := getValidCommonConfig()
+	invalidLogFormatCfg.LogFormat = "xml"
+	input = append(input, &testInputCommon{invalidLogFormatCfg, "invalid log_format"})
+
+	invalidLogLevelCfg := getValidCommonConfig()
+	invalidLogLevelCfg.LogLevel = "verbose"
+	input = append(input, &testInputCommon{invalidLogLevelCfg, "invalid log_level"})
+
 	return input
 }
 
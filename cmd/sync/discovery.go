@@ -0,0 +1,66 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// discoveryCache caches the instances of the scaling groups, so that the upstreams of the same scaling group don't call
+// the cloud provider API again in the same sync cycle. With a TTL, the instances are also reused in the next cycles
+// until they are older than the TTL. A nil discoveryCache doesn't cache anything.
+type discoveryCache struct {
+	entries map[string]discoveryEntry
+	ttl     time.Duration
+	cycle   int
+	mu      sync.Mutex
+}
+
+type discoveryEntry struct {
+	fetched   time.Time
+	instances []Instance
+	cycle     int
+}
+
+func newDiscoveryCache(ttl time.Duration) *discoveryCache {
+	return &discoveryCache{entries: make(map[string]discoveryEntry), ttl: ttl}
+}
+
+// newCycle starts a sync cycle. A fresh cycle, such as a sync requested with the admin API, doesn't use the instances
+// cached in the previous cycles.
+func (c *discoveryCache) newCycle(fresh bool) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.cycle++
+	if fresh {
+		clear(c.entries)
+	}
+}
+
+// get returns the cached instances of the scaling group, if they were fetched in the current cycle or within the TTL.
+func (c *discoveryCache) get(group string, now time.Time) ([]Instance, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[group]
+	if !ok || (entry.cycle != c.cycle && now.Sub(entry.fetched) >= c.ttl) {
+		return nil, false
+	}
+	return entry.instances, true
+}
+
+func (c *discoveryCache) set(group string, instances []Instance, now time.Time) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries[group] = discoveryEntry{fetched: now, instances: instances, cycle: c.cycle}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDiscoveryCache(t *testing.T) {
+	t.Parallel()
+	c := newDiscoveryCache(time.Minute)
+	instances := []Instance{{ID: "i-1", PrivateIP: "10.0.0.1"}}
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	c.newCycle(false)
+	if _, ok := c.get("group", start); ok {
+		t.Fatal("get() returned the instances of an unknown group")
+	}
+	c.set("group", instances, start)
+
+	tests := []struct {
+		now   time.Time
+		msg   string
+		cycle bool
+		fresh bool
+		want  bool
+	}{
+		{now: start.Add(2 * time.Minute), msg: "in the same cycle after the TTL", want: true},
+		{now: start.Add(30 * time.Second), cycle: true, msg: "in the next cycle within the TTL", want: true},
+		{now: start.Add(2 * time.Minute), cycle: true, msg: "in the next cycle after the TTL"},
+	}
+	for _, test := range tests {
+		if test.cycle {
+			c.newCycle(false)
+		}
+		if _, ok := c.get("group", test.now); ok != test.want {
+			t.Errorf("get() returned %v %v, expected %v", ok, test.msg, test.want)
+		}
+	}
+
+	c.set("group", instances, start)
+	c.newCycle(true)
+	if _, ok := c.get("group", start); ok {
+		t.Error("get() returned the cached instances in a fresh cycle")
+	}
+}
+
+func TestDiscoveryCacheWithoutTTL(t *testing.T) {
+	t.Parallel()
+	c := newDiscoveryCache(0)
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	c.newCycle(false)
+	c.set("group", []Instance{{ID: "i-1"}}, now)
+	if _, ok := c.get("group", now); !ok {
+		t.Error("get() didn't return the instances cached in the same cycle")
+	}
+
+	c.newCycle(false)
+	if _, ok := c.get("group", now); ok {
+		t.Error("get() returned the instances cached in the previous cycle without a TTL")
+	}
+
+	var nilCache *discoveryCache
+	nilCache.set("group", []Instance{{ID: "i-1"}}, now)
+	if _, ok := nilCache.get("group", now); ok {
+		t.Error("get() of a nil cache returned instances")
+	}
+}
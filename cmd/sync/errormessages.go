@@ -1,16 +1,54 @@
 package main
 
 const (
-	errorMsgFormat                 = "the mandatory field %v is either empty or missing in the config file"
-	intervalErrorMsg               = "the mandatory field sync_interval is either 0, negative or missing in the config file"
-	cloudProviderErrorMsg          = "the field cloud_provider has invalid value %v in the config file"
-	defaultCloudProvider           = "AWS"
-	upstreamNameErrorMsg           = "the mandatory field name is either empty or missing for an upstream in the config file"
-	upstreamErrorMsgFormat         = "the mandatory field %v is either empty or missing for the upstream %v in the config file"
-	upstreamPortErrorMsgFormat     = "the mandatory field port is either zero or missing for the upstream %v in the config file"
-	upstreamKindErrorMsgFormat     = "the mandatory field kind is either not equal to http or tcp or missing for the upstream %v in the config file"
-	upstreamMaxConnsErrorMsgFmt    = "the field max_conns has invalid value %v in the config file"
-	upstreamMaxFailsErrorMsgFmt    = "the field max_fails has invalid value %v in the config file"
-	upstreamFailTimeoutErrorMsgFmt = "the field fail_timeout has invalid value %v in the config file"
-	upstreamSlowStartErrorMsgFmt   = "the field slow_start has invalid value %v in the config file"
+	errorMsgFormat                      = "the mandatory field %v is either empty or missing in the config file"
+	intervalErrorMsg                    = "the mandatory field sync_interval is either 0, negative or missing in the config file"
+	discoveryCacheTTLErrorMsgFmt        = "the field discovery_cache_ttl has invalid value %v in the config file, it can't be negative"
+	cloudProviderErrorMsg               = "the field cloud_provider has invalid value %v in the config file"
+	defaultCloudProvider                = "AWS"
+	upstreamDiscoveryKindErrorMsgFmt    = "the field kind of upstream_discovery has invalid value %v in the config file, valid values are http and stream"
+	upstreamsErrorMsg                   = "there are no upstreams found in the config file and upstream_discovery isn't configured"
+	upstreamDuplicateErrorMsgFmt        = "the upstream %v of kind %v is defined more than once in the config file"
+	upstreamNameErrorMsg                = "the mandatory field name is either empty or missing for an upstream in the config file"
+	upstreamErrorMsgFormat              = "the mandatory field %v is either empty or missing for the upstream %v in the config file"
+	upstreamPortErrorMsgFormat          = "the mandatory field port is either zero or missing for the upstream %v in the config file"
+	upstreamKindErrorMsgFormat          = "the mandatory field kind is either not equal to http or stream or missing for the upstream %v in the config file"
+	upstreamMaxConnsErrorMsgFmt         = "the field max_conns has invalid value %v in the config file"
+	upstreamMaxFailsErrorMsgFmt         = "the field max_fails has invalid value %v in the config file"
+	upstreamFailTimeoutErrorMsgFmt      = "the field fail_timeout has invalid value %v in the config file"
+	upstreamSlowStartErrorMsgFmt        = "the field slow_start has invalid value %v in the config file"
+	upstreamLBMethodErrorMsgFmt         = "the field load_balancing_method has invalid value %v for the upstream %v in the config file"
+	upstreamBackupErrorMsgFmt           = "backup servers can't be used with the load balancing method %v of the upstream %v"
+	upstreamCrossZoneErrorMsgFmt        = "the field cross_zone has invalid value %v for the upstream %v in the config file, valid values are backup and weight"
+	upstreamSameZoneWeightErrorMsgFmt   = "the field same_zone_weight has invalid value %v for the upstream %v in the config file, it must be at least 2"
+	upstreamMinSameZoneErrorMsgFmt      = "the field min_same_zone_servers has invalid value %v for the upstream %v in the config file"
+	upstreamDrainTimeoutErrorMsgFmt     = "the field drain_timeout has invalid value %v for the upstream %v in the config file, it can't be negative and can only be set for http upstreams"
+	upstreamMinInstanceAgeErrorMsgFmt   = "the field min_instance_age has invalid value %v for the upstream %v in the config file, it can't be negative"
+	upstreamProbeTypeErrorMsgFmt        = "the field type of health_probe has invalid value %v for the upstream %v in the config file, valid values are tcp and http"
+	upstreamProbePathErrorMsgFmt        = "the field path of health_probe has invalid value %v for the upstream %v in the config file, it must start with /"
+	upstreamProbeErrorMsgFmt            = "the fields timeout, port, expected_status and unhealthy_threshold of health_probe have invalid values for the upstream %v in the config file"
+	upstreamDeviceIndexErrorMsgFmt      = "the field device_index of address_selection has invalid value %v for the upstream %v in the config file, it can't be negative"
+	upstreamSubnetCIDRErrorMsgFmt       = "the field subnet_cidr of address_selection has invalid value %v for the upstream %v in the config file"
+	upstreamAddressSelectionErrorMsgFmt = "the field %v of address_selection isn't supported by the cloud provider for the upstream %v in the config file"
+	upstreamInstanceTagsErrorMsgFmt     = "the fields include and exclude of instance_tags must not both be empty and can't have empty tag keys for the upstream %v in the config file"
+	upstreamFeedbackErrorMsgFmt         = "the field unhealthy_duration of unhealthy_feedback must be positive and the fields report_window and max_reports can't be negative for the upstream %v in the config file"
+	headerSecretErrorMsgFmt             = "exactly one of the fields env and file must be set for the custom header secret %v in the config file"
+	apiAuthErrorMsg                     = "the field entra_id is either empty or missing for api_auth in the config file"
+	apiAuthHeaderErrorMsg               = "the Authorization header can't be set in custom_headers or custom_header_secrets together with api_auth in the config file"
+	logFormatErrorMsgFmt                = "the field log_format has invalid value %v in the config file, valid values are text and json"
+	logLevelErrorMsgFmt                 = "the field log_level has invalid value %v in the config file, valid values are debug, info, warn and error"
+	retryMaxAttemptsErrorMsg            = "the field max_attempts of retry can't be negative in the config file"
+	retryDelayErrorMsg                  = "the fields base_delay and max_delay of retry can't be negative and max_delay can't be less than base_delay in the config file"
+	retryJitterErrorMsg                 = "the field jitter of retry must be between 0 and 1 in the config file"
+	apiTLSCertErrorMsg                  = "the fields cert_file and key_file of api_tls must be set together in the config file"
+	apiTLSMinVersionErrorMsgFmt         = "the field min_version of api_tls has invalid value %v in the config file, valid values are 1.0, 1.1, 1.2 and 1.3"
+	adminListenErrorMsgFmt              = "the field admin_listen has invalid value %v in the config file, it must be an address like 127.0.0.1:8090"
+	tracingEndpointErrorMsgFmt          = "the field endpoint of tracing has invalid value %v in the config file, it must be an http or https URL"
+	tracingSampleRatioErrorMsgFmt       = "the field sample_ratio of tracing has invalid value %v in the config file, it must be between 0 and 1"
+	webhookURLErrorMsg                  = "the field url of a webhook must be an http or https URL in the config file"
+	webhookFormatErrorMsgFmt            = "the field format of a webhook has invalid value %v in the config file, valid values are generic and slack"
+	ownershipStoreErrorMsg              = "the field keyval_zone of ownership or the field state_path must be set in the config file"
+	massRemovalErrorMsg                 = "the field max_percent of mass_removal must be between 1 and 99 and the field delay can't be negative in the config file"
+	leaderElectionStoreErrorMsg         = "exactly one of the fields keyval_zone and lock_file must be set for leader_election in the config file"
+	leaderElectionLeaseErrorMsgFmt      = "the field lease_duration of leader_election has invalid value %v in the config file, it must be greater than sync_interval"
 )
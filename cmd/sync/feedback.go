@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"slices"
+	"time"
+)
+
+const (
+	// peerStateUnhealthy is the state of an NGINX Plus peer that fails the active health checks.
+	peerStateUnhealthy = "unhealthy"
+
+	defaultFeedbackMaxReports   = 1
+	defaultFeedbackReportWindow = 10 * time.Minute
+)
+
+// unhealthyFeedbackConfig configures the reports of the instances that NGINX Plus considers unhealthy to the scaling
+// group, so that the scaling group replaces or repairs them.
+type unhealthyFeedbackConfig struct {
+	UnhealthyDuration time.Duration `yaml:"unhealthy_duration"`
+	ReportWindow      time.Duration `yaml:"report_window,omitempty"`
+	MaxReports        int           `yaml:"max_reports,omitempty"`
+}
+
+func validateUnhealthyFeedbackConfig(cfg *unhealthyFeedbackConfig, upstreamName string) error {
+	if cfg.UnhealthyDuration <= 0 || cfg.ReportWindow < 0 || cfg.MaxReports < 0 {
+		return fmt.Errorf(upstreamFeedbackErrorMsgFmt, upstreamName)
+	}
+	return nil
+}
+
+// errReportLimit is returned by reportInstance when the limit of reports of the scaling group is reached.
+var errReportLimit = errors.New("the limit of reports of the scaling group is reached")
+
+// unhealthyTracker tracks for how long the servers of the upstreams are unhealthy in NGINX Plus and reports the
+// instances that stay unhealthy for unhealthy_duration to the scaling group, at most max_reports within report_window
+// for every scaling group, so that the upstreams of the same group can't replace more of its instances together.
+// Every instance is reported once.
+type unhealthyTracker struct {
+	since    map[upstreamKey]map[string]time.Time
+	reported map[upstreamKey]map[string]time.Time
+	reports  map[string][]time.Time
+}
+
+func newUnhealthyTracker() *unhealthyTracker {
+	return &unhealthyTracker{
+		since:    make(map[upstreamKey]map[string]time.Time),
+		reported: make(map[upstreamKey]map[string]time.Time),
+		reports:  make(map[string][]time.Time),
+	}
+}
+
+// reportFunc reports the unhealthy instance of the scaling group.
+type reportFunc func(ctx context.Context, group string, id string) error
+
+// update records the states of the servers of the upstream, given by their addresses, and reports the instances that
+// have been unhealthy for long enough. instanceIDs are the IDs of the discovered instances by the addresses of their
+// servers. The instances are looked up in the scaling group and then in the backup scaling group of the upstream.
+func (t *unhealthyTracker) update(ctx context.Context, upstream Upstream, states, instanceIDs map[string]string, now time.Time, report reportFunc) {
+	cfg := upstream.UnhealthyFeedback
+	key := upstreamKey{name: upstream.Name, kind: upstream.Kind}
+
+	since := make(map[string]time.Time)
+	for address, state := range states {
+		if state != peerStateUnhealthy {
+			continue
+		}
+		start, ok := t.since[key][address]
+		if !ok {
+			start = now
+		}
+		since[address] = start
+	}
+	t.since[key] = since
+
+	// the instances that are gone are forgotten
+	reported := make(map[string]time.Time)
+	for _, id := range instanceIDs {
+		if at, ok := t.reported[key][id]; ok {
+			reported[id] = at
+		}
+	}
+	t.reported[key] = reported
+
+	window := cfg.ReportWindow
+	if window == 0 {
+		window = defaultFeedbackReportWindow
+	}
+	maxReports := cfg.MaxReports
+	if maxReports == 0 {
+		maxReports = defaultFeedbackMaxReports
+	}
+	limited := func(group string) bool {
+		var recent []time.Time
+		for _, at := range t.reports[group] {
+			if now.Sub(at) < window {
+				recent = append(recent, at)
+			}
+		}
+		t.reports[group] = recent
+		return len(recent) >= maxReports
+	}
+
+	for _, address := range slices.Sorted(maps.Keys(since)) {
+		start := since[address]
+		id, ok := instanceIDs[address]
+		if !ok || now.Sub(start) < cfg.UnhealthyDuration {
+			continue
+		}
+		if _, ok := reported[id]; ok {
+			continue
+		}
+		group, err := reportInstance(ctx, upstream, id, limited, report)
+		if errors.Is(err, errReportLimit) {
+			slog.Warn("Not reporting an unhealthy instance, the limit of reports of the scaling group is reached",
+				"upstream", upstream.Name, "kind", upstream.Kind, "scaling_group", group, "instance_id", id,
+				"server", address, "max_reports", maxReports, "report_window", window)
+			continue
+		}
+		if err != nil {
+			slog.Error("Couldn't report an unhealthy instance", "upstream", upstream.Name, "kind", upstream.Kind,
+				"instance_id", id, "server", address, "error", err)
+			continue
+		}
+		slog.Warn("Reported an unhealthy instance to the scaling group", "upstream", upstream.Name, "kind", upstream.Kind,
+			"scaling_group", group, "instance_id", id, "server", address, "unhealthy_since", start)
+		reported[id] = now
+		t.reports[group] = append(t.reports[group], now)
+	}
+}
+
+// reportInstance reports the instance to the scaling group of the upstream it belongs to and returns the group. A
+// group that reached the limit of reports is skipped; if the instance isn't found in the other group, reportInstance
+// returns errReportLimit with the skipped group.
+func reportInstance(ctx context.Context, upstream Upstream, id string, limited func(group string) bool, report reportFunc) (string, error) {
+	groups := []string{upstream.ScalingGroup}
+	if upstream.BackupScalingGroup != "" {
+		groups = append(groups, upstream.BackupScalingGroup)
+	}
+
+	skipped := ""
+	var err error
+	for _, group := range groups {
+		if limited(group) {
+			skipped = group
+			continue
+		}
+		err = report(ctx, group, id)
+		if !errors.Is(err, errInstanceNotFound) {
+			return group, err
+		}
+	}
+	if skipped != "" {
+		return skipped, errReportLimit
+	}
+	return upstream.ScalingGroup, err
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestValidateUnhealthyFeedbackConfig(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		msg     string
+		cfg     unhealthyFeedbackConfig
+		wantErr bool
+	}{
+		{cfg: unhealthyFeedbackConfig{UnhealthyDuration: time.Minute}, msg: "unhealthy duration"},
+		{cfg: unhealthyFeedbackConfig{UnhealthyDuration: time.Minute, MaxReports: 2, ReportWindow: time.Hour}, msg: "rate limit"},
+		{cfg: unhealthyFeedbackConfig{}, msg: "no unhealthy duration", wantErr: true},
+		{cfg: unhealthyFeedbackConfig{UnhealthyDuration: time.Minute, MaxReports: -1}, msg: "negative max reports", wantErr: true},
+		{cfg: unhealthyFeedbackConfig{UnhealthyDuration: time.Minute, ReportWindow: -time.Hour}, msg: "negative report window", wantErr: true},
+	}
+
+	for _, test := range tests {
+		err := validateUnhealthyFeedbackConfig(&test.cfg, "backend")
+		if (err != nil) != test.wantErr {
+			t.Errorf("validateUnhealthyFeedbackConfig() returned %v for the case of %v", err, test.msg)
+		}
+	}
+}
+
+func TestUnhealthyTrackerUpdate(t *testing.T) {
+	t.Parallel()
+	upstream := Upstream{
+		Name:              "backend",
+		Kind:              "http",
+		ScalingGroup:      "group",
+		UnhealthyFeedback: &unhealthyFeedbackConfig{UnhealthyDuration: time.Minute, ReportWindow: 10 * time.Minute},
+	}
+	instanceIDs := map[string]string{"10.0.0.1:80": "i-1", "10.0.0.2:80": "i-2"}
+	var reported []string
+	report := func(_ context.Context, _ string, id string) error {
+		reported = append(reported, id)
+		return nil
+	}
+	tracker := newUnhealthyTracker()
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	ctx := context.Background()
+
+	update := func(states map[string]string, now time.Time, want []string) {
+		t.Helper()
+		tracker.update(ctx, upstream, states, instanceIDs, now, report)
+		if !reflect.DeepEqual(reported, want) {
+			t.Errorf("update() at %v reported %v, expected %v", now.Sub(start), reported, want)
+		}
+	}
+
+	unhealthy := map[string]string{"10.0.0.1:80": "unhealthy", "10.0.0.2:80": "unhealthy"}
+	update(unhealthy, start, nil)
+	update(unhealthy, start.Add(30*time.Second), nil)
+
+	// a server that recovers starts over
+	update(map[string]string{"10.0.0.1:80": "unhealthy", "10.0.0.2:80": "up"}, start.Add(40*time.Second), nil)
+
+	// only one instance is reported within the window
+	update(unhealthy, start.Add(time.Minute), []string{"i-1"})
+	update(unhealthy, start.Add(2*time.Minute), []string{"i-1"})
+
+	// the reported instance isn't reported again
+	update(unhealthy, start.Add(11*time.Minute), []string{"i-1", "i-2"})
+	update(unhealthy, start.Add(30*time.Minute), []string{"i-1", "i-2"})
+}
+
+func TestUnhealthyTrackerUpdateLimitsByScalingGroup(t *testing.T) {
+	t.Parallel()
+	feedback := &unhealthyFeedbackConfig{UnhealthyDuration: time.Minute}
+	httpUpstream := Upstream{Name: "backend", Kind: "http", ScalingGroup: "group", BackupScalingGroup: "backup", UnhealthyFeedback: feedback}
+	streamUpstream := Upstream{Name: "backend", Kind: "stream", ScalingGroup: "group", UnhealthyFeedback: feedback}
+	groups := map[string]string{"i-1": "group", "i-2": "group", "i-3": "backup"}
+	var reported []string
+	report := func(_ context.Context, group string, id string) error {
+		if groups[id] != group {
+			return errInstanceNotFound
+		}
+		reported = append(reported, id)
+		return nil
+	}
+	tracker := newUnhealthyTracker()
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	ctx := context.Background()
+
+	httpIDs := map[string]string{"10.0.0.1:80": "i-1", "10.0.0.3:80": "i-3"}
+	httpStates := map[string]string{"10.0.0.1:80": "unhealthy", "10.0.0.3:80": "unhealthy"}
+	streamIDs := map[string]string{"10.0.0.2:80": "i-2"}
+	streamStates := map[string]string{"10.0.0.2:80": "unhealthy"}
+	for _, now := range []time.Time{start, start.Add(time.Minute)} {
+		tracker.update(ctx, httpUpstream, httpStates, httpIDs, now, report)
+		tracker.update(ctx, streamUpstream, streamStates, streamIDs, now, report)
+	}
+
+	// the upstreams share the limit of the group, and the instance of the backup group is still reported
+	want := []string{"i-1", "i-3"}
+	if !reflect.DeepEqual(reported, want) {
+		t.Errorf("update() reported %v, expected %v", reported, want)
+	}
+}
@@ -0,0 +1,311 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"syscall"
+	"time"
+
+	nginx "github.com/nginx/nginx-plus-go-client/v3/client"
+)
+
+// leaderKey is the key of the lease in the key-value zone of leader election.
+const leaderKey = "leader"
+
+// defaultLeaseIntervals is the default lease duration in sync intervals.
+const defaultLeaseIntervals = 3
+
+// errNotLeader is returned for the syncs requested from a replica that is not the leader.
+var errNotLeader = errors.New("this replica is not the leader")
+
+// leaderElectionConfig enables leader election, so that only one of the replicas of nginx-asg-sync syncs the upstreams.
+// The lease is stored either in an NGINX Plus key-value zone or held as a lock of a local file.
+type leaderElectionConfig struct {
+	KeyvalZone    string        `yaml:"keyval_zone,omitempty"`
+	LockFile      string        `yaml:"lock_file,omitempty"`
+	LeaseDuration time.Duration `yaml:"lease_duration,omitempty"`
+}
+
+func validateLeaderElectionConfig(cfg *leaderElectionConfig, syncInterval time.Duration) error {
+	if (cfg.KeyvalZone == "") == (cfg.LockFile == "") {
+		return errors.New(leaderElectionStoreErrorMsg)
+	}
+
+	if cfg.LeaseDuration < 0 || (cfg.LeaseDuration > 0 && cfg.LeaseDuration <= syncInterval) {
+		return fmt.Errorf(leaderElectionLeaseErrorMsgFmt, cfg.LeaseDuration)
+	}
+
+	return nil
+}
+
+// leaderElector acquires the leadership among the replicas of nginx-asg-sync.
+type leaderElector interface {
+	// Elect acquires or renews the leadership and reports whether this replica is the leader.
+	Elect(ctx context.Context) (bool, error)
+	// Resign gives up the leadership, so that another replica can take over without waiting for the lease to expire.
+	Resign(ctx context.Context) error
+}
+
+// newLeaderElector creates the leaderElector configured in cfg.
+func newLeaderElector(cfg *leaderElectionConfig, client keyvalClient, syncInterval time.Duration) leaderElector {
+	if cfg.LockFile != "" {
+		return &fileLeaderElector{path: cfg.LockFile}
+	}
+
+	return &keyvalLeaderElector{
+		client:        client,
+		now:           time.Now,
+		zone:          cfg.KeyvalZone,
+		identity:      replicaIdentity(),
+		leaseDuration: leaseDuration(cfg, syncInterval),
+	}
+}
+
+// leaseDuration returns how long the lease configured in cfg lasts without a renewal: lease_duration or, by default,
+// defaultLeaseIntervals sync intervals for a key-value zone. The lock of a file doesn't expire, so its duration is 0.
+func leaseDuration(cfg *leaderElectionConfig, syncInterval time.Duration) time.Duration {
+	switch {
+	case cfg.LockFile != "":
+		return 0
+	case cfg.LeaseDuration > 0:
+		return cfg.LeaseDuration
+	default:
+		return defaultLeaseIntervals * syncInterval
+	}
+}
+
+// replicaIdentity identifies this replica in the lease: the host name and the process ID.
+func replicaIdentity() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+	return fmt.Sprintf("%v-%d", hostname, os.Getpid())
+}
+
+type leaderLease struct {
+	Expires time.Time `json:"expires"`
+	Holder  string    `json:"holder"`
+}
+
+// keyvalLeaderElector stores the lease as a JSON value in an HTTP key-value zone. The leader renews the lease in every
+// sync cycle. The other replicas take over the lease when it expires, so the clocks of the replicas must be synchronized.
+// The key-value API has no compare-and-swap, so the election is best-effort: the replicas that take over an expired
+// lease at the same moment can both consider themselves the leader until one of them renews the lease.
+type keyvalLeaderElector struct {
+	client        keyvalClient
+	now           func() time.Time
+	zone          string
+	identity      string
+	leaseDuration time.Duration
+}
+
+func (e *keyvalLeaderElector) Elect(ctx context.Context) (bool, error) {
+	now := e.now()
+
+	lease, exists, err := e.read(ctx)
+	if err != nil {
+		return false, err
+	}
+	if exists && lease.Holder != e.identity && now.Before(lease.Expires) {
+		return false, nil
+	}
+
+	data, err := json.Marshal(leaderLease{Holder: e.identity, Expires: now.Add(e.leaseDuration)})
+	if err != nil {
+		return false, fmt.Errorf("couldn't marshal the lease: %w", err)
+	}
+
+	if exists {
+		err = e.client.ModifyKeyValPair(ctx, e.zone, leaderKey, string(data))
+	} else {
+		err = e.client.AddKeyValPair(ctx, e.zone, leaderKey, string(data))
+		// another replica created the lease first
+		var statusErr nginx.StatusError
+		if errors.As(err, &statusErr) && statusErr.Status() == http.StatusConflict {
+			return false, nil
+		}
+	}
+	if err != nil {
+		return false, fmt.Errorf("couldn't store the lease in the zone %v: %w", e.zone, err)
+	}
+
+	// the key-value store has no compare-and-swap, so the replicas that took over an expired lease at the same time
+	// read it back: the last write wins, unless the other write lands after the read
+	lease, exists, err = e.read(ctx)
+	if err != nil {
+		return false, err
+	}
+	return exists && lease.Holder == e.identity, nil
+}
+
+func (e *keyvalLeaderElector) Resign(ctx context.Context) error {
+	lease, exists, err := e.read(ctx)
+	if err != nil {
+		return err
+	}
+	if !exists || lease.Holder != e.identity {
+		return nil
+	}
+
+	if err := e.client.DeleteKeyValuePair(ctx, e.zone, leaderKey); err != nil {
+		return fmt.Errorf("couldn't delete the lease from the zone %v: %w", e.zone, err)
+	}
+	return nil
+}
+
+// read returns the lease stored in the zone. A lease that can't be parsed is returned as expired, so that it's replaced.
+func (e *keyvalLeaderElector) read(ctx context.Context) (leaderLease, bool, error) {
+	pairs, err := e.client.GetKeyValPairs(ctx, e.zone)
+	if err != nil {
+		return leaderLease{}, false, fmt.Errorf("couldn't get the key-value pairs of the zone %v: %w", e.zone, err)
+	}
+
+	value, exists := pairs[leaderKey]
+	if !exists {
+		return leaderLease{}, false, nil
+	}
+
+	var lease leaderLease
+	if err := json.Unmarshal([]byte(value), &lease); err != nil {
+		slog.Warn("Couldn't parse the lease, replacing it", "keyval_zone", e.zone, "error", err)
+		return leaderLease{}, true, nil
+	}
+	return lease, true, nil
+}
+
+// fileLeaderElector holds an exclusive lock of a local file while it's the leader. The lock is released by the
+// operating system when the process exits, so another replica on the same host takes over immediately.
+type fileLeaderElector struct {
+	file *os.File
+	path string
+}
+
+func (e *fileLeaderElector) Elect(context.Context) (bool, error) {
+	if e.file != nil {
+		return true, nil
+	}
+
+	f, err := os.OpenFile(e.path, os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return false, fmt.Errorf("couldn't open the lock file %v: %w", e.path, err)
+	}
+
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		f.Close()
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			return false, nil
+		}
+		return false, fmt.Errorf("couldn't lock the lock file %v: %w", e.path, err)
+	}
+
+	// the holder is written to the file only for the operators
+	if err := f.Truncate(0); err == nil {
+		_, _ = f.WriteAt([]byte(replicaIdentity()+"\n"), 0)
+	}
+
+	e.file = f
+	return true, nil
+}
+
+func (e *fileLeaderElector) Resign(context.Context) error {
+	if e.file == nil {
+		return nil
+	}
+
+	err := syscall.Flock(int(e.file.Fd()), syscall.LOCK_UN)
+	e.file.Close()
+	e.file = nil
+	if err != nil {
+		return fmt.Errorf("couldn't unlock the lock file %v: %w", e.path, err)
+	}
+	return nil
+}
+
+// leadership tracks whether this replica is the leader. A nil leadership means leader election is disabled and the
+// replica always syncs.
+type leadership struct {
+	elector leaderElector
+	now     func() time.Time
+	// expires is when the lease acquired or renewed last expires, if the lease has a duration.
+	expires time.Time
+	lease   time.Duration
+	leader  bool
+}
+
+// newLeadership creates the leadership of the leader election configured in cfg.
+func newLeadership(cfg *leaderElectionConfig, client keyvalClient, syncInterval time.Duration) *leadership {
+	return &leadership{
+		elector: newLeaderElector(cfg, client, syncInterval),
+		now:     time.Now,
+		lease:   leaseDuration(cfg, syncInterval),
+	}
+}
+
+// check acquires or renews the leadership and reports whether this replica is the leader. A replica that can't renew
+// its lease stops syncing, since another replica can take over when the lease expires.
+func (l *leadership) check(ctx context.Context) bool {
+	if l == nil {
+		return true
+	}
+
+	// the lease is counted from before the call, since it can be written at any moment of the call
+	start := l.now()
+	leader, err := l.elector.Elect(ctx)
+	if err != nil {
+		slog.Error("Couldn't acquire the leadership", "error", err)
+		leader = false
+	}
+	if leader {
+		l.expires = start.Add(l.lease)
+	}
+
+	switch {
+	case leader && !l.leader:
+		slog.Info("Became the leader, syncing the upstreams")
+	case !leader && l.leader:
+		slog.Warn("Lost the leadership, standing by")
+	}
+	l.leader = leader
+
+	return leader
+}
+
+// ensure returns errNotLeader if this replica is no longer the leader. It is called before every update of NGINX Plus,
+// so that a sync cycle that lasts longer than the lease doesn't overlap with another leader: the lease is renewed when
+// less than half of it is left.
+func (l *leadership) ensure(ctx context.Context) error {
+	if l == nil {
+		return nil
+	}
+	if !l.leader {
+		return errNotLeader
+	}
+	if l.lease == 0 || l.expires.Sub(l.now()) > l.lease/2 {
+		return nil
+	}
+
+	if !l.check(ctx) {
+		return errNotLeader
+	}
+	return nil
+}
+
+// resign gives up the leadership, if this replica is the leader.
+func (l *leadership) resign(ctx context.Context) {
+	if l == nil || !l.leader {
+		return
+	}
+
+	if err := l.elector.Resign(ctx); err != nil {
+		slog.Error("Couldn't give up the leadership", "error", err)
+		return
+	}
+	l.leader = false
+	slog.Info("Gave up the leadership")
+}
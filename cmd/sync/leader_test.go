@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	nginx "github.com/nginx/nginx-plus-go-client/v3/client"
+)
+
+func TestValidateLeaderElectionConfig(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		msg     string
+		cfg     leaderElectionConfig
+		wantErr bool
+	}{
+		{cfg: leaderElectionConfig{KeyvalZone: "leader"}, msg: "keyval zone"},
+		{cfg: leaderElectionConfig{LockFile: "/run/nginx-asg-sync.lock"}, msg: "lock file"},
+		{cfg: leaderElectionConfig{KeyvalZone: "leader", LeaseDuration: time.Minute}, msg: "lease duration"},
+		{cfg: leaderElectionConfig{}, msg: "no store", wantErr: true},
+		{cfg: leaderElectionConfig{KeyvalZone: "leader", LockFile: "/run/nginx-asg-sync.lock"}, msg: "both stores", wantErr: true},
+		{cfg: leaderElectionConfig{KeyvalZone: "leader", LeaseDuration: 5 * time.Second}, msg: "lease shorter than the interval", wantErr: true},
+		{cfg: leaderElectionConfig{KeyvalZone: "leader", LeaseDuration: -time.Minute}, msg: "negative lease", wantErr: true},
+	}
+
+	for _, test := range tests {
+		err := validateLeaderElectionConfig(&test.cfg, 10*time.Second)
+		if (err != nil) != test.wantErr {
+			t.Errorf("validateLeaderElectionConfig() returned %v for the case of %v", err, test.msg)
+		}
+	}
+}
+
+func newTestKeyvalLeaderElector(client keyvalClient, identity string, now *time.Time) *keyvalLeaderElector {
+	return &keyvalLeaderElector{
+		client:        client,
+		now:           func() time.Time { return *now },
+		zone:          "leader",
+		identity:      identity,
+		leaseDuration: 30 * time.Second,
+	}
+}
+
+func TestKeyvalLeaderElector(t *testing.T) {
+	t.Parallel()
+	client := &fakeKeyvalClient{pairs: map[string]nginx.KeyValPairs{}}
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	first := newTestKeyvalLeaderElector(client, "first", &now)
+	second := newTestKeyvalLeaderElector(client, "second", &now)
+	ctx := context.Background()
+
+	elect := func(e *keyvalLeaderElector, want bool) {
+		t.Helper()
+		leader, err := e.Elect(ctx)
+		if err != nil {
+			t.Fatalf("Elect() failed: %v", err)
+		}
+		if leader != want {
+			t.Errorf("Elect() for %v returned %v at %v, expected %v", e.identity, leader, now, want)
+		}
+	}
+
+	elect(first, true)
+	elect(second, false)
+
+	// the leader renews the lease
+	now = now.Add(20 * time.Second)
+	elect(first, true)
+	now = now.Add(20 * time.Second)
+	elect(second, false)
+
+	// the leader stops renewing the lease and the standby takes over when it expires
+	now = now.Add(20 * time.Second)
+	elect(second, true)
+	elect(first, false)
+
+	// the leader resigns and the standby takes over immediately
+	if err := second.Resign(ctx); err != nil {
+		t.Fatalf("Resign() failed: %v", err)
+	}
+	elect(first, true)
+}
+
+func TestKeyvalLeaderElectorReplacesInvalidLease(t *testing.T) {
+	t.Parallel()
+	client := &fakeKeyvalClient{pairs: map[string]nginx.KeyValPairs{"leader": {leaderKey: "garbage"}}}
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	e := newTestKeyvalLeaderElector(client, "first", &now)
+
+	leader, err := e.Elect(context.Background())
+	if err != nil {
+		t.Fatalf("Elect() failed: %v", err)
+	}
+	if !leader {
+		t.Errorf("Elect() didn't replace the invalid lease %q", client.pairs["leader"][leaderKey])
+	}
+}
+
+func TestFileLeaderElector(t *testing.T) {
+	t.Parallel()
+	path := filepath.Join(t.TempDir(), "leader.lock")
+	first := &fileLeaderElector{path: path}
+	second := &fileLeaderElector{path: path}
+	ctx := context.Background()
+
+	for _, test := range []struct {
+		e    *fileLeaderElector
+		msg  string
+		want bool
+	}{
+		{e: first, want: true, msg: "the first replica"},
+		{e: second, want: false, msg: "the second replica while the first holds the lock"},
+		{e: first, want: true, msg: "the first replica again"},
+	} {
+		leader, err := test.e.Elect(ctx)
+		if err != nil {
+			t.Fatalf("Elect() failed for %v: %v", test.msg, err)
+		}
+		if leader != test.want {
+			t.Errorf("Elect() returned %v for %v, expected %v", leader, test.msg, test.want)
+		}
+	}
+
+	if err := first.Resign(ctx); err != nil {
+		t.Fatalf("Resign() failed: %v", err)
+	}
+	leader, err := second.Elect(ctx)
+	if err != nil {
+		t.Fatalf("Elect() failed: %v", err)
+	}
+	if !leader {
+		t.Error("Elect() didn't acquire the lock released by the first replica")
+	}
+	if err := second.Resign(ctx); err != nil {
+		t.Fatalf("Resign() failed: %v", err)
+	}
+}
+
+// fakeLeaderElector elects this replica while leader is true and counts the elections.
+type fakeLeaderElector struct {
+	calls  int
+	leader bool
+}
+
+func (e *fakeLeaderElector) Elect(context.Context) (bool, error) {
+	e.calls++
+	return e.leader, nil
+}
+
+func (e *fakeLeaderElector) Resign(context.Context) error {
+	e.leader = false
+	return nil
+}
+
+func TestLeadershipEnsure(t *testing.T) {
+	t.Parallel()
+	elector := &fakeLeaderElector{leader: true}
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	l := &leadership{elector: elector, now: func() time.Time { return now }, lease: 30 * time.Second}
+	ctx := context.Background()
+
+	if !l.check(ctx) {
+		t.Fatal("check() didn't acquire the leadership")
+	}
+
+	// more than half of the lease is left
+	now = now.Add(10 * time.Second)
+	if err := l.ensure(ctx); err != nil || elector.calls != 1 {
+		t.Errorf("ensure() returned %v after %d elections, expected no renewal", err, elector.calls)
+	}
+
+	// the lease is about to expire and is renewed
+	now = now.Add(10 * time.Second)
+	if err := l.ensure(ctx); err != nil || elector.calls != 2 {
+		t.Errorf("ensure() returned %v after %d elections, expected a renewal", err, elector.calls)
+	}
+
+	// the renewal fails, because another replica took over the lease
+	elector.leader = false
+	now = now.Add(20 * time.Second)
+	if err := l.ensure(ctx); !errors.Is(err, errNotLeader) {
+		t.Errorf("ensure() returned %v after the lease was lost, expected %v", err, errNotLeader)
+	}
+	if err := l.ensure(ctx); !errors.Is(err, errNotLeader) || elector.calls != 3 {
+		t.Errorf("ensure() returned %v after %d elections for a replica that isn't the leader", err, elector.calls)
+	}
+
+	// the lock of a file doesn't expire
+	l = &leadership{elector: &fakeLeaderElector{leader: true}, now: func() time.Time { return now }}
+	l.check(ctx)
+	now = now.Add(time.Hour)
+	if err := l.ensure(ctx); err != nil {
+		t.Errorf("ensure() returned %v for a lease without a duration", err)
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// logWriter writes the log to the file of -log_path. The file is reopened on SIGUSR1 and when it is moved or deleted
+// by another tool, for example logrotate, so that the log is never written to a file that is no longer at the path.
+// Optionally, the file is rotated when it exceeds the max size or age, keeping the maxBackups most recent files as
+// path.1, path.2 and so on.
+type logWriter struct {
+	openedAt   time.Time
+	file       *os.File
+	now        func() time.Time
+	path       string
+	size       int64
+	maxSize    int64
+	maxAge     time.Duration
+	maxBackups int
+	mu         sync.Mutex
+}
+
+// newLogWriter opens the log file. A zero maxSize or maxAge disables the rotation by size or age.
+func newLogWriter(path string, maxSize int64, maxAge time.Duration, maxBackups int) (*logWriter, error) {
+	w := &logWriter{
+		now:        time.Now,
+		path:       path,
+		maxSize:    maxSize,
+		maxAge:     maxAge,
+		maxBackups: maxBackups,
+	}
+	if err := w.openLocked(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// Write writes a log entry to the file, reopening or rotating the file first if needed. If the file can't be
+// reopened or rotated, the entry is written to the previously opened file and it is tried again on the next write.
+func (w *logWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if !w.isCurrentLocked() {
+		_ = w.openLocked()
+	}
+
+	if w.shouldRotateLocked(len(p)) {
+		_ = w.rotateLocked()
+	}
+
+	n, err := w.file.Write(p)
+	w.size += int64(n)
+	if err != nil {
+		return n, fmt.Errorf("couldn't write to the log file: %w", err)
+	}
+	return n, nil
+}
+
+// Reopen closes the log file and opens the file at the path again.
+func (w *logWriter) Reopen() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.openLocked()
+}
+
+// openLocked opens the file at the path. The previously opened file is closed only if the new one was opened.
+func (w *logWriter) openLocked() error {
+	f, err := os.OpenFile(w.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return fmt.Errorf("couldn't open the log file: %w", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("couldn't access the log file: %w", err)
+	}
+
+	if w.file != nil {
+		w.file.Close()
+	}
+	w.file = f
+	w.size = info.Size()
+	w.openedAt = w.now()
+	return nil
+}
+
+// isCurrentLocked reports whether the opened file is still the file at the path.
+func (w *logWriter) isCurrentLocked() bool {
+	pathInfo, err := os.Stat(w.path)
+	if err != nil {
+		return false
+	}
+	fileInfo, err := w.file.Stat()
+	if err != nil {
+		return false
+	}
+	return os.SameFile(pathInfo, fileInfo)
+}
+
+func (w *logWriter) shouldRotateLocked(n int) bool {
+	if w.size == 0 {
+		return false
+	}
+	if w.maxSize > 0 && w.size+int64(n) > w.maxSize {
+		return true
+	}
+	return w.maxAge > 0 && w.now().Sub(w.openedAt) >= w.maxAge
+}
+
+// rotateLocked renames the log file to path.1, shifting the older backups and removing the ones beyond maxBackups,
+// and opens a new file at the path.
+func (w *logWriter) rotateLocked() error {
+	if w.maxBackups > 0 {
+		for i := w.maxBackups - 1; i > 0; i-- {
+			err := os.Rename(w.backupPath(i), w.backupPath(i+1))
+			if err != nil && !os.IsNotExist(err) {
+				return fmt.Errorf("couldn't rotate the log file: %w", err)
+			}
+		}
+		if err := os.Rename(w.path, w.backupPath(1)); err != nil {
+			return fmt.Errorf("couldn't rotate the log file: %w", err)
+		}
+	} else if err := os.Remove(w.path); err != nil {
+		return fmt.Errorf("couldn't rotate the log file: %w", err)
+	}
+
+	return w.openLocked()
+}
+
+func (w *logWriter) backupPath(i int) string {
+	return fmt.Sprintf("%v.%d", w.path, i)
+}
+
+// reopenLogOnSignal reopens the log file every time nginx-asg-sync receives SIGUSR1, for example from the postrotate
+// script of logrotate.
+func reopenLogOnSignal(w *logWriter) {
+	sigusr1 := make(chan os.Signal, 1)
+	signal.Notify(sigusr1, syscall.SIGUSR1)
+
+	for range sigusr1 {
+		if err := w.Reopen(); err != nil {
+			slog.Error("Couldn't reopen the log file", "path", w.path, "error", err)
+			continue
+		}
+		slog.Info("Reopened the log file", "path", w.path)
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func readLogFile(t *testing.T, path string) string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("couldn't read %v: %v", path, err)
+	}
+	return string(data)
+}
+
+func TestLogWriterReopen(t *testing.T) {
+	t.Parallel()
+	path := filepath.Join(t.TempDir(), "nginx-asg-sync.log")
+	w, err := newLogWriter(path, 0, 0, 0)
+	if err != nil {
+		t.Fatalf("newLogWriter() returned an error: %v", err)
+	}
+	defer w.file.Close()
+
+	if _, err := w.Write([]byte("before\n")); err != nil {
+		t.Fatalf("Write() returned an error: %v", err)
+	}
+
+	// logrotate without copytruncate: the file is renamed and a new one is created
+	if err := os.Rename(path, path+".1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Reopen(); err != nil {
+		t.Fatalf("Reopen() returned an error: %v", err)
+	}
+	if _, err := w.Write([]byte("after\n")); err != nil {
+		t.Fatalf("Write() returned an error: %v", err)
+	}
+
+	if got := readLogFile(t, path+".1"); got != "before\n" {
+		t.Errorf("the rotated file contains %q, expected %q", got, "before\n")
+	}
+	if got := readLogFile(t, path); got != "after\n" {
+		t.Errorf("the log file contains %q, expected %q", got, "after\n")
+	}
+}
+
+func TestLogWriterReopensMovedFile(t *testing.T) {
+	t.Parallel()
+	path := filepath.Join(t.TempDir(), "nginx-asg-sync.log")
+	w, err := newLogWriter(path, 0, 0, 0)
+	if err != nil {
+		t.Fatalf("newLogWriter() returned an error: %v", err)
+	}
+	defer w.file.Close()
+
+	if err := os.Rename(path, path+".1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write([]byte("after\n")); err != nil {
+		t.Fatalf("Write() returned an error: %v", err)
+	}
+
+	if got := readLogFile(t, path); got != "after\n" {
+		t.Errorf("the log file contains %q after it was moved, expected %q", got, "after\n")
+	}
+	if got := readLogFile(t, path+".1"); got != "" {
+		t.Errorf("the moved file contains %q, expected nothing", got)
+	}
+}
+
+func TestLogWriterRotateBySize(t *testing.T) {
+	t.Parallel()
+	path := filepath.Join(t.TempDir(), "nginx-asg-sync.log")
+	w, err := newLogWriter(path, 10, 0, 2)
+	if err != nil {
+		t.Fatalf("newLogWriter() returned an error: %v", err)
+	}
+	defer w.file.Close()
+
+	for _, line := range []string{"first\n", "second\n", "third\n", "fourth\n"} {
+		if _, err := w.Write([]byte(line)); err != nil {
+			t.Fatalf("Write() returned an error: %v", err)
+		}
+	}
+
+	expected := map[string]string{path: "fourth\n", path + ".1": "third\n", path + ".2": "second\n"}
+	for p, want := range expected {
+		if got := readLogFile(t, p); got != want {
+			t.Errorf("%v contains %q, expected %q", filepath.Base(p), got, want)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("the rotated file beyond the max backups wasn't removed: %v", err)
+	}
+}
+
+func TestLogWriterRotateByAge(t *testing.T) {
+	t.Parallel()
+	path := filepath.Join(t.TempDir(), "nginx-asg-sync.log")
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	w, err := newLogWriter(path, 0, 24*time.Hour, 1)
+	if err != nil {
+		t.Fatalf("newLogWriter() returned an error: %v", err)
+	}
+	defer w.file.Close()
+	w.now = func() time.Time { return now }
+	w.openedAt = now
+
+	if _, err := w.Write([]byte("first\n")); err != nil {
+		t.Fatalf("Write() returned an error: %v", err)
+	}
+	now = now.Add(23 * time.Hour)
+	if _, err := w.Write([]byte("second\n")); err != nil {
+		t.Fatalf("Write() returned an error: %v", err)
+	}
+	now = now.Add(time.Hour)
+	if _, err := w.Write([]byte("third\n")); err != nil {
+		t.Fatalf("Write() returned an error: %v", err)
+	}
+
+	if got := readLogFile(t, path+".1"); got != "first\nsecond\n" {
+		t.Errorf("the rotated file contains %q, expected %q", got, "first\nsecond\n")
+	}
+	if got := readLogFile(t, path); got != "third\n" {
+		t.Errorf("the log file contains %q, expected %q", got, "third\n")
+	}
+}
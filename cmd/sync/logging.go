@@ -0,0 +1,42 @@
+package main
+
+import (
+	"io"
+	"log/slog"
+	"strings"
+)
+
+const (
+	logFormatText = "text"
+	logFormatJSON = "json"
+)
+
+var logLevels = map[string]slog.Level{
+	"debug": slog.LevelDebug,
+	"info":  slog.LevelInfo,
+	"warn":  slog.LevelWarn,
+	"error": slog.LevelError,
+}
+
+func isValidLogFormat(format string) bool {
+	return format == "" || format == logFormatText || format == logFormatJSON
+}
+
+func isValidLogLevel(level string) bool {
+	_, ok := logLevels[strings.ToLower(level)]
+	return level == "" || ok
+}
+
+// newLogger creates the logger with the format (text or json) and the level (debug, info, warn or error).
+// Empty values mean text and info.
+func newLogger(w io.Writer, format, level string) *slog.Logger {
+	opts := &slog.HandlerOptions{Level: slog.LevelInfo}
+	if l, ok := logLevels[strings.ToLower(level)]; ok {
+		opts.Level = l
+	}
+
+	if format == logFormatJSON {
+		return slog.New(slog.NewJSONHandler(w, opts))
+	}
+	return slog.New(slog.NewTextHandler(w, opts))
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestNewLoggerJSON(t *testing.T) {
+	t.Parallel()
+	var buf bytes.Buffer
+	logger := newLogger(&buf, logFormatJSON, "info").With("provider", "AWS")
+
+	logger.Info("Updated HTTP servers", "upstream", "backend-one", "scaling_group", "backend-group", "added", []string{"10.0.0.1:80"})
+	logger.Debug("Called the cloud provider API")
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("newLogger() logged %d lines at the info level, expected 1: %q", len(lines), buf.String())
+	}
+
+	var entry map[string]any
+	if err := json.Unmarshal([]byte(lines[0]), &entry); err != nil {
+		t.Fatalf("newLogger() didn't log JSON: %v", err)
+	}
+	expected := map[string]string{"level": "INFO", "msg": "Updated HTTP servers", "provider": "AWS", "upstream": "backend-one", "scaling_group": "backend-group"}
+	for key, value := range expected {
+		if entry[key] != value {
+			t.Errorf("newLogger() logged %v=%v, expected %v", key, entry[key], value)
+		}
+	}
+	if added, ok := entry["added"].([]any); !ok || len(added) != 1 || added[0] != "10.0.0.1:80" {
+		t.Errorf("newLogger() logged added=%v, expected [10.0.0.1:80]", entry["added"])
+	}
+}
+
+func TestNewLoggerLevel(t *testing.T) {
+	t.Parallel()
+	var buf bytes.Buffer
+	logger := newLogger(&buf, "", "DEBUG")
+
+	logger.Debug("Called the cloud provider API", "operation", "DescribeInstances", "error", errors.New("throttled"))
+
+	out := buf.String()
+	if !strings.Contains(out, "level=DEBUG") || !strings.Contains(out, "operation=DescribeInstances") || !strings.Contains(out, "error=throttled") {
+		t.Errorf("newLogger() logged %q, expected a text debug entry with the operation and the error", out)
+	}
+
+	buf.Reset()
+	newLogger(&buf, logFormatText, "warn").Info("Updated HTTP servers")
+	if buf.Len() != 0 {
+		t.Errorf("newLogger() with the warn level logged %q at the info level", buf.String())
+	}
+}
+
+func TestIsValidLogConfig(t *testing.T) {
+	t.Parallel()
+	for _, format := range []string{"", "text", "json"} {
+		if !isValidLogFormat(format) {
+			t.Errorf("isValidLogFormat(%q) returned false", format)
+		}
+	}
+	if isValidLogFormat("xml") {
+		t.Error("isValidLogFormat(\"xml\") returned true")
+	}
+
+	for _, level := range []string{"", "debug", "info", "warn", "error", "Debug"} {
+		if !isValidLogLevel(level) {
+			t.Errorf("isValidLogLevel(%q) returned false", level)
+		}
+	}
+	if isValidLogLevel("verbose") {
+		t.Error("isValidLogLevel(\"verbose\") returned true")
+	}
+}
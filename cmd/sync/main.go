@@ -2,10 +2,11 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
@@ -13,87 +14,230 @@ import (
 	"time"
 
 	nginx "github.com/nginx/nginx-plus-go-client/v3/client"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
 )
 
 var (
 	configFile = flag.String("config_path", "/etc/nginx/config.yaml", "Path to the config file")
 	logFile    = flag.String("log_path", "", "Path to the log file. If the file doesn't exist, it will be created")
+	logMaxSize = flag.Int64("log_max_size", 0, "Rotate the log file when it exceeds the size in megabytes. 0 disables the rotation by size")
+	logMaxAge  = flag.Duration("log_max_age", 0, "Rotate the log file when it is older than the duration, for example 24h. 0 disables the rotation by age")
+	logBackups = flag.Int("log_max_backups", 5, "Number of rotated log files to keep")
+	once       = flag.Bool("once", false, "Run a single sync pass and exit. The exit status is 1 if any upstream couldn't be synced")
+	dryRun     = flag.Bool("dry-run", false, "Print the planned changes to the servers of every upstream instead of applying them")
 	version    string
 )
 
 const (
 	connTimeoutInSecs = 10
 	maxHeaders        = 100
+	megabyte          = 1024 * 1024
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == validateCommand {
+		os.Exit(runValidate(os.Args[2:], os.Stdout))
+	}
+
 	flag.Parse()
 
+	var logOutput io.Writer = os.Stderr
+	slog.SetDefault(newLogger(logOutput, logFormatText, ""))
 	if *logFile != "" {
-		logF, err := os.OpenFile(*logFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+		logW, err := newLogWriter(*logFile, *logMaxSize*megabyte, *logMaxAge, *logBackups)
 		if err != nil {
-			log.Printf("Couldn't open the log file: %v", err)
+			slog.Error("Couldn't open the log file", "path", *logFile, "error", err)
 			os.Exit(10)
 		}
-		log.SetOutput(io.MultiWriter(logF, os.Stderr))
+		// stderr goes first, so that a failed write to the log file doesn't lose the entry
+		logOutput = io.MultiWriter(os.Stderr, logW)
+		slog.SetDefault(newLogger(logOutput, logFormatText, ""))
+		go reopenLogOnSignal(logW)
 	}
 
-	log.Printf("nginx-asg-sync version %s", version)
-
 	cfgData, err := os.ReadFile(*configFile)
 	if err != nil {
-		log.Printf("Couldn't read the config file %v: %v", *configFile, err)
+		slog.Error("Couldn't read the config file", "path", *configFile, "error", err)
 		os.Exit(10)
 	}
 
 	commonConfig, err := parseCommonConfig(cfgData)
 	if err != nil {
-		log.Printf("Couldn't parse the config: %v", err)
+		slog.Error("Couldn't parse the config", "error", err)
 		os.Exit(10)
 	}
 
-	var cloudProviderClient CloudProvider
+	slog.SetDefault(newLogger(logOutput, commonConfig.LogFormat, commonConfig.LogLevel).With("provider", commonConfig.CloudProvider))
+	slog.Info("Starting nginx-asg-sync", "version", version)
 
-	switch commonConfig.CloudProvider {
-	case "AWS":
-		cloudProviderClient, err = NewAWSClient(cfgData)
-	case "Azure":
-		cloudProviderClient, err = NewAzureClient(cfgData)
+	cloudProviderClient, err := newCloudProvider(commonConfig, cfgData)
+	if err != nil {
+		slog.Error("Couldn't create cloud provider client", "error", err)
+		os.Exit(10)
 	}
 
+	httpClient, err := NewHTTPClient(commonConfig)
 	if err != nil {
-		log.Printf("Couldn't create cloud provider client for %v: %v", commonConfig.CloudProvider, err)
+		slog.Error("Couldn't create the HTTP client of NGINX", "error", err)
 		os.Exit(10)
 	}
-
-	httpClient := NewHTTPClient(commonConfig)
 	nginxClient, err := nginx.NewNginxClient(commonConfig.APIEndpoint, nginx.WithHTTPClient(httpClient))
 	if err != nil {
-		log.Printf("Couldn't create NGINX client: %v", err)
+		slog.Error("Couldn't create NGINX client", "error", err)
 		os.Exit(10)
 	}
+	if len(commonConfig.CustomHeaders) > 0 || len(commonConfig.CustomHeaderSecrets) > 0 || commonConfig.APIAuth != nil {
+		slog.Info("Using custom headers for the NGINX Plus API", "headers", redactedCustomHeaders(commonConfig))
+	}
 
 	upstreams := cloudProviderClient.GetUpstreams()
+	retry := newRetryPolicy(commonConfig.Retry)
+
+	// checkUpstream checks that the upstream exists in NGINX Plus
+	checkUpstream := func(ctx context.Context, ups Upstream) error {
+		return retry.do(ctx, "check the upstream "+ups.Name, func() error {
+			if ups.Kind == "http" {
+				return nginxClient.CheckIfUpstreamExists(ctx, ups.Name)
+			}
+			return nginxClient.CheckIfStreamUpstreamExists(ctx, ups.Name)
+		})
+	}
 
 	for _, ups := range upstreams {
 		ctx := context.TODO()
-		if ups.Kind == "http" {
-			err = nginxClient.CheckIfUpstreamExists(ctx, ups.Name)
-		} else {
-			err = nginxClient.CheckIfStreamUpstreamExists(ctx, ups.Name)
+		err = checkUpstream(ctx, ups)
+		if err != nil {
+			slog.Error("Problem with the NGINX configuration", "upstream", ups.Name, "error", err)
+			os.Exit(10)
+		}
+
+		for _, group := range []string{ups.ScalingGroup, ups.BackupScalingGroup} {
+			if group == "" {
+				continue
+			}
+			var exists bool
+			err := retry.do(ctx, "check the scaling group "+group, func() error {
+				var err error
+				exists, err = cloudProviderClient.CheckIfScalingGroupExists(ctx, group)
+				return err
+			})
+			if err != nil {
+				slog.Error("Couldn't check if Scaling group exists", "upstream", ups.Name, "scaling_group", group, "error", err)
+				os.Exit(10)
+			} else if !exists {
+				slog.Warn("Scaling group doesn't exist in the cloud provider", "upstream", ups.Name, "scaling_group", group)
+			}
 		}
+	}
 
+	if commonConfig.Ownership != nil && commonConfig.Ownership.KeyvalZone != "" {
+		_, err = nginxClient.GetKeyValPairs(context.TODO(), commonConfig.Ownership.KeyvalZone)
 		if err != nil {
-			log.Printf("Problem with the NGINX configuration: %v", err)
+			slog.Error("Problem with the NGINX configuration", "keyval_zone", commonConfig.Ownership.KeyvalZone, "error", err)
 			os.Exit(10)
 		}
+	}
+
+	if commonConfig.LeaderElection != nil && commonConfig.LeaderElection.KeyvalZone != "" {
+		_, err = nginxClient.GetKeyValPairs(context.TODO(), commonConfig.LeaderElection.KeyvalZone)
+		if err != nil {
+			slog.Error("Problem with the NGINX configuration", "keyval_zone", commonConfig.LeaderElection.KeyvalZone, "error", err)
+			os.Exit(10)
+		}
+	}
+
+	state, err := newStateStore(commonConfig.StatePath)
+	if err != nil {
+		slog.Error("Couldn't load the state", "error", err)
+		os.Exit(10)
+	}
+
+	var autodiscovery *upstreamDiscovery
+	if discoverer, ok := cloudProviderClient.(upstreamDiscoverer); ok && discoverer.UpstreamDiscovery() != nil {
+		autodiscovery = newUpstreamDiscovery(discoverer, upstreams, checkUpstream)
+	}
+	upstreamList := newUpstreamList(upstreams)
+	if setter, ok := cloudProviderClient.(upstreamSourceSetter); ok {
+		setter.SetUpstreamSource(upstreamList.get)
+	}
+
+	s := newSyncer(cloudProviderClient, newUpstreamUpdaters(nginxClient), state)
+	s.upstreams = upstreamList
+	s.retry = retry
+	s.status = newStatusStore(upstreams)
+	s.discovery = newDiscoveryCache(commonConfig.DiscoveryCacheTTL)
+	s.localZone = commonConfig.Zone
+	s.massRemoval = commonConfig.MassRemoval
+	s.dryRun = *dryRun
+	if isZoneAwarenessEnabled(upstreams) && (s.localZone == "" || s.localZone == "self") {
+		s.localZone, err = cloudProviderClient.GetLocalZone(context.TODO())
+		if err != nil {
+			slog.Error("Couldn't get the zone of nginx-asg-sync", "error", err)
+			os.Exit(10)
+		}
+		slog.Info("Using the zone for zone awareness", "zone", s.localZone)
+	}
+	if commonConfig.Ownership != nil {
+		s.owners = newOwnershipStore(commonConfig.Ownership, nginxClient, state)
+	}
+
+	var notifier *webhookNotifier
+	if len(commonConfig.Webhooks) > 0 && !*dryRun {
+		notifier, err = newWebhookNotifier(commonConfig.Webhooks, commonConfig.Retry)
+		if err != nil {
+			slog.Error("Couldn't create the webhooks", "error", err)
+			os.Exit(10)
+		}
+		s.notifier = notifier
+		if autodiscovery != nil {
+			autodiscovery.notifier = notifier
+		}
+	}
+
+	// a replica in the dry-run mode doesn't change NGINX Plus, so it doesn't take the leadership from the others
+	var leader *leadership
+	if commonConfig.LeaderElection != nil && !*dryRun {
+		leader = newLeadership(commonConfig.LeaderElection, nginxClient, commonConfig.SyncInterval)
+		s.leader = leader
+	}
+
+	var tracerProvider *sdktrace.TracerProvider
+	if commonConfig.Tracing != nil {
+		tracerProvider, err = newTracerProvider(context.Background(), commonConfig.Tracing)
+		if err != nil {
+			slog.Error("Couldn't set up the tracing", "error", err)
+			os.Exit(10)
+		}
+		otel.SetTracerProvider(tracerProvider)
+	}
+
+	// shutdown gives up the leadership, delivers the queued webhook events and exports the remaining spans
+	shutdown := func() {
+		resignCtx, resignCancel := context.WithTimeout(context.Background(), connTimeoutInSecs*time.Second)
+		leader.resign(resignCtx)
+		resignCancel()
+		if notifier != nil {
+			notifier.Close(webhookShutdownTimeout)
+		}
+		if tracerProvider != nil {
+			flushCtx, flushCancel := context.WithTimeout(context.Background(), connTimeoutInSecs*time.Second)
+			defer flushCancel()
+			if err := tracerProvider.Shutdown(flushCtx); err != nil {
+				slog.Error("Couldn't export the remaining spans", "error", err)
+			}
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
 
-		exists, err := cloudProviderClient.CheckIfScalingGroupExists(ups.ScalingGroup)
+	// the syncs requested with the admin API run in the sync loop, so that an upstream is never synced concurrently
+	syncRequests := make(chan syncRequest)
+	if commonConfig.AdminListen != "" && !*once {
+		err = startAdminServer(ctx, commonConfig.AdminListen, newAdminHandler(upstreamList, s.status, syncRequests))
 		if err != nil {
-			log.Printf("Couldn't check if Scaling group exists: %v", err)
+			slog.Error("Couldn't start the admin API server", "error", err)
 			os.Exit(10)
-		} else if !exists {
-			log.Printf("Warning: Scaling group '%v' doesn't exist in the cloud provider", ups.ScalingGroup)
 		}
 	}
 
@@ -101,75 +245,65 @@ func main() {
 	signal.Notify(sigterm, syscall.SIGTERM)
 
 	for {
-		for _, upstream := range upstreams {
-			ips, err := cloudProviderClient.GetPrivateIPsForScalingGroup(upstream.ScalingGroup)
-			if err != nil {
-				log.Printf("Couldn't get the IP addresses for %v: %v", upstream.ScalingGroup, err)
-				continue
+		failed := false
+		if leader.check(ctx) {
+			if autodiscovery != nil {
+				upstreams = autodiscovery.refresh(ctx)
+				upstreamList.set(upstreams)
+				s.status.setUpstreams(upstreams)
 			}
-			ctx := context.TODO()
-
-			if upstream.Kind == "http" {
-				upsServers := make([]nginx.UpstreamServer, 0, len(ips))
-				for _, ip := range ips {
-					backend := fmt.Sprintf("%v:%v", ip, upstream.Port)
-					upsServers = append(upsServers, nginx.UpstreamServer{
-						Server:      backend,
-						MaxConns:    upstream.MaxConns,
-						MaxFails:    upstream.MaxFails,
-						FailTimeout: upstream.FailTimeout,
-						SlowStart:   upstream.SlowStart,
-					})
-				}
-
-				added, removed, updated, err := nginxClient.UpdateHTTPServers(ctx, upstream.Name, upsServers)
-				if err != nil {
-					log.Printf("Couldn't update HTTP servers in NGINX: %v", err)
-					continue
+			s.discovery.newCycle(false)
+			cycleCtx, span := startSpan(ctx, "sync", "upstreams", len(upstreams))
+			for _, upstream := range upstreams {
+				if err := s.sync(cycleCtx, upstream); err != nil {
+					failed = true
+					// the rest of the upstreams are synced by the new leader
+					if errors.Is(err, errNotLeader) {
+						break
+					}
 				}
+			}
+			s.saveState()
+			span.End()
+		} else if *once {
+			slog.Info("Another replica is the leader, skipping the sync")
+		}
 
-				if len(added) > 0 || len(removed) > 0 || len(updated) > 0 {
-					addedAddresses := getUpstreamServerAddresses(added)
-					removedAddresses := getUpstreamServerAddresses(removed)
-					updatedAddresses := getUpstreamServerAddresses(updated)
-					log.Printf("Updated HTTP servers of %v for group %v ; Added: %+v, Removed: %+v, Updated: %+v",
-						upstream.Name, upstream.ScalingGroup, addedAddresses, removedAddresses, updatedAddresses)
-				}
-			} else {
-				upsServers := make([]nginx.StreamUpstreamServer, 0, len(ips))
-				for _, ip := range ips {
-					backend := fmt.Sprintf("%v:%v", ip, upstream.Port)
-					upsServers = append(upsServers, nginx.StreamUpstreamServer{
-						Server:      backend,
-						MaxConns:    upstream.MaxConns,
-						MaxFails:    upstream.MaxFails,
-						FailTimeout: upstream.FailTimeout,
-						SlowStart:   upstream.SlowStart,
-					})
-				}
+		if *once {
+			cancel()
+			shutdown()
+			if failed {
+				os.Exit(1)
+			}
+			return
+		}
 
-				added, removed, updated, err := nginxClient.UpdateStreamServers(ctx, upstream.Name, upsServers)
-				if err != nil {
-					log.Printf("Couldn't update Steam servers in NGINX: %v", err)
+		timer := time.NewTimer(commonConfig.SyncInterval)
+	wait:
+		for {
+			select {
+			case <-timer.C:
+				break wait
+			case req := <-syncRequests:
+				if leader != nil && !leader.leader {
+					req.done <- errNotLeader
 					continue
 				}
-
-				if len(added) > 0 || len(removed) > 0 || len(updated) > 0 {
-					addedAddresses := getStreamUpstreamServerAddresses(added)
-					removedAddresses := getStreamUpstreamServerAddresses(removed)
-					updatedAddresses := getStreamUpstreamServerAddresses(updated)
-					log.Printf("Updated Stream servers of %v for group %v ; Added: %+v, Removed: %+v, Updated: %+v",
-						upstream.Name, upstream.ScalingGroup, addedAddresses, removedAddresses, updatedAddresses)
+				s.discovery.newCycle(true)
+				var errs []error
+				for _, upstream := range req.upstreams {
+					errs = append(errs, s.sync(ctx, upstream))
 				}
+				s.saveState()
+				req.done <- errors.Join(errs...)
+			case <-sigterm:
+				slog.Info("Terminating...")
+				timer.Stop()
+				cancel()
+				shutdown()
+				return
 			}
 		}
-
-		select {
-		case <-time.After(commonConfig.SyncInterval):
-		case <-sigterm:
-			log.Println("Terminating...")
-			return
-		}
 	}
 }
 
@@ -181,22 +315,16 @@ func getUpstreamServerAddresses(server []nginx.UpstreamServer) []string {
 	return upstreamServerAddr
 }
 
-func getStreamUpstreamServerAddresses(server []nginx.StreamUpstreamServer) []string {
-	streamUpstreamServerAddr := make([]string, 0, len(server))
-	for _, s := range server {
-		streamUpstreamServerAddr = append(streamUpstreamServerAddr, s.Server)
-	}
-	return streamUpstreamServerAddr
-}
-
 // headerTransport wraps an http.RoundTripper and adds custom headers to all requests.
+// The values of the headers from secrets are read on every request, so that rotated values are used.
 type headerTransport struct {
 	headers   http.Header
+	secrets   map[string]headerValueSource
 	transport http.RoundTripper
 }
 
 func (t *headerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
-	if len(t.headers)+len(req.Header) > maxHeaders {
+	if len(t.headers)+len(t.secrets)+len(req.Header) > maxHeaders {
 		return nil, fmt.Errorf("number of headers in request exceeds the maximum allowed (%d)", maxHeaders)
 	}
 	clonedReq := req.Clone(req.Context())
@@ -207,6 +335,14 @@ func (t *headerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
 		}
 	}
 
+	for key, src := range t.secrets {
+		value, err := src.Value(req.Context())
+		if err != nil {
+			return nil, fmt.Errorf("couldn't get the value of the header %v: %w", key, err)
+		}
+		clonedReq.Header.Set(key, value)
+	}
+
 	resp, err := t.transport.RoundTrip(clonedReq)
 	if err != nil {
 		return nil, fmt.Errorf("headerTransport RoundTrip failed: %w", err)
@@ -215,22 +351,69 @@ func (t *headerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
 	return resp, nil
 }
 
-func NewHTTPClient(cfg *commonConfig) *http.Client {
-	rt := http.DefaultTransport
-	if h := NewHeaders(cfg); len(h) > 0 {
-		rt = &headerTransport{headers: h, transport: rt}
+func NewHTTPClient(cfg *commonConfig) (*http.Client, error) {
+	var rt http.RoundTripper = http.DefaultTransport
+	if cfg.APITLS != nil {
+		tlsConfig, err := newTLSConfig(cfg.APITLS, cfg.APIEndpoint)
+		if err != nil {
+			return nil, err
+		}
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = tlsConfig
+		rt = transport
 	}
+
+	h, err := NewHeaders(cfg)
+	if err != nil {
+		return nil, err
+	}
+	secrets, err := newHeaderSecrets(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if len(h) > 0 || len(secrets) > 0 {
+		rt = &headerTransport{headers: h, secrets: secrets, transport: rt}
+	}
+
 	return &http.Client{
 		Transport: rt,
 		Timeout:   connTimeoutInSecs * time.Second,
-	}
+	}, nil
 }
 
-func NewHeaders(cfg *commonConfig) http.Header {
+// NewHeaders returns the custom headers with the ${VAR} references in their values replaced by the values of the
+// environment variables.
+func NewHeaders(cfg *commonConfig) (http.Header, error) {
 	headers := http.Header{}
 
 	for key, value := range cfg.CustomHeaders {
-		headers.Set(key, value)
+		expanded, err := expandEnv(value)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't expand the value of the custom header %v: %w", key, err)
+		}
+		headers.Set(key, expanded)
+	}
+	return headers, nil
+}
+
+// newHeaderSecrets returns the sources of the headers whose values are read for every request: the custom header
+// secrets and the Authorization header of api_auth.
+func newHeaderSecrets(cfg *commonConfig) (map[string]headerValueSource, error) {
+	secrets := make(map[string]headerValueSource, len(cfg.CustomHeaderSecrets))
+	for key, secret := range cfg.CustomHeaderSecrets {
+		src, err := newHeaderValueSource(secret)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't get the value of the custom header %v: %w", key, err)
+		}
+		secrets[http.CanonicalHeaderKey(key)] = src
+	}
+
+	if cfg.APIAuth != nil && cfg.APIAuth.EntraID != nil {
+		src, err := newEntraIDTokenSource(cfg.APIAuth.EntraID)
+		if err != nil {
+			return nil, err
+		}
+		secrets[authorizationHeader] = src
 	}
-	return headers
+	return secrets, nil
 }
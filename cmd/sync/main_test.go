@@ -64,53 +64,6 @@ func TestGetUpstreamServerAddresses(t *testing.T) {
 	}
 }
 
-func TestGetStreamUpstreamServerAddresses(t *testing.T) {
-	t.Parallel()
-	tests := []struct {
-		name     string
-		servers  []nginx.StreamUpstreamServer
-		expected []string
-	}{
-		{
-			name:     "empty server list",
-			servers:  []nginx.StreamUpstreamServer{},
-			expected: []string{},
-		},
-		{
-			name: "single stream server",
-			servers: []nginx.StreamUpstreamServer{
-				{Server: "10.0.0.1:3306"},
-			},
-			expected: []string{"10.0.0.1:3306"},
-		},
-		{
-			name: "multiple stream servers",
-			servers: []nginx.StreamUpstreamServer{
-				{Server: "10.0.0.1:3306"},
-				{Server: "10.0.0.2:3306"},
-				{Server: "10.0.0.3:5432"},
-			},
-			expected: []string{"10.0.0.1:3306", "10.0.0.2:3306", "10.0.0.3:5432"},
-		},
-	}
-
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			t.Parallel()
-			result := getStreamUpstreamServerAddresses(tt.servers)
-			if len(result) != len(tt.expected) {
-				t.Errorf("expected %d addresses, got %d", len(tt.expected), len(result))
-				return
-			}
-			for i, addr := range result {
-				if addr != tt.expected[i] {
-					t.Errorf("expected address[%d] = %s, got %s", i, tt.expected[i], addr)
-				}
-			}
-		})
-	}
-}
-
 // Helper function.
 func intPtr(i int) *int {
 	return &i
@@ -168,7 +121,10 @@ func TestCustomHeaders(t *testing.T) {
 			cfg := &commonConfig{
 				CustomHeaders: tc.customHeaders,
 			}
-			client := NewHTTPClient(cfg)
+			client, err := NewHTTPClient(cfg)
+			if err != nil {
+				t.Fatalf("NewHTTPClient() failed: %v", err)
+			}
 
 			req, err := http.NewRequestWithContext(t.Context(), http.MethodGet, server.URL, nil)
 			if err != nil {
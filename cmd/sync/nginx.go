@@ -0,0 +1,299 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"slices"
+	"strings"
+
+	nginx "github.com/nginx/nginx-plus-go-client/v3/client"
+)
+
+// upstreamUpdater reads and updates the servers of NGINX Plus upstreams of one kind (http or stream).
+// Stream servers are represented as nginx.UpstreamServer so that the sync logic is shared between both kinds.
+type upstreamUpdater interface {
+	GetServers(ctx context.Context, upstream string) ([]nginx.UpstreamServer, error)
+	UpdateServers(ctx context.Context, upstream string, servers []nginx.UpstreamServer) (added, removed, updated []nginx.UpstreamServer, err error)
+	// GetPeerStates returns the states of the servers of the upstream, such as up or unhealthy, by their addresses.
+	GetPeerStates(ctx context.Context, upstream string) (map[string]string, error)
+}
+
+// newUpstreamUpdaters returns the upstreamUpdater for each upstream kind.
+func newUpstreamUpdaters(client *nginx.NginxClient) map[string]upstreamUpdater {
+	return map[string]upstreamUpdater{
+		"http":   &httpUpstreamUpdater{client: client},
+		"stream": &streamUpstreamUpdater{client: client},
+	}
+}
+
+type httpUpstreamUpdater struct {
+	client *nginx.NginxClient
+}
+
+func (u *httpUpstreamUpdater) GetServers(ctx context.Context, upstream string) ([]nginx.UpstreamServer, error) {
+	servers, err := u.client.GetHTTPServers(ctx, upstream)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't get HTTP servers of %v: %w", upstream, err)
+	}
+	return servers, nil
+}
+
+func (u *httpUpstreamUpdater) UpdateServers(ctx context.Context, upstream string, servers []nginx.UpstreamServer) (added, removed, updated []nginx.UpstreamServer, err error) {
+	added, removed, updated, err = u.client.UpdateHTTPServers(ctx, upstream, servers)
+	if err != nil {
+		err = fmt.Errorf("couldn't update HTTP servers in NGINX: %w", err)
+	}
+	return added, removed, updated, err
+}
+
+func (u *httpUpstreamUpdater) GetPeerStates(ctx context.Context, upstream string) (map[string]string, error) {
+	upstreams, err := u.client.GetUpstreams(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't get HTTP upstreams: %w", err)
+	}
+	ups, ok := (*upstreams)[upstream]
+	if !ok {
+		return nil, fmt.Errorf("the HTTP upstream %v doesn't exist", upstream)
+	}
+
+	states := make(map[string]string, len(ups.Peers))
+	for _, peer := range ups.Peers {
+		states[peer.Server] = peer.State
+	}
+	return states, nil
+}
+
+type streamUpstreamUpdater struct {
+	client *nginx.NginxClient
+}
+
+func (u *streamUpstreamUpdater) GetServers(ctx context.Context, upstream string) ([]nginx.UpstreamServer, error) {
+	servers, err := u.client.GetStreamServers(ctx, upstream)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't get Stream servers of %v: %w", upstream, err)
+	}
+	return fromStreamUpstreamServers(servers), nil
+}
+
+func (u *streamUpstreamUpdater) UpdateServers(ctx context.Context, upstream string, servers []nginx.UpstreamServer) (added, removed, updated []nginx.UpstreamServer, err error) {
+	a, r, up, err := u.client.UpdateStreamServers(ctx, upstream, toStreamUpstreamServers(servers))
+	if err != nil {
+		err = fmt.Errorf("couldn't update Stream servers in NGINX: %w", err)
+	}
+	return fromStreamUpstreamServers(a), fromStreamUpstreamServers(r), fromStreamUpstreamServers(up), err
+}
+
+func (u *streamUpstreamUpdater) GetPeerStates(ctx context.Context, upstream string) (map[string]string, error) {
+	upstreams, err := u.client.GetStreamUpstreams(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't get Stream upstreams: %w", err)
+	}
+	ups, ok := (*upstreams)[upstream]
+	if !ok {
+		return nil, fmt.Errorf("the Stream upstream %v doesn't exist", upstream)
+	}
+
+	states := make(map[string]string, len(ups.Peers))
+	for _, peer := range ups.Peers {
+		states[peer.Server] = peer.State
+	}
+	return states, nil
+}
+
+// The default parameters of servers in NGINX Plus, which the servers without the parameters are compared with.
+// The defaults of fail_timeout and slow_start are in parameters.go.
+var (
+	defaultMaxConns = 0
+	defaultMaxFails = 1
+	defaultBackup   = false
+	defaultDown     = false
+	defaultWeight   = 1
+)
+
+// planServerUpdates returns the servers that updating an upstream that has the current servers with servers would add,
+// remove and update. The servers are compared the same way the NGINX Plus API client compares them in UpdateHTTPServers
+// and UpdateStreamServers: port 80 is added to the servers without a port, the duplicate servers with different
+// parameters are left out with an error, and the parameters are compared with the defaults of NGINX Plus applied.
+func planServerUpdates(servers, current []nginx.UpstreamServer) (toAdd, toRemove, toUpdate []nginx.UpstreamServer, err error) {
+	byAddress := make(map[string]nginx.UpstreamServer, len(servers))
+	mismatched := make(map[string]bool)
+	formatted := make([]nginx.UpstreamServer, 0, len(servers))
+	for _, server := range servers {
+		server.Server = addPortToServer(server.Server)
+		if prev, ok := byAddress[server.Server]; ok {
+			if !mismatched[server.Server] && !haveSameParameters(server, prev) {
+				mismatched[server.Server] = true
+				err = errors.Join(err, fmt.Errorf("couldn't update the server %v: %w", server.Server, nginx.ErrParameterMismatch))
+			}
+			continue
+		}
+		byAddress[server.Server] = server
+		formatted = append(formatted, server)
+	}
+	formatted = slices.DeleteFunc(formatted, func(server nginx.UpstreamServer) bool { return mismatched[server.Server] })
+
+	currentByAddress := make(map[string]nginx.UpstreamServer, len(current))
+	for _, server := range current {
+		if _, ok := currentByAddress[server.Server]; !ok {
+			currentByAddress[server.Server] = server
+		}
+	}
+
+	wanted := make(map[string]bool, len(formatted))
+	for _, server := range formatted {
+		wanted[server.Server] = true
+		existing, ok := currentByAddress[server.Server]
+		if !ok {
+			toAdd = append(toAdd, server)
+			continue
+		}
+		if !haveSameParameters(server, existing) {
+			server.ID = existing.ID
+			toUpdate = append(toUpdate, server)
+		}
+	}
+
+	for _, server := range current {
+		if !wanted[server.Server] {
+			toRemove = append(toRemove, server)
+		}
+	}
+
+	return toAdd, toRemove, toUpdate, err
+}
+
+// haveSameParameters reports whether the servers have the same parameters, ignoring their IDs and with the defaults of
+// NGINX Plus applied to the parameters that aren't set.
+func haveSameParameters(a, b nginx.UpstreamServer) bool {
+	a.ID = b.ID
+	return reflect.DeepEqual(withServerDefaults(a), withServerDefaults(b))
+}
+
+func withServerDefaults(server nginx.UpstreamServer) nginx.UpstreamServer {
+	if server.MaxConns == nil {
+		server.MaxConns = &defaultMaxConns
+	}
+	if server.MaxFails == nil {
+		server.MaxFails = &defaultMaxFails
+	}
+	if server.FailTimeout == "" {
+		server.FailTimeout = defaultFailTimeout
+	}
+	if server.SlowStart == "" {
+		server.SlowStart = defaultSlowStart
+	}
+	if server.Backup == nil {
+		server.Backup = &defaultBackup
+	}
+	if server.Down == nil {
+		server.Down = &defaultDown
+	}
+	if server.Weight == nil {
+		server.Weight = &defaultWeight
+	}
+	return server
+}
+
+// addPortToServer adds port 80 to the address of the server if it doesn't have a port and isn't a UNIX socket.
+func addPortToServer(server string) string {
+	if len(strings.Split(server, ":")) == 2 || len(strings.Split(server, "]:")) == 2 || strings.HasPrefix(server, "unix:") {
+		return server
+	}
+	return server + ":80"
+}
+
+// serverParameterChanges describes the parameters that differ between the current server and the updated server,
+// with the defaults of NGINX Plus applied to the parameters that aren't set, such as "weight: 1 -> 5".
+func serverParameterChanges(current, updated nginx.UpstreamServer) []string {
+	current, updated = withServerDefaults(current), withServerDefaults(updated)
+	params := []struct {
+		current, updated any
+		name             string
+	}{
+		{*current.MaxConns, *updated.MaxConns, "max_conns"},
+		{*current.MaxFails, *updated.MaxFails, "max_fails"},
+		{current.FailTimeout, updated.FailTimeout, "fail_timeout"},
+		{current.SlowStart, updated.SlowStart, "slow_start"},
+		{*current.Backup, *updated.Backup, "backup"},
+		{*current.Down, *updated.Down, "down"},
+		{*current.Weight, *updated.Weight, "weight"},
+		{current.Route, updated.Route, "route"},
+		{current.Service, updated.Service, "service"},
+		{current.Drain, updated.Drain, "drain"},
+	}
+
+	var changes []string
+	for _, param := range params {
+		if param.current != param.updated {
+			changes = append(changes, fmt.Sprintf("%v: %v -> %v", param.name, param.current, param.updated))
+		}
+	}
+	return changes
+}
+
+// writePlan writes the planned changes to the servers of the upstream as a report for the dry-run mode.
+// The report is written at once, so that the reports of different upstreams aren't interleaved.
+func writePlan(w io.Writer, upstream Upstream, current, toAdd, toRemove, toUpdate []nginx.UpstreamServer) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%v upstream %v (scaling group %v):\n", kindLabel(upstream.Kind), upstream.Name, upstream.ScalingGroup)
+	if len(toAdd) == 0 && len(toRemove) == 0 && len(toUpdate) == 0 {
+		fmt.Fprintf(&b, "  no changes, %v servers\n", len(current))
+	}
+	for _, server := range toAdd {
+		fmt.Fprintf(&b, "  + %v\n", server.Server)
+	}
+	for _, server := range toRemove {
+		fmt.Fprintf(&b, "  - %v\n", server.Server)
+	}
+	for _, server := range toUpdate {
+		var changes []string
+		if i := slices.IndexFunc(current, func(s nginx.UpstreamServer) bool { return s.Server == server.Server }); i >= 0 {
+			changes = serverParameterChanges(current[i], server)
+		}
+		fmt.Fprintf(&b, "  ~ %v (%v)\n", server.Server, strings.Join(changes, ", "))
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func toStreamUpstreamServers(servers []nginx.UpstreamServer) []nginx.StreamUpstreamServer {
+	streamServers := make([]nginx.StreamUpstreamServer, 0, len(servers))
+	for _, s := range servers {
+		streamServers = append(streamServers, nginx.StreamUpstreamServer{
+			ID:          s.ID,
+			Server:      s.Server,
+			MaxConns:    s.MaxConns,
+			MaxFails:    s.MaxFails,
+			FailTimeout: s.FailTimeout,
+			SlowStart:   s.SlowStart,
+			Backup:      s.Backup,
+			Down:        s.Down,
+			Weight:      s.Weight,
+			Service:     s.Service,
+		})
+	}
+	return streamServers
+}
+
+func fromStreamUpstreamServers(streamServers []nginx.StreamUpstreamServer) []nginx.UpstreamServer {
+	servers := make([]nginx.UpstreamServer, 0, len(streamServers))
+	for _, s := range streamServers {
+		servers = append(servers, nginx.UpstreamServer{
+			ID:          s.ID,
+			Server:      s.Server,
+			MaxConns:    s.MaxConns,
+			MaxFails:    s.MaxFails,
+			FailTimeout: s.FailTimeout,
+			SlowStart:   s.SlowStart,
+			Backup:      s.Backup,
+			Down:        s.Down,
+			Weight:      s.Weight,
+			Service:     s.Service,
+		})
+	}
+	return servers
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	nginx "github.com/nginx/nginx-plus-go-client/v3/client"
+)
+
+func TestPlanServerUpdates(t *testing.T) {
+	t.Parallel()
+	maxFails := 1
+	maxConns := 10
+	current := []nginx.UpstreamServer{
+		{ID: 1, Server: "10.0.0.1:80", MaxFails: &maxFails, FailTimeout: "10s", SlowStart: "0s"},
+		{ID: 2, Server: "10.0.0.2:80", MaxFails: &maxFails, FailTimeout: "10s", SlowStart: "0s"},
+		{ID: 3, Server: "10.0.0.3:80", MaxFails: &maxFails, FailTimeout: "10s", SlowStart: "0s"},
+	}
+	servers := []nginx.UpstreamServer{
+		{Server: "10.0.0.1:80"},
+		{Server: "10.0.0.2:80", MaxConns: &maxConns},
+		{Server: "10.0.0.4:80"},
+		{Server: "10.0.0.4:80"},
+	}
+
+	toAdd, toRemove, toUpdate, err := planServerUpdates(servers, current)
+	if err != nil {
+		t.Fatalf("planServerUpdates() failed: %v", err)
+	}
+
+	if got := getUpstreamServerAddresses(toAdd); !reflect.DeepEqual(got, []string{"10.0.0.4:80"}) {
+		t.Errorf("planServerUpdates() planned to add %v, expected [10.0.0.4:80]", got)
+	}
+	if got := getUpstreamServerAddresses(toRemove); !reflect.DeepEqual(got, []string{"10.0.0.3:80"}) {
+		t.Errorf("planServerUpdates() planned to remove %v, expected [10.0.0.3:80]", got)
+	}
+	if len(toUpdate) != 1 || toUpdate[0].Server != "10.0.0.2:80" || toUpdate[0].ID != 2 {
+		t.Errorf("planServerUpdates() planned to update %+v, expected the server 10.0.0.2:80 with the ID 2", toUpdate)
+	}
+}
+
+func TestPlanServerUpdatesWithMismatchedDuplicates(t *testing.T) {
+	t.Parallel()
+	weight := 5
+	servers := []nginx.UpstreamServer{
+		{Server: "10.0.0.1"},
+		{Server: "10.0.0.1:80", Weight: &weight},
+		{Server: "10.0.0.2:80"},
+	}
+
+	toAdd, _, _, err := planServerUpdates(servers, nil)
+	if !errors.Is(err, nginx.ErrParameterMismatch) {
+		t.Errorf("planServerUpdates() returned the error %v, expected %v", err, nginx.ErrParameterMismatch)
+	}
+	if got := getUpstreamServerAddresses(toAdd); !reflect.DeepEqual(got, []string{"10.0.0.2:80"}) {
+		t.Errorf("planServerUpdates() planned to add %v, expected [10.0.0.2:80]", got)
+	}
+}
+
+func TestWritePlan(t *testing.T) {
+	t.Parallel()
+	weight := 5
+	upstream := Upstream{Name: "backend", Kind: "stream", ScalingGroup: "group"}
+	current := []nginx.UpstreamServer{{ID: 1, Server: "10.0.0.1:53"}, {ID: 2, Server: "10.0.0.2:53"}}
+	toUpdate := []nginx.UpstreamServer{{ID: 1, Server: "10.0.0.1:53", Weight: &weight, SlowStart: "30s"}}
+	toAdd := []nginx.UpstreamServer{{Server: "10.0.0.3:53"}}
+
+	var b strings.Builder
+	if err := writePlan(&b, upstream, current, toAdd, current[1:], toUpdate); err != nil {
+		t.Fatalf("writePlan() failed: %v", err)
+	}
+	want := "Stream upstream backend (scaling group group):\n" +
+		"  + 10.0.0.3:53\n" +
+		"  - 10.0.0.2:53\n" +
+		"  ~ 10.0.0.1:53 (slow_start: 0s -> 30s, weight: 1 -> 5)\n"
+	if b.String() != want {
+		t.Errorf("writePlan() wrote %q, expected %q", b.String(), want)
+	}
+
+	b.Reset()
+	if err := writePlan(&b, upstream, current, nil, nil, nil); err != nil {
+		t.Fatalf("writePlan() failed: %v", err)
+	}
+	if want := "Stream upstream backend (scaling group group):\n  no changes, 2 servers\n"; b.String() != want {
+		t.Errorf("writePlan() wrote %q, expected %q", b.String(), want)
+	}
+}
+
+func TestPlanServerUpdatesWithoutChanges(t *testing.T) {
+	t.Parallel()
+	servers := []nginx.UpstreamServer{{Server: "10.0.0.1:80", FailTimeout: "10s"}}
+	current := []nginx.UpstreamServer{{ID: 5, Server: "10.0.0.1:80"}}
+
+	toAdd, toRemove, toUpdate, err := planServerUpdates(servers, current)
+	if err != nil {
+		t.Fatalf("planServerUpdates() failed: %v", err)
+	}
+	if len(toAdd) != 0 || len(toRemove) != 0 || len(toUpdate) != 0 {
+		t.Errorf("planServerUpdates() planned to add %+v, remove %+v and update %+v, expected no changes", toAdd, toRemove, toUpdate)
+	}
+}
+
+// fakeNginxAPI serves the servers of upstreams like the NGINX Plus API, for both upstream kinds.
+type fakeNginxAPI struct {
+	servers map[string][]nginx.UpstreamServer
+	mu      sync.Mutex
+	nextID  int
+}
+
+func (a *fakeNginxAPI) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/{version}/{kind}/upstreams/{upstream}/servers", func(w http.ResponseWriter, r *http.Request) {
+		a.mu.Lock()
+		defer a.mu.Unlock()
+		writeJSON(w, http.StatusOK, a.servers[r.PathValue("upstream")])
+	})
+	mux.HandleFunc("POST /api/{version}/{kind}/upstreams/{upstream}/servers/", func(w http.ResponseWriter, r *http.Request) {
+		a.mu.Lock()
+		defer a.mu.Unlock()
+		var server nginx.UpstreamServer
+		if err := json.NewDecoder(r.Body).Decode(&server); err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{})
+			return
+		}
+		a.nextID++
+		server.ID = a.nextID
+		a.servers[r.PathValue("upstream")] = append(a.servers[r.PathValue("upstream")], server)
+		writeJSON(w, http.StatusCreated, server)
+	})
+	mux.HandleFunc("PATCH /api/{version}/{kind}/upstreams/{upstream}/servers/{id}/", func(w http.ResponseWriter, r *http.Request) {
+		a.mu.Lock()
+		defer a.mu.Unlock()
+		var server nginx.UpstreamServer
+		if err := json.NewDecoder(r.Body).Decode(&server); err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{})
+			return
+		}
+		servers := a.servers[r.PathValue("upstream")]
+		i := slices.IndexFunc(servers, func(s nginx.UpstreamServer) bool { return strconv.Itoa(s.ID) == r.PathValue("id") })
+		if i < 0 {
+			writeJSON(w, http.StatusNotFound, map[string]string{})
+			return
+		}
+		server.ID = servers[i].ID
+		servers[i] = server
+		writeJSON(w, http.StatusOK, server)
+	})
+	mux.HandleFunc("DELETE /api/{version}/{kind}/upstreams/{upstream}/servers/{id}/", func(w http.ResponseWriter, r *http.Request) {
+		a.mu.Lock()
+		defer a.mu.Unlock()
+		upstream := r.PathValue("upstream")
+		a.servers[upstream] = slices.DeleteFunc(a.servers[upstream], func(s nginx.UpstreamServer) bool {
+			return strconv.Itoa(s.ID) == r.PathValue("id")
+		})
+		writeJSON(w, http.StatusOK, a.servers[upstream])
+	})
+	return mux
+}
+
+func TestPlanServerUpdatesMatchesUpdateServers(t *testing.T) {
+	t.Parallel()
+	weight := 5
+	maxFails := 1
+	current := []nginx.UpstreamServer{
+		{ID: 1, Server: "10.0.0.1:80", MaxFails: &maxFails, FailTimeout: "10s", SlowStart: "0s"},
+		{ID: 2, Server: "10.0.0.2:80", MaxFails: &maxFails, FailTimeout: "10s", SlowStart: "0s"},
+		{ID: 3, Server: "10.0.0.3:80", MaxFails: &maxFails, FailTimeout: "10s", SlowStart: "0s"},
+	}
+	servers := []nginx.UpstreamServer{
+		{Server: "10.0.0.1:80", FailTimeout: "10s"},
+		{Server: "10.0.0.2:80", Weight: &weight},
+		{Server: "10.0.0.4:80"},
+		{Server: "10.0.0.5"},
+	}
+
+	for _, kind := range []string{"http", "stream"} {
+		t.Run(kind, func(t *testing.T) {
+			t.Parallel()
+			api := &fakeNginxAPI{servers: map[string][]nginx.UpstreamServer{"backend": slices.Clone(current)}, nextID: 3}
+			server := httptest.NewServer(api.handler())
+			defer server.Close()
+
+			client, err := nginx.NewNginxClient(server.URL+"/api", nginx.WithHTTPClient(server.Client()))
+			if err != nil {
+				t.Fatalf("NewNginxClient() failed: %v", err)
+			}
+			updater := newUpstreamUpdaters(client)[kind]
+			ctx := context.Background()
+
+			got, err := updater.GetServers(ctx, "backend")
+			if err != nil {
+				t.Fatalf("GetServers() failed: %v", err)
+			}
+			toAdd, toRemove, toUpdate, err := planServerUpdates(servers, got)
+			if err != nil {
+				t.Fatalf("planServerUpdates() failed: %v", err)
+			}
+
+			added, removed, updated, err := updater.UpdateServers(ctx, "backend", servers)
+			if err != nil {
+				t.Fatalf("UpdateServers() failed: %v", err)
+			}
+
+			if len(added) != 2 || len(removed) != 1 || len(updated) != 1 {
+				t.Fatalf("UpdateServers() added %+v, removed %+v and updated %+v, expected 2, 1 and 1 servers", added, removed, updated)
+			}
+			if !reflect.DeepEqual(toAdd, added) {
+				t.Errorf("planServerUpdates() planned to add %+v, UpdateServers() added %+v", toAdd, added)
+			}
+			if !reflect.DeepEqual(toRemove, removed) {
+				t.Errorf("planServerUpdates() planned to remove %+v, UpdateServers() removed %+v", toRemove, removed)
+			}
+			if !reflect.DeepEqual(toUpdate, updated) {
+				t.Errorf("planServerUpdates() planned to update %+v, UpdateServers() updated %+v", toUpdate, updated)
+			}
+		})
+	}
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	nginx "github.com/nginx/nginx-plus-go-client/v3/client"
+)
+
+// ownershipConfig enables the ownership mode, in which nginx-asg-sync only adds and removes the servers it created itself.
+// The list of owned servers is tracked either in an NGINX Plus key-value zone or, without keyval_zone, in the state
+// file of state_path.
+type ownershipConfig struct {
+	KeyvalZone string `yaml:"keyval_zone,omitempty"`
+}
+
+// validateOwnershipConfig checks that the owned servers are stored somewhere that survives restarts.
+func validateOwnershipConfig(cfg *ownershipConfig, statePath string) error {
+	if cfg.KeyvalZone == "" && statePath == "" {
+		return errors.New(ownershipStoreErrorMsg)
+	}
+
+	return nil
+}
+
+// ownershipStore persists the addresses of the servers that nginx-asg-sync added to an upstream.
+type ownershipStore interface {
+	Load(ctx context.Context, upstream Upstream) ([]string, error)
+	Save(ctx context.Context, upstream Upstream, servers []string) error
+}
+
+// newOwnershipStore creates the ownershipStore configured in cfg, which keeps the owned servers in the state if cfg
+// has no key-value zone.
+func newOwnershipStore(cfg *ownershipConfig, client keyvalClient, state *stateStore) ownershipStore {
+	if cfg.KeyvalZone != "" {
+		return &keyvalOwnershipStore{client: client, zone: cfg.KeyvalZone}
+	}
+	return &stateOwnershipStore{state: state}
+}
+
+// ownershipKey identifies an upstream in the ownership store. HTTP and stream upstreams can share a name.
+func ownershipKey(upstream Upstream) string {
+	return upstream.Kind + "/" + upstream.Name
+}
+
+// keyvalClient is the part of the NGINX Plus API client used to manage key-value pairs.
+type keyvalClient interface {
+	GetKeyValPairs(ctx context.Context, zone string) (nginx.KeyValPairs, error)
+	AddKeyValPair(ctx context.Context, zone string, key string, val string) error
+	ModifyKeyValPair(ctx context.Context, zone string, key string, val string) error
+	DeleteKeyValuePair(ctx context.Context, zone string, key string) error
+}
+
+// keyvalOwnershipStore stores every owned server as a separate key, kind/name/address, in an HTTP key-value zone, so
+// that the size of a value doesn't limit the number of the owned servers of an upstream.
+type keyvalOwnershipStore struct {
+	client keyvalClient
+	zone   string
+}
+
+// ownedServerValue is the value of the keys of the owned servers.
+const ownedServerValue = "1"
+
+// keyvalOwnedServers returns the owned servers of the upstream stored in the key-value pairs.
+func keyvalOwnedServers(pairs nginx.KeyValPairs, upstream Upstream) []string {
+	prefix := ownershipKey(upstream) + "/"
+
+	var servers []string
+	for key := range pairs {
+		server, ok := strings.CutPrefix(key, prefix)
+		// the upstream names can contain slashes, the addresses can't
+		if ok && server != "" && !strings.Contains(server, "/") {
+			servers = append(servers, server)
+		}
+	}
+	slices.Sort(servers)
+
+	return servers
+}
+
+func (s *keyvalOwnershipStore) Load(ctx context.Context, upstream Upstream) ([]string, error) {
+	pairs, err := s.client.GetKeyValPairs(ctx, s.zone)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't get the key-value pairs of the zone %v: %w", s.zone, err)
+	}
+
+	return keyvalOwnedServers(pairs, upstream), nil
+}
+
+// Save adds the keys of the newly owned servers and deletes the keys of the servers that are no longer owned.
+func (s *keyvalOwnershipStore) Save(ctx context.Context, upstream Upstream, servers []string) error {
+	pairs, err := s.client.GetKeyValPairs(ctx, s.zone)
+	if err != nil {
+		return fmt.Errorf("couldn't get the key-value pairs of the zone %v: %w", s.zone, err)
+	}
+	stored := keyvalOwnedServers(pairs, upstream)
+	prefix := ownershipKey(upstream) + "/"
+
+	for _, server := range servers {
+		if slices.Contains(stored, server) {
+			continue
+		}
+		if err := s.client.AddKeyValPair(ctx, s.zone, prefix+server, ownedServerValue); err != nil {
+			return fmt.Errorf("couldn't store the owned server %v of %v in the zone %v: %w", server, upstream.Name, s.zone, err)
+		}
+	}
+	for _, server := range stored {
+		if slices.Contains(servers, server) {
+			continue
+		}
+		if err := s.client.DeleteKeyValuePair(ctx, s.zone, prefix+server); err != nil {
+			return fmt.Errorf("couldn't delete the owned server %v of %v from the zone %v: %w", server, upstream.Name, s.zone, err)
+		}
+	}
+
+	return nil
+}
+
+// stateOwnershipStore stores the owned servers of every upstream in the sync state, so that they are written to the
+// state file together with the rest of the state at the end of every sync cycle.
+type stateOwnershipStore struct {
+	state *stateStore
+}
+
+func (s *stateOwnershipStore) Load(_ context.Context, upstream Upstream) ([]string, error) {
+	return slices.Clone(s.state.upstream(upstream).Owned), nil
+}
+
+func (s *stateOwnershipStore) Save(_ context.Context, upstream Upstream, servers []string) error {
+	s.state.upstream(upstream).Owned = slices.Clone(servers)
+	return nil
+}
+
+// withForeignServers returns the discovered servers together with the servers of the upstream that
+// nginx-asg-sync doesn't own, so that updating the upstream with the result leaves the latter untouched.
+// A server whose address matches a discovered instance is considered owned.
+func withForeignServers(discovered, current []nginx.UpstreamServer, owned []string) []nginx.UpstreamServer {
+	managed := make(map[string]bool, len(discovered)+len(owned))
+	for _, s := range owned {
+		managed[s] = true
+	}
+	for _, s := range discovered {
+		managed[s.Server] = true
+	}
+
+	result := slices.Clone(discovered)
+	for _, s := range current {
+		if !managed[s.Server] {
+			result = append(result, s)
+		}
+	}
+
+	return result
+}
+
+// ownedServersAfterUpdate returns the addresses nginx-asg-sync owns after an update of the upstream.
+// Owned servers that failed to be removed stay owned so that their removal is retried in the next cycle.
+func ownedServersAfterUpdate(discovered, current []nginx.UpstreamServer, owned []string, added, removed []nginx.UpstreamServer) []string {
+	managed := make(map[string]bool, len(discovered)+len(owned))
+	for _, s := range owned {
+		managed[s] = true
+	}
+	for _, s := range discovered {
+		managed[s.Server] = true
+	}
+
+	result := make(map[string]bool)
+	for _, s := range current {
+		if managed[s.Server] {
+			result[s.Server] = true
+		}
+	}
+	for _, s := range removed {
+		delete(result, s.Server)
+	}
+	for _, s := range added {
+		result[s.Server] = true
+	}
+
+	servers := make([]string, 0, len(result))
+	for s := range result {
+		servers = append(servers, s)
+	}
+	slices.Sort(servers)
+
+	return servers
+}
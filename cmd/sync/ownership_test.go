@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"testing"
+
+	nginx "github.com/nginx/nginx-plus-go-client/v3/client"
+)
+
+type fakeKeyvalClient struct {
+	pairs map[string]nginx.KeyValPairs
+}
+
+func (c *fakeKeyvalClient) GetKeyValPairs(_ context.Context, zone string) (nginx.KeyValPairs, error) {
+	return c.pairs[zone], nil
+}
+
+func (c *fakeKeyvalClient) AddKeyValPair(_ context.Context, zone string, key string, val string) error {
+	if c.pairs[zone] == nil {
+		c.pairs[zone] = nginx.KeyValPairs{}
+	}
+	if _, ok := c.pairs[zone][key]; ok {
+		return &fakeStatusError{status: http.StatusConflict}
+	}
+	c.pairs[zone][key] = val
+	return nil
+}
+
+func (c *fakeKeyvalClient) ModifyKeyValPair(_ context.Context, zone string, key string, val string) error {
+	c.pairs[zone][key] = val
+	return nil
+}
+
+func (c *fakeKeyvalClient) DeleteKeyValuePair(_ context.Context, zone string, key string) error {
+	delete(c.pairs[zone], key)
+	return nil
+}
+
+func TestValidateOwnershipConfig(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		cfg       ownershipConfig
+		statePath string
+		msg       string
+		wantErr   bool
+	}{
+		{cfg: ownershipConfig{KeyvalZone: "owned"}, msg: "keyval zone"},
+		{cfg: ownershipConfig{}, statePath: "/var/lib/nginx-asg-sync/state.json", msg: "state file"},
+		{cfg: ownershipConfig{KeyvalZone: "owned"}, statePath: "/var/lib/nginx-asg-sync/state.json", msg: "keyval zone and state file"},
+		{cfg: ownershipConfig{}, msg: "no store", wantErr: true},
+	}
+
+	for _, test := range tests {
+		err := validateOwnershipConfig(&test.cfg, test.statePath)
+		if (err != nil) != test.wantErr {
+			t.Errorf("validateOwnershipConfig() returned %v for the config with %v", err, test.msg)
+		}
+	}
+}
+
+func TestOwnershipStores(t *testing.T) {
+	t.Parallel()
+	stores := map[string]ownershipStore{
+		"keyval": &keyvalOwnershipStore{client: &fakeKeyvalClient{pairs: map[string]nginx.KeyValPairs{}}, zone: "owned"},
+		"state":  &stateOwnershipStore{state: newMemoryStateStore()},
+	}
+	httpUps := Upstream{Name: "backend", Kind: "http"}
+	streamUps := Upstream{Name: "backend", Kind: "stream"}
+
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			ctx := context.Background()
+
+			owned, err := store.Load(ctx, httpUps)
+			if err != nil || len(owned) != 0 {
+				t.Fatalf("Load() of an empty store returned %v, %v", owned, err)
+			}
+
+			want := []string{"10.0.0.1:80", "10.0.0.2:80"}
+			if err := store.Save(ctx, httpUps, want); err != nil {
+				t.Fatalf("Save() failed: %v", err)
+			}
+			if err := store.Save(ctx, streamUps, []string{"10.0.0.3:53"}); err != nil {
+				t.Fatalf("Save() failed: %v", err)
+			}
+
+			owned, err = store.Load(ctx, httpUps)
+			if err != nil {
+				t.Fatalf("Load() failed: %v", err)
+			}
+			if !reflect.DeepEqual(owned, want) {
+				t.Errorf("Load() returned %v, expected %v", owned, want)
+			}
+
+			if err := store.Save(ctx, httpUps, nil); err != nil {
+				t.Fatalf("Save() failed: %v", err)
+			}
+			owned, err = store.Load(ctx, httpUps)
+			if err != nil || len(owned) != 0 {
+				t.Errorf("Load() after clearing returned %v, %v", owned, err)
+			}
+
+			owned, err = store.Load(ctx, streamUps)
+			if err != nil || !reflect.DeepEqual(owned, []string{"10.0.0.3:53"}) {
+				t.Errorf("Load() of the stream upstream returned %v, %v", owned, err)
+			}
+		})
+	}
+}
+
+func TestKeyvalOwnershipStoreKeys(t *testing.T) {
+	t.Parallel()
+	client := &fakeKeyvalClient{pairs: map[string]nginx.KeyValPairs{}}
+	store := &keyvalOwnershipStore{client: client, zone: "owned"}
+	upstream := Upstream{Name: "backend", Kind: "http"}
+	ctx := context.Background()
+
+	if err := store.Save(ctx, upstream, []string{"10.0.0.1:80", "10.0.0.2:80"}); err != nil {
+		t.Fatalf("Save() failed: %v", err)
+	}
+	if err := store.Save(ctx, Upstream{Name: "backend/v2", Kind: "http"}, []string{"10.0.0.9:80"}); err != nil {
+		t.Fatalf("Save() failed: %v", err)
+	}
+	// only the changed servers are added and deleted
+	if err := store.Save(ctx, upstream, []string{"10.0.0.2:80", "10.0.0.3:80"}); err != nil {
+		t.Fatalf("Save() failed: %v", err)
+	}
+
+	want := nginx.KeyValPairs{"http/backend/10.0.0.2:80": "1", "http/backend/10.0.0.3:80": "1", "http/backend/v2/10.0.0.9:80": "1"}
+	if !reflect.DeepEqual(client.pairs["owned"], want) {
+		t.Errorf("Save() stored %v, expected %v", client.pairs["owned"], want)
+	}
+
+	owned, err := store.Load(ctx, upstream)
+	if err != nil || !reflect.DeepEqual(owned, []string{"10.0.0.2:80", "10.0.0.3:80"}) {
+		t.Errorf("Load() returned %v, %v", owned, err)
+	}
+}
+
+func TestWithForeignServers(t *testing.T) {
+	t.Parallel()
+	discovered := []nginx.UpstreamServer{{Server: "10.0.0.1:80"}, {Server: "10.0.0.2:80"}}
+	current := []nginx.UpstreamServer{
+		{Server: "10.0.0.1:80"},
+		{Server: "10.0.0.3:80"},
+		{Server: "192.168.0.1:80", Backup: ptrBool(true)},
+	}
+	owned := []string{"10.0.0.1:80", "10.0.0.3:80"}
+
+	servers := withForeignServers(discovered, current, owned)
+	got := getUpstreamServerAddresses(servers)
+	want := []string{"10.0.0.1:80", "10.0.0.2:80", "192.168.0.1:80"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("withForeignServers() returned %v, expected %v", got, want)
+	}
+	if servers[2].Backup == nil || !*servers[2].Backup {
+		t.Errorf("withForeignServers() didn't preserve the parameters of the foreign server: %+v", servers[2])
+	}
+}
+
+func TestOwnedServersAfterUpdate(t *testing.T) {
+	t.Parallel()
+	discovered := []nginx.UpstreamServer{{Server: "10.0.0.1:80"}, {Server: "10.0.0.2:80"}, {Server: "10.0.0.5:80"}}
+	current := []nginx.UpstreamServer{
+		{Server: "10.0.0.1:80"},
+		{Server: "10.0.0.3:80"},
+		{Server: "10.0.0.4:80"},
+		{Server: "10.0.0.5:80"},
+		{Server: "192.168.0.1:80"},
+	}
+	owned := []string{"10.0.0.1:80", "10.0.0.3:80", "10.0.0.4:80", "10.0.0.9:80"}
+	added := []nginx.UpstreamServer{{Server: "10.0.0.2:80"}}
+	// the removal of 10.0.0.4:80 failed
+	removed := []nginx.UpstreamServer{{Server: "10.0.0.3:80"}}
+
+	got := ownedServersAfterUpdate(discovered, current, owned, added, removed)
+	want := []string{"10.0.0.1:80", "10.0.0.2:80", "10.0.0.4:80", "10.0.0.5:80"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ownedServersAfterUpdate() returned %v, expected %v", got, want)
+	}
+}
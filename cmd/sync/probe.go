@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	nginx "github.com/nginx/nginx-plus-go-client/v3/client"
+)
+
+const (
+	probeTypeTCP  = "tcp"
+	probeTypeHTTP = "http"
+
+	defaultProbeTimeout            = 2 * time.Second
+	defaultProbeExpectedStatus     = http.StatusOK
+	defaultProbeUnhealthyThreshold = 3
+
+	// maxConcurrentProbes limits the number of the instances probed at the same time.
+	maxConcurrentProbes = 16
+)
+
+// healthProbeConfig configures the probe of the discovered instances before their servers are added to the upstream.
+type healthProbeConfig struct {
+	Type               string        `yaml:"type"`
+	Path               string        `yaml:"path,omitempty"`
+	Timeout            time.Duration `yaml:"timeout,omitempty"`
+	Port               int           `yaml:"port,omitempty"`
+	ExpectedStatus     int           `yaml:"expected_status,omitempty"`
+	UnhealthyThreshold int           `yaml:"unhealthy_threshold,omitempty"`
+}
+
+func validateHealthProbeConfig(cfg *healthProbeConfig, upstreamName string) error {
+	if cfg.Type != probeTypeTCP && cfg.Type != probeTypeHTTP {
+		return fmt.Errorf(upstreamProbeTypeErrorMsgFmt, cfg.Type, upstreamName)
+	}
+
+	if cfg.Type == probeTypeHTTP && cfg.Path != "" && cfg.Path[0] != '/' {
+		return fmt.Errorf(upstreamProbePathErrorMsgFmt, cfg.Path, upstreamName)
+	}
+
+	if cfg.Timeout < 0 || cfg.Port < 0 || cfg.Port > 65535 || cfg.UnhealthyThreshold < 0 ||
+		(cfg.ExpectedStatus != 0 && (cfg.ExpectedStatus < 100 || cfg.ExpectedStatus > 599)) {
+		return fmt.Errorf(upstreamProbeErrorMsgFmt, upstreamName)
+	}
+
+	return nil
+}
+
+// probeFunc probes the address, which is the IP and the port of an instance.
+type probeFunc func(ctx context.Context, cfg *healthProbeConfig, address string) error
+
+// probeResult is the admission state of the server of an instance.
+type probeResult struct {
+	failures int
+	admitted bool
+}
+
+// healthProber admits the servers of the discovered instances that passed the health probe of the upstream. An admitted
+// server is dropped after unhealthy_threshold consecutive failed probes. The results are kept only in memory, so after
+// a restart every instance must pass the probe again.
+type healthProber struct {
+	probe   probeFunc
+	results map[upstreamKey]map[string]*probeResult
+	mu      sync.Mutex
+}
+
+func newHealthProber() *healthProber {
+	return &healthProber{probe: probeInstance, results: make(map[upstreamKey]map[string]*probeResult)}
+}
+
+// admit probes the servers and returns the admitted ones.
+func (p *healthProber) admit(ctx context.Context, upstream Upstream, servers []nginx.UpstreamServer) []nginx.UpstreamServer {
+	cfg := upstream.HealthProbe
+	threshold := cfg.UnhealthyThreshold
+	if threshold == 0 {
+		threshold = defaultProbeUnhealthyThreshold
+	}
+
+	errs := make([]error, len(servers))
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, maxConcurrentProbes)
+	for i, server := range servers {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			errs[i] = p.probe(ctx, cfg, probeTarget(server.Server, cfg.Port))
+		}()
+	}
+	wg.Wait()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	key := upstreamKey{name: upstream.Name, kind: upstream.Kind}
+	previous := p.results[key]
+	results := make(map[string]*probeResult, len(servers))
+	admitted := make([]nginx.UpstreamServer, 0, len(servers))
+	for i, server := range servers {
+		result, ok := previous[server.Server]
+		if !ok {
+			result = &probeResult{}
+		}
+		results[server.Server] = result
+
+		if errs[i] == nil {
+			if !result.admitted {
+				slog.Info("Instance passed the health probe", "upstream", upstream.Name, "kind", upstream.Kind, "server", server.Server)
+			}
+			result.admitted = true
+			result.failures = 0
+		} else {
+			result.failures++
+			if result.admitted && result.failures >= threshold {
+				slog.Warn("Dropping the server after failed health probes", "upstream", upstream.Name, "kind", upstream.Kind,
+					"server", server.Server, "failures", result.failures, "error", errs[i])
+				result.admitted = false
+			} else if !result.admitted {
+				slog.Debug("Instance didn't pass the health probe", "upstream", upstream.Name, "kind", upstream.Kind,
+					"server", server.Server, "error", errs[i])
+			}
+		}
+
+		if result.admitted {
+			admitted = append(admitted, server)
+		}
+	}
+	// the instances that are gone are forgotten
+	p.results[key] = results
+
+	return admitted
+}
+
+// probeTarget returns the address to probe for the server: the IP of the server with the port of the probe or, if not
+// set, the port of the server.
+func probeTarget(server string, port int) string {
+	if port == 0 {
+		return server
+	}
+	host, _, err := net.SplitHostPort(server)
+	if err != nil {
+		return server
+	}
+	return net.JoinHostPort(host, strconv.Itoa(port))
+}
+
+// probeClient sends the HTTP probes. The redirects are not followed, so that the status of the instance is checked.
+var probeClient = &http.Client{
+	CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+}
+
+// probeInstance probes the address with a TCP connection or an HTTP GET request.
+func probeInstance(ctx context.Context, cfg *healthProbeConfig, address string) error {
+	timeout := cfg.Timeout
+	if timeout == 0 {
+		timeout = defaultProbeTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	if cfg.Type == probeTypeTCP {
+		var d net.Dialer
+		conn, err := d.DialContext(ctx, "tcp", address)
+		if err != nil {
+			return fmt.Errorf("couldn't connect to %v: %w", address, err)
+		}
+		conn.Close()
+		return nil
+	}
+
+	path := cfg.Path
+	if path == "" {
+		path = "/"
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://"+address+path, http.NoBody)
+	if err != nil {
+		return fmt.Errorf("couldn't create the probe request: %w", err)
+	}
+	resp, err := probeClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("couldn't send the probe request: %w", err)
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+
+	expected := cfg.ExpectedStatus
+	if expected == 0 {
+		expected = defaultProbeExpectedStatus
+	}
+	if resp.StatusCode != expected {
+		return fmt.Errorf("the probe of %v returned the status %d, expected %d", address, resp.StatusCode, expected)
+	}
+	return nil
+}
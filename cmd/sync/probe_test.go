@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	nginx "github.com/nginx/nginx-plus-go-client/v3/client"
+)
+
+func TestValidateHealthProbeConfig(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		msg     string
+		cfg     healthProbeConfig
+		wantErr bool
+	}{
+		{cfg: healthProbeConfig{Type: "tcp"}, msg: "tcp probe"},
+		{cfg: healthProbeConfig{Type: "http", Path: "/health", ExpectedStatus: 204, Port: 8080}, msg: "http probe"},
+		{cfg: healthProbeConfig{}, msg: "no type", wantErr: true},
+		{cfg: healthProbeConfig{Type: "grpc"}, msg: "invalid type", wantErr: true},
+		{cfg: healthProbeConfig{Type: "http", Path: "health"}, msg: "relative path", wantErr: true},
+		{cfg: healthProbeConfig{Type: "http", ExpectedStatus: 1000}, msg: "invalid status", wantErr: true},
+		{cfg: healthProbeConfig{Type: "tcp", Port: 70000}, msg: "invalid port", wantErr: true},
+		{cfg: healthProbeConfig{Type: "tcp", UnhealthyThreshold: -1}, msg: "negative threshold", wantErr: true},
+		{cfg: healthProbeConfig{Type: "tcp", Timeout: -1}, msg: "negative timeout", wantErr: true},
+	}
+
+	for _, test := range tests {
+		err := validateHealthProbeConfig(&test.cfg, "backend")
+		if (err != nil) != test.wantErr {
+			t.Errorf("validateHealthProbeConfig() returned %v for the case of %v", err, test.msg)
+		}
+	}
+}
+
+func TestHealthProberAdmit(t *testing.T) {
+	t.Parallel()
+	upstream := Upstream{Name: "backend", Kind: "http", HealthProbe: &healthProbeConfig{Type: "tcp", UnhealthyThreshold: 2}}
+	servers := []nginx.UpstreamServer{{Server: "10.0.0.1:80"}, {Server: "10.0.0.2:80"}}
+	healthy := map[string]bool{"10.0.0.1:80": true}
+	p := newHealthProber()
+	p.probe = func(_ context.Context, _ *healthProbeConfig, address string) error {
+		if !healthy[address] {
+			return errors.New("connection refused")
+		}
+		return nil
+	}
+	ctx := context.Background()
+
+	admit := func(msg string, want []string) {
+		t.Helper()
+		got := getUpstreamServerAddresses(p.admit(ctx, upstream, servers))
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("admit() returned %v %v, expected %v", got, msg, want)
+		}
+	}
+
+	admit("with a failing instance", []string{"10.0.0.1:80"})
+
+	healthy["10.0.0.2:80"] = true
+	admit("after the instance passed the probe", []string{"10.0.0.1:80", "10.0.0.2:80"})
+
+	healthy["10.0.0.2:80"] = false
+	admit("after a failed probe below the threshold", []string{"10.0.0.1:80", "10.0.0.2:80"})
+	admit("after the failed probes reached the threshold", []string{"10.0.0.1:80"})
+
+	// a passed probe resets the failures
+	healthy["10.0.0.2:80"] = true
+	admit("after the instance recovered", []string{"10.0.0.1:80", "10.0.0.2:80"})
+	healthy["10.0.0.2:80"] = false
+	admit("after a failed probe of the recovered instance", []string{"10.0.0.1:80", "10.0.0.2:80"})
+}
+
+func TestProbeTarget(t *testing.T) {
+	t.Parallel()
+	if got := probeTarget("10.0.0.1:80", 0); got != "10.0.0.1:80" {
+		t.Errorf("probeTarget() returned %v, expected 10.0.0.1:80", got)
+	}
+	if got := probeTarget("10.0.0.1:80", 8080); got != "10.0.0.1:8080" {
+		t.Errorf("probeTarget() returned %v, expected 10.0.0.1:8080", got)
+	}
+}
+
+func TestProbeInstance(t *testing.T) {
+	t.Parallel()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/health" {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	address := strings.TrimPrefix(server.URL, "http://")
+	ctx := context.Background()
+
+	tests := []struct {
+		cfg     *healthProbeConfig
+		msg     string
+		wantErr bool
+	}{
+		{cfg: &healthProbeConfig{Type: "tcp"}, msg: "tcp probe"},
+		{cfg: &healthProbeConfig{Type: "http", Path: "/health"}, msg: "http probe"},
+		{cfg: &healthProbeConfig{Type: "http", Path: "/missing"}, msg: "http probe with an unexpected status", wantErr: true},
+		{cfg: &healthProbeConfig{Type: "http", Path: "/missing", ExpectedStatus: http.StatusNotFound}, msg: "http probe with the expected status"},
+	}
+
+	for _, test := range tests {
+		err := probeInstance(ctx, test.cfg, address)
+		if (err != nil) != test.wantErr {
+			t.Errorf("probeInstance() returned %v for the case of %v", err, test.msg)
+		}
+	}
+
+	server.Close()
+	if err := probeInstance(ctx, &healthProbeConfig{Type: "tcp"}, address); err == nil {
+		t.Error("probeInstance() succeeded for a closed port")
+	}
+}
@@ -1,10 +1,62 @@
 package main
 
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
 // CloudProvider is the interface to connect with any cloud provider.
 type CloudProvider interface {
-	GetPrivateIPsForScalingGroup(name string) ([]string, error)
-	CheckIfScalingGroupExists(name string) (bool, error)
+	GetInstancesForScalingGroup(ctx context.Context, name string) ([]Instance, error)
+	CheckIfScalingGroupExists(ctx context.Context, name string) (bool, error)
 	GetUpstreams() []Upstream
+	GetLocalZone(ctx context.Context) (string, error)
+	// ReportUnhealthyInstance asks the scaling group to replace or repair the instance. It returns errInstanceNotFound
+	// if the instance doesn't belong to the scaling group.
+	ReportUnhealthyInstance(ctx context.Context, group string, id string) error
+}
+
+// batchCloudProvider is implemented by the cloud providers that can get the instances of several scaling groups with
+// fewer API calls than one call for every scaling group.
+type batchCloudProvider interface {
+	// GetInstancesForScalingGroups returns the instances of the scaling groups by the names of the groups. The scaling
+	// groups that don't exist are left out.
+	GetInstancesForScalingGroups(ctx context.Context, names []string) (map[string][]Instance, error)
+}
+
+// upstreamSourceSetter is implemented by the cloud providers whose discovery of instances depends on the settings of
+// the upstreams, such as in_service or address_selection, so that they consider the discovered upstreams too.
+type upstreamSourceSetter interface {
+	// SetUpstreamSource sets the function that returns all the synced upstreams, including the discovered ones. By
+	// default, only the upstreams of the config are considered.
+	SetUpstreamSource(source func() []Upstream)
+}
+
+// upstreamDiscoverer is implemented by the cloud providers that can discover upstreams from the tags of the scaling
+// groups.
+type upstreamDiscoverer interface {
+	// UpstreamDiscovery returns the upstream_discovery config with the defaults set, or nil if the upstreams aren't
+	// discovered.
+	UpstreamDiscovery() *upstreamDiscoveryConfig
+	// GetTaggedScalingGroups returns the tags of the scaling groups that have the tag key, by the names of the groups.
+	GetTaggedScalingGroups(ctx context.Context, key string) (map[string]map[string]string, error)
+}
+
+// errInstanceNotFound is returned by ReportUnhealthyInstance for an instance that doesn't belong to the scaling group.
+var errInstanceNotFound = errors.New("the instance doesn't belong to the scaling group")
+
+// Instance is the cloud agnostic representation of an instance (virtual machine) of a scaling group.
+type Instance struct {
+	LaunchTime time.Time
+	ID         string
+	PrivateIP  string
+	Zone       string
+	// Tags are the tags of the instance for instance_tags.
+	Tags map[string]string
+	// Addresses are the addresses of the network interfaces of the instance for address_selection.
+	Addresses []Address
 }
 
 func validateCloudProvider(provider string) bool {
@@ -15,3 +67,23 @@ func validateCloudProvider(provider string) bool {
 
 	return providers[provider]
 }
+
+// newCloudProvider creates the client of the cloud provider of the config.
+func newCloudProvider(cfg *commonConfig, data []byte) (CloudProvider, error) {
+	var client CloudProvider
+	var err error
+
+	switch cfg.CloudProvider {
+	case "AWS":
+		client, err = NewAWSClient(data)
+	case "Azure":
+		client, err = NewAzureClient(data)
+	default:
+		return nil, fmt.Errorf(cloudProviderErrorMsg, cfg.CloudProvider)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+	return client, nil
+}
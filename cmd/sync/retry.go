@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	nginx "github.com/nginx/nginx-plus-go-client/v3/client"
+)
+
+const (
+	defaultRetryMaxAttempts = 3
+	defaultRetryBaseDelay   = time.Second
+	defaultRetryMaxDelay    = 30 * time.Second
+	defaultRetryJitter      = 0.5
+)
+
+// retryConfig configures the retries of the failed cloud provider and NGINX Plus API calls.
+type retryConfig struct {
+	Jitter      *float64      `yaml:"jitter,omitempty"`
+	MaxAttempts int           `yaml:"max_attempts,omitempty"`
+	BaseDelay   time.Duration `yaml:"base_delay,omitempty"`
+	MaxDelay    time.Duration `yaml:"max_delay,omitempty"`
+}
+
+func validateRetryConfig(cfg *retryConfig) error {
+	if cfg.MaxAttempts < 0 {
+		return errors.New(retryMaxAttemptsErrorMsg)
+	}
+	if cfg.BaseDelay < 0 || cfg.MaxDelay < 0 || (cfg.MaxDelay > 0 && cfg.MaxDelay < cfg.BaseDelay) {
+		return errors.New(retryDelayErrorMsg)
+	}
+	if cfg.Jitter != nil && (*cfg.Jitter < 0 || *cfg.Jitter > 1) {
+		return errors.New(retryJitterErrorMsg)
+	}
+	return nil
+}
+
+// retryPolicy retries the calls that fail with a retryable error, waiting with an exponential backoff with jitter
+// between the attempts, so that many instances of nginx-asg-sync don't retry at the same time.
+type retryPolicy struct {
+	sleep       func(ctx context.Context, d time.Duration) error
+	maxAttempts int
+	baseDelay   time.Duration
+	maxDelay    time.Duration
+	jitter      float64
+}
+
+// newRetryPolicy creates the retryPolicy of the config. A nil config means the default policy.
+func newRetryPolicy(cfg *retryConfig) *retryPolicy {
+	p := &retryPolicy{
+		sleep:       sleepContext,
+		maxAttempts: defaultRetryMaxAttempts,
+		baseDelay:   defaultRetryBaseDelay,
+		maxDelay:    defaultRetryMaxDelay,
+		jitter:      defaultRetryJitter,
+	}
+	if cfg == nil {
+		return p
+	}
+
+	if cfg.MaxAttempts > 0 {
+		p.maxAttempts = cfg.MaxAttempts
+	}
+	if cfg.BaseDelay > 0 {
+		p.baseDelay = cfg.BaseDelay
+	}
+	if cfg.MaxDelay > 0 {
+		p.maxDelay = cfg.MaxDelay
+	}
+	if cfg.Jitter != nil {
+		p.jitter = *cfg.Jitter
+	}
+	return p
+}
+
+// do calls fn until it succeeds, fails with an error that isn't retryable or the attempts are exhausted, and returns
+// the last error. The operation describes the call in the log. A nil policy calls fn once.
+func (p *retryPolicy) do(ctx context.Context, operation string, fn func() error) error {
+	if p == nil {
+		return fn()
+	}
+
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || attempt >= p.maxAttempts || !isRetryableError(err) {
+			return err
+		}
+
+		delay := p.delay(attempt)
+		slog.Warn("Retrying a failed call", "operation", operation, "delay", delay, "attempt", attempt+1, "max_attempts", p.maxAttempts, "error", err)
+		if sleepErr := p.sleep(ctx, delay); sleepErr != nil {
+			return err
+		}
+	}
+}
+
+// delay returns the wait before the attempt after the given one: the base delay doubled for every attempt, capped at
+// the max delay and reduced by a random part of up to the jitter fraction.
+func (p *retryPolicy) delay(attempt int) time.Duration {
+	d := p.maxDelay
+	if shift := attempt - 1; shift < 32 && p.baseDelay<<shift < p.maxDelay && p.baseDelay<<shift > 0 {
+		d = p.baseDelay << shift
+	}
+	return d - time.Duration(p.jitter*rand.Float64()*float64(d)) //nolint:gosec // jitter doesn't need a secure random
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// isRetryableError reports whether a call that failed with the error is worth retrying: throttling, server errors,
+// timeouts and refused or reset connections are retryable; everything else, for example an upstream that doesn't
+// exist or missing permissions, is fatal.
+func isRetryableError(err error) bool {
+	if errors.Is(err, context.Canceled) {
+		return false
+	}
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.ECONNRESET) {
+		return true
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+
+	// AWS
+	var codeErr interface{ ErrorCode() string }
+	if errors.As(err, &codeErr) {
+		if _, ok := retry.DefaultThrottleErrorCodes[codeErr.ErrorCode()]; ok {
+			return true
+		}
+	}
+	var awsRespErr interface{ HTTPStatusCode() int }
+	if errors.As(err, &awsRespErr) {
+		return isRetryableStatus(awsRespErr.HTTPStatusCode())
+	}
+
+	// Azure
+	var azureRespErr *azcore.ResponseError
+	if errors.As(err, &azureRespErr) {
+		return isRetryableStatus(azureRespErr.StatusCode)
+	}
+
+	// webhooks
+	var webhookErr *webhookStatusError
+	if errors.As(err, &webhookErr) {
+		return isRetryableStatus(webhookErr.status)
+	}
+
+	// NGINX Plus
+	var nginxErr nginx.StatusError
+	if errors.As(err, &nginxErr) {
+		return isRetryableStatus(nginxErr.Status())
+	}
+
+	return false
+}
+
+func isRetryableStatus(status int) bool {
+	return status == http.StatusTooManyRequests || status == http.StatusRequestTimeout || status >= http.StatusInternalServerError
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+)
+
+type fakeAPIError struct {
+	code   string
+	status int
+}
+
+func (e *fakeAPIError) Error() string       { return e.code }
+func (e *fakeAPIError) ErrorCode() string   { return e.code }
+func (e *fakeAPIError) HTTPStatusCode() int { return e.status }
+
+type fakeStatusError struct {
+	status int
+}
+
+func (e *fakeStatusError) Error() string { return fmt.Sprintf("status %d", e.status) }
+func (e *fakeStatusError) Status() int   { return e.status }
+func (e *fakeStatusError) Code() string  { return "" }
+
+func newTestRetryPolicy(maxAttempts int, delays *[]time.Duration) *retryPolicy {
+	p := newRetryPolicy(&retryConfig{MaxAttempts: maxAttempts, BaseDelay: time.Second, MaxDelay: 3 * time.Second})
+	p.sleep = func(_ context.Context, d time.Duration) error {
+		*delays = append(*delays, d)
+		return nil
+	}
+	return p
+}
+
+func TestRetryPolicyDo(t *testing.T) {
+	t.Parallel()
+	throttled := &fakeAPIError{code: "Throttling", status: http.StatusBadRequest}
+
+	var delays []time.Duration
+	calls := 0
+	err := newTestRetryPolicy(4, &delays).do(context.Background(), "test", func() error {
+		calls++
+		if calls < 3 {
+			return throttled
+		}
+		return nil
+	})
+	if err != nil || calls != 3 || len(delays) != 2 {
+		t.Errorf("do() returned %v after %d calls and %d waits, expected success after 3 calls", err, calls, len(delays))
+	}
+
+	delays = nil
+	calls = 0
+	err = newTestRetryPolicy(3, &delays).do(context.Background(), "test", func() error {
+		calls++
+		return throttled
+	})
+	if !errors.Is(err, throttled) || calls != 3 {
+		t.Errorf("do() returned %v after %d calls, expected the last error after 3 calls", err, calls)
+	}
+
+	delays = nil
+	calls = 0
+	fatal := &fakeStatusError{status: http.StatusNotFound}
+	err = newTestRetryPolicy(3, &delays).do(context.Background(), "test", func() error {
+		calls++
+		return fatal
+	})
+	if !errors.Is(err, fatal) || calls != 1 {
+		t.Errorf("do() returned %v after %d calls, expected the fatal error after 1 call", err, calls)
+	}
+
+	var nilPolicy *retryPolicy
+	calls = 0
+	_ = nilPolicy.do(context.Background(), "test", func() error {
+		calls++
+		return throttled
+	})
+	if calls != 1 {
+		t.Errorf("do() of a nil policy made %d calls, expected 1", calls)
+	}
+}
+
+func TestRetryPolicyDelay(t *testing.T) {
+	t.Parallel()
+	noJitter := 0.0
+	p := newRetryPolicy(&retryConfig{BaseDelay: time.Second, MaxDelay: 5 * time.Second, Jitter: &noJitter})
+
+	expected := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second}
+	for i, want := range expected {
+		if got := p.delay(i + 1); got != want {
+			t.Errorf("delay(%d) returned %v, expected %v", i+1, got, want)
+		}
+	}
+	if got := p.delay(100); got != 5*time.Second {
+		t.Errorf("delay(100) returned %v, expected the max delay", got)
+	}
+
+	p = newRetryPolicy(&retryConfig{BaseDelay: time.Second, MaxDelay: 5 * time.Second})
+	for range 100 {
+		if got := p.delay(2); got < time.Second || got > 2*time.Second {
+			t.Fatalf("delay(2) returned %v with the default jitter, expected between 1s and 2s", got)
+		}
+	}
+}
+
+func TestIsRetryableError(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		err      error
+		msg      string
+		expected bool
+	}{
+		{err: &fakeAPIError{code: "RequestLimitExceeded", status: http.StatusServiceUnavailable}, msg: "AWS throttling", expected: true},
+		{err: &fakeAPIError{code: "InternalError", status: http.StatusInternalServerError}, msg: "AWS server error", expected: true},
+		{err: &fakeAPIError{code: "UnauthorizedOperation", status: http.StatusForbidden}, msg: "AWS missing permissions"},
+		{err: fmt.Errorf("listing: %w", &azcore.ResponseError{StatusCode: http.StatusTooManyRequests}), msg: "Azure throttling", expected: true},
+		{err: &azcore.ResponseError{StatusCode: http.StatusNotFound}, msg: "Azure not found"},
+		{err: fmt.Errorf("update: %w", &fakeStatusError{status: http.StatusBadGateway}), msg: "NGINX bad gateway", expected: true},
+		{err: &fakeStatusError{status: http.StatusBadRequest}, msg: "NGINX bad request"},
+		{err: os.ErrDeadlineExceeded, msg: "timeout", expected: true},
+		{err: fmt.Errorf("dial: %w", syscall.ECONNREFUSED), msg: "connection refused", expected: true},
+		{err: context.Canceled, msg: "canceled"},
+		{err: errors.New("scaling group doesn't exist"), msg: "unknown error"},
+	}
+
+	for _, test := range tests {
+		if result := isRetryableError(test.err); result != test.expected {
+			t.Errorf("isRetryableError() returned %v for %v, expected %v", result, test.msg, test.expected)
+		}
+	}
+}
+
+func TestValidateRetryConfig(t *testing.T) {
+	t.Parallel()
+	jitter := 0.3
+	invalidJitter := 1.5
+	tests := []struct {
+		msg     string
+		cfg     retryConfig
+		wantErr bool
+	}{
+		{cfg: retryConfig{MaxAttempts: 5, BaseDelay: time.Second, MaxDelay: time.Minute, Jitter: &jitter}, msg: "all fields"},
+		{cfg: retryConfig{MaxAttempts: 1}, msg: "retries disabled"},
+		{cfg: retryConfig{MaxAttempts: -1}, msg: "negative max_attempts", wantErr: true},
+		{cfg: retryConfig{BaseDelay: time.Minute, MaxDelay: time.Second}, msg: "max_delay less than base_delay", wantErr: true},
+		{cfg: retryConfig{Jitter: &invalidJitter}, msg: "jitter above 1", wantErr: true},
+	}
+
+	for _, test := range tests {
+		err := validateRetryConfig(&test.cfg)
+		if (err != nil) != test.wantErr {
+			t.Errorf("validateRetryConfig() returned %v for the config with %v", err, test.msg)
+		}
+	}
+}
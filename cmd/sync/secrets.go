@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+const redacted = "<redacted>"
+
+var envReference = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// sensitiveHeaders are the headers whose values are always redacted in the logs.
+var sensitiveHeaders = map[string]bool{
+	"Authorization":       true,
+	"Proxy-Authorization": true,
+	"Cookie":              true,
+	"X-Api-Key":           true,
+}
+
+// headerSecretConfig configures where the value of a custom header is read from. The value is read from either an
+// environment variable or a file, and appended to the optional prefix, for example "Bearer ".
+type headerSecretConfig struct {
+	Env    string `yaml:"env,omitempty"`
+	File   string `yaml:"file,omitempty"`
+	Prefix string `yaml:"prefix,omitempty"`
+}
+
+func validateHeaderSecretConfig(cfg headerSecretConfig, header string) error {
+	if (cfg.Env == "") == (cfg.File == "") {
+		return fmt.Errorf(headerSecretErrorMsgFmt, header)
+	}
+	return nil
+}
+
+// expandEnv replaces the ${VAR} references in the value with the values of the environment variables.
+// Unlike os.ExpandEnv, it leaves a single $ untouched and fails if a variable is not set.
+func expandEnv(value string) (string, error) {
+	var errs []error
+	expanded := envReference.ReplaceAllStringFunc(value, func(ref string) string {
+		name := envReference.FindStringSubmatch(ref)[1]
+		v, ok := os.LookupEnv(name)
+		if !ok {
+			errs = append(errs, fmt.Errorf("the environment variable %v is not set", name))
+		}
+		return v
+	})
+	return expanded, errors.Join(errs...)
+}
+
+// headerValueSource provides the current value of a custom header.
+type headerValueSource interface {
+	Value(ctx context.Context) (string, error)
+}
+
+func newHeaderValueSource(cfg headerSecretConfig) (headerValueSource, error) {
+	if cfg.Env != "" {
+		v, ok := os.LookupEnv(cfg.Env)
+		if !ok {
+			return nil, fmt.Errorf("the environment variable %v is not set", cfg.Env)
+		}
+		return staticHeaderValue(cfg.Prefix + strings.TrimSpace(v)), nil
+	}
+
+	src := &fileHeaderValue{path: cfg.File, prefix: cfg.Prefix}
+	if _, err := src.Value(context.TODO()); err != nil {
+		return nil, err
+	}
+	return src, nil
+}
+
+type staticHeaderValue string
+
+func (v staticHeaderValue) Value(context.Context) (string, error) {
+	return string(v), nil
+}
+
+// fileHeaderValue reads the value of a header from a file and reads it again when the file changes, so that
+// rotated tokens are used without a restart.
+type fileHeaderValue struct {
+	modTime time.Time
+	path    string
+	prefix  string
+	value   string
+	mu      sync.Mutex
+}
+
+func (f *fileHeaderValue) Value(context.Context) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	info, err := os.Stat(f.path)
+	if err == nil && info.ModTime().Equal(f.modTime) {
+		return f.value, nil
+	}
+
+	var data []byte
+	if err == nil {
+		data, err = os.ReadFile(f.path)
+	}
+	if err != nil {
+		if f.value != "" {
+			slog.Warn("Couldn't read the header value file, using the previous value", "path", f.path, "error", err)
+			return f.value, nil
+		}
+		return "", fmt.Errorf("couldn't read the header value file: %w", err)
+	}
+
+	f.value = f.prefix + strings.TrimSpace(string(data))
+	f.modTime = info.ModTime()
+	return f.value, nil
+}
+
+// redactedCustomHeaders returns the custom headers of the config safe for logging: the values of the sensitive
+// headers, of the headers with environment variables, of the headers from secrets and of api_auth are redacted.
+func redactedCustomHeaders(cfg *commonConfig) http.Header {
+	headers := make(http.Header, len(cfg.CustomHeaders)+len(cfg.CustomHeaderSecrets))
+	for key, value := range cfg.CustomHeaders {
+		if sensitiveHeaders[http.CanonicalHeaderKey(key)] || envReference.MatchString(value) {
+			value = redacted
+		}
+		headers.Set(key, value)
+	}
+	for key := range cfg.CustomHeaderSecrets {
+		headers.Set(key, redacted)
+	}
+	if cfg.APIAuth != nil {
+		headers.Set(authorizationHeader, redacted)
+	}
+	return headers
+}
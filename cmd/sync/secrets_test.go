@@ -0,0 +1,152 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestExpandEnv(t *testing.T) {
+	t.Setenv("ASG_SYNC_TEST_KEY", "secret-key")
+
+	tests := []struct {
+		value    string
+		expected string
+		wantErr  bool
+	}{
+		{value: "ApiKey ${ASG_SYNC_TEST_KEY}", expected: "ApiKey secret-key"},
+		{value: "price: $5", expected: "price: $5"},
+		{value: "$ASG_SYNC_TEST_KEY", expected: "$ASG_SYNC_TEST_KEY"},
+		{value: "${ASG_SYNC_TEST_MISSING}", wantErr: true},
+	}
+
+	for _, test := range tests {
+		result, err := expandEnv(test.value)
+		if (err != nil) != test.wantErr {
+			t.Errorf("expandEnv(%q) returned the error %v", test.value, err)
+			continue
+		}
+		if !test.wantErr && result != test.expected {
+			t.Errorf("expandEnv(%q) returned %q, expected %q", test.value, result, test.expected)
+		}
+	}
+}
+
+func TestValidateHeaderSecretConfig(t *testing.T) {
+	t.Parallel()
+	if err := validateHeaderSecretConfig(headerSecretConfig{Env: "TOKEN"}, "Authorization"); err != nil {
+		t.Errorf("validateHeaderSecretConfig() failed for the env source: %v", err)
+	}
+	if err := validateHeaderSecretConfig(headerSecretConfig{Env: "TOKEN", File: "/run/token"}, "Authorization"); err == nil {
+		t.Error("validateHeaderSecretConfig() didn't fail for both sources")
+	}
+	if err := validateHeaderSecretConfig(headerSecretConfig{Prefix: "Bearer "}, "Authorization"); err == nil {
+		t.Error("validateHeaderSecretConfig() didn't fail for no source")
+	}
+}
+
+func TestFileHeaderValue(t *testing.T) {
+	t.Parallel()
+	path := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(path, []byte("first\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	src, err := newHeaderValueSource(headerSecretConfig{File: path, Prefix: "Bearer "})
+	if err != nil {
+		t.Fatalf("newHeaderValueSource() failed: %v", err)
+	}
+	if value, _ := src.Value(t.Context()); value != "Bearer first" {
+		t.Errorf("Value() returned %q, expected %q", value, "Bearer first")
+	}
+
+	if err := os.WriteFile(path, []byte("second\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(path, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if value, _ := src.Value(t.Context()); value != "Bearer second" {
+		t.Errorf("Value() returned %q after the rotation, expected %q", value, "Bearer second")
+	}
+
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	if value, err := src.Value(t.Context()); err != nil || value != "Bearer second" {
+		t.Errorf("Value() returned %q and %v for the removed file, expected the previous value", value, err)
+	}
+
+	if _, err := newHeaderValueSource(headerSecretConfig{File: path}); err == nil {
+		t.Error("newHeaderValueSource() didn't fail for a missing file")
+	}
+}
+
+func TestNewHTTPClientWithHeaderSecrets(t *testing.T) {
+	t.Setenv("ASG_SYNC_TEST_TENANT", "tenant-1")
+	path := filepath.Join(t.TempDir(), "key")
+	if err := os.WriteFile(path, []byte("dataplane-key"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	var gotHeaders http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotHeaders = r.Header.Clone()
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	cfg := &commonConfig{
+		CustomHeaders:       map[string]string{"X-Tenant": "${ASG_SYNC_TEST_TENANT}"},
+		CustomHeaderSecrets: map[string]headerSecretConfig{"Authorization": {File: path, Prefix: "ApiKey "}},
+	}
+	client, err := NewHTTPClient(cfg)
+	if err != nil {
+		t.Fatalf("NewHTTPClient() failed: %v", err)
+	}
+	if err := get(t, client, server.URL); err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+
+	if got := gotHeaders.Get("X-Tenant"); got != "tenant-1" {
+		t.Errorf("the server got the header X-Tenant %q, expected %q", got, "tenant-1")
+	}
+	if got := gotHeaders.Get("Authorization"); got != "ApiKey dataplane-key" {
+		t.Errorf("the server got the header Authorization %q, expected %q", got, "ApiKey dataplane-key")
+	}
+
+	cfg.CustomHeaders["X-Tenant"] = "${ASG_SYNC_TEST_MISSING}"
+	if _, err := NewHTTPClient(cfg); err == nil {
+		t.Error("NewHTTPClient() didn't fail for a missing environment variable")
+	}
+}
+
+func TestRedactedCustomHeaders(t *testing.T) {
+	t.Parallel()
+	cfg := &commonConfig{
+		CustomHeaders: map[string]string{
+			"Content-Type":  "application/json",
+			"authorization": "Basic dXNlcjpwYXNz",
+			"X-Tenant":      "${TENANT}",
+		},
+		CustomHeaderSecrets: map[string]headerSecretConfig{"X-Token": {Env: "TOKEN"}},
+	}
+
+	headers := redactedCustomHeaders(cfg)
+
+	expected := map[string]string{
+		"Content-Type":  "application/json",
+		"Authorization": redacted,
+		"X-Tenant":      redacted,
+		"X-Token":       redacted,
+	}
+	for key, value := range expected {
+		if got := headers.Get(key); got != value {
+			t.Errorf("redactedCustomHeaders() returned %q for %v, expected %q", got, key, value)
+		}
+	}
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	nginx "github.com/nginx/nginx-plus-go-client/v3/client"
+)
+
+const (
+	// stateRetention is how long an instance that is no longer in the scaling groups is kept in the state.
+	stateRetention = 24 * time.Hour
+	// defaultMassRemovalDelay is how long the removal of most of the instances of an upstream is refused by default.
+	defaultMassRemovalDelay = 5 * time.Minute
+)
+
+// massRemovalConfig guards the upstreams against losing most of their servers at once, for example when the API of the
+// cloud provider returns an incomplete list of instances.
+type massRemovalConfig struct {
+	MaxPercent int           `yaml:"max_percent"`
+	Delay      time.Duration `yaml:"delay,omitempty"`
+}
+
+func validateMassRemovalConfig(cfg *massRemovalConfig) error {
+	if cfg.MaxPercent < 1 || cfg.MaxPercent > 99 || cfg.Delay < 0 {
+		return errors.New(massRemovalErrorMsg)
+	}
+	return nil
+}
+
+// syncState is the memory of nginx-asg-sync between the sync cycles: the instances seen in the scaling groups of every
+// upstream, the servers being drained and the owned servers. With state_path, it is persisted, so that it survives
+// restarts.
+type syncState struct {
+	Upstreams map[string]*upstreamState `json:"upstreams"`
+}
+
+// upstreamState is the state of an upstream, identified by ownershipKey.
+type upstreamState struct {
+	// Instances are the instances of the scaling groups of the upstream by the instance ID.
+	Instances map[string]*instanceState `json:"instances"`
+	// Draining are the times the servers started draining by the address of the server.
+	Draining map[string]time.Time `json:"draining,omitempty"`
+	// Owned are the addresses of the servers owned by nginx-asg-sync in the ownership mode without keyval_zone.
+	Owned []string `json:"owned,omitempty"`
+}
+
+type instanceState struct {
+	LastSeen time.Time `json:"last_seen"`
+	Address  string    `json:"address"`
+}
+
+// stateStore keeps the syncState and writes it to the file at the path, if set.
+type stateStore struct {
+	state syncState
+	path  string
+}
+
+// newStateStore creates the stateStore and loads the state from the file at the path. An empty path means the state
+// is only kept in memory.
+func newStateStore(path string) (*stateStore, error) {
+	s := newMemoryStateStore()
+	if path == "" {
+		return s, nil
+	}
+	s.path = path
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read the state file %v: %w", path, err)
+	}
+
+	if err := json.Unmarshal(data, &s.state); err != nil {
+		return nil, fmt.Errorf("couldn't parse the state file %v: %w", path, err)
+	}
+	if s.state.Upstreams == nil {
+		s.state.Upstreams = make(map[string]*upstreamState)
+	}
+	for _, st := range s.state.Upstreams {
+		if st.Instances == nil {
+			st.Instances = make(map[string]*instanceState)
+		}
+		if st.Draining == nil {
+			st.Draining = make(map[string]time.Time)
+		}
+	}
+
+	return s, nil
+}
+
+// newMemoryStateStore creates the stateStore that keeps the state only in memory.
+func newMemoryStateStore() *stateStore {
+	return &stateStore{state: syncState{Upstreams: make(map[string]*upstreamState)}}
+}
+
+// upstream returns the state of the upstream, creating it if needed.
+func (s *stateStore) upstream(upstream Upstream) *upstreamState {
+	key := ownershipKey(upstream)
+	st, ok := s.state.Upstreams[key]
+	if !ok {
+		st = &upstreamState{Instances: make(map[string]*instanceState), Draining: make(map[string]time.Time)}
+		s.state.Upstreams[key] = st
+	}
+	return st
+}
+
+// observe records the instances discovered in the scaling groups of the upstream, given as the instance IDs by the
+// addresses of the servers, and forgets the instances not seen for stateRetention.
+func (s *stateStore) observe(upstream Upstream, instanceIDs map[string]string, now time.Time) {
+	st := s.upstream(upstream)
+
+	for address, id := range instanceIDs {
+		ins, ok := st.Instances[id]
+		if !ok {
+			ins = &instanceState{}
+			st.Instances[id] = ins
+		}
+		ins.Address = address
+		ins.LastSeen = now
+	}
+
+	for id, ins := range st.Instances {
+		if now.Sub(ins.LastSeen) > stateRetention {
+			delete(st.Instances, id)
+		}
+	}
+}
+
+// checkRemovals returns an error if more than max_percent of the instances of the upstream seen in the last sync cycle
+// are missing from the discovered instances, given as the instance IDs by the addresses of the servers, and were last
+// seen less than the delay of cfg ago. The upstream must not be synced then. As the instances aren't observed while
+// their removal is refused, the removal goes ahead once they have been missing for the delay, also across restarts
+// with state_path. A nil cfg disables the check.
+func (s *stateStore) checkRemovals(upstream Upstream, instanceIDs map[string]string, cfg *massRemovalConfig, now time.Time) error {
+	if cfg == nil {
+		return nil
+	}
+	st := s.upstream(upstream)
+
+	var lastCycle time.Time
+	for _, ins := range st.Instances {
+		if ins.LastSeen.After(lastCycle) {
+			lastCycle = ins.LastSeen
+		}
+	}
+	delay := cfg.Delay
+	if delay == 0 {
+		delay = defaultMassRemovalDelay
+	}
+	if lastCycle.IsZero() || now.Sub(lastCycle) >= delay {
+		return nil
+	}
+
+	discovered := make(map[string]bool, len(instanceIDs))
+	for _, id := range instanceIDs {
+		discovered[id] = true
+	}
+	var seen, missing int
+	for id, ins := range st.Instances {
+		if !ins.LastSeen.Equal(lastCycle) {
+			continue
+		}
+		seen++
+		if !discovered[id] {
+			missing++
+		}
+	}
+
+	if missing*100 > seen*cfg.MaxPercent {
+		return fmt.Errorf("%v of the %v instances seen at %v are gone, which is more than %v%% allowed by mass_removal, "+
+			"their servers won't be removed before %v", missing, seen, lastCycle.Format(time.RFC3339), cfg.MaxPercent,
+			lastCycle.Add(delay).Format(time.RFC3339))
+	}
+	return nil
+}
+
+// instanceIDs returns the IDs of the known instances of the upstream by the addresses of their servers.
+func (s *stateStore) instanceIDs(upstream Upstream) map[string]string {
+	st := s.upstream(upstream)
+
+	ids := make(map[string]string, len(st.Instances))
+	for id, ins := range st.Instances {
+		ids[ins.Address] = id
+	}
+	return ids
+}
+
+// withDrainingServers returns the servers together with the current servers of the upstream that are not among them
+// and started draining less than the drain timeout of the upstream ago. Such servers are kept in the upstream with
+// the drain parameter, so that NGINX Plus doesn't send new sessions to them. The drain start times are kept in the
+// state, so that the drain timeout isn't restarted by a restart of nginx-asg-sync.
+func (s *stateStore) withDrainingServers(upstream Upstream, servers, current []nginx.UpstreamServer, now time.Time) []nginx.UpstreamServer {
+	st := s.upstream(upstream)
+
+	desired := make(map[string]bool, len(servers))
+	for _, server := range servers {
+		desired[server.Server] = true
+	}
+	inNginx := make(map[string]bool, len(current))
+	for _, server := range current {
+		inNginx[server.Server] = true
+	}
+
+	// the servers that came back or were removed from NGINX Plus are no longer draining
+	for address := range st.Draining {
+		if desired[address] || !inNginx[address] {
+			delete(st.Draining, address)
+		}
+	}
+
+	for _, server := range current {
+		if desired[server.Server] {
+			continue
+		}
+		since, ok := st.Draining[server.Server]
+		if !ok {
+			since = now
+			st.Draining[server.Server] = since
+		}
+		if now.Sub(since) < upstream.DrainTimeout {
+			server.Drain = true
+			servers = append(servers, server)
+		}
+	}
+
+	return servers
+}
+
+// save writes the state to the file, if the path is set.
+func (s *stateStore) save() error {
+	if s.path == "" {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(s.state, "", "  ")
+	if err != nil {
+		return fmt.Errorf("couldn't marshal the state: %w", err)
+	}
+
+	return writeFileAtomic(s.path, data)
+}
+
+// writeFileAtomic writes data to a temporary file and renames it to path, so that readers never see a partial file.
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	if err != nil {
+		return fmt.Errorf("couldn't create a temporary file for %v: %w", path, err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("couldn't write %v: %w", tmp.Name(), err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("couldn't sync %v: %w", tmp.Name(), err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("couldn't close %v: %w", tmp.Name(), err)
+	}
+
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("couldn't rename %v to %v: %w", tmp.Name(), path, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	nginx "github.com/nginx/nginx-plus-go-client/v3/client"
+)
+
+func TestStateStoreSaveAndLoad(t *testing.T) {
+	t.Parallel()
+	path := filepath.Join(t.TempDir(), "state.json")
+	upstream := Upstream{Name: "backend", Kind: "http", DrainTimeout: time.Minute}
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	s, err := newStateStore(path)
+	if err != nil {
+		t.Fatalf("newStateStore() failed: %v", err)
+	}
+	s.observe(upstream, map[string]string{"10.0.0.1:80": "i-1"}, now)
+	s.withDrainingServers(upstream, nil, []nginx.UpstreamServer{{Server: "10.0.0.2:80"}}, now)
+	owners := newOwnershipStore(&ownershipConfig{}, nil, s)
+	if err = owners.Save(context.Background(), upstream, []string{"10.0.0.1:80", "10.0.0.2:80"}); err != nil {
+		t.Fatalf("Save() failed: %v", err)
+	}
+	if err = s.save(); err != nil {
+		t.Fatalf("save() failed: %v", err)
+	}
+
+	loaded, err := newStateStore(path)
+	if err != nil {
+		t.Fatalf("newStateStore() failed: %v", err)
+	}
+	if !reflect.DeepEqual(loaded.state, s.state) {
+		t.Errorf("newStateStore() loaded %+v, expected %+v", loaded.state, s.state)
+	}
+}
+
+func TestNewStateStoreWithoutFile(t *testing.T) {
+	t.Parallel()
+	s, err := newStateStore(filepath.Join(t.TempDir(), "state.json"))
+	if err != nil {
+		t.Fatalf("newStateStore() failed: %v", err)
+	}
+	if len(s.state.Upstreams) != 0 {
+		t.Errorf("newStateStore() returned the upstreams %v for a missing file", s.state.Upstreams)
+	}
+}
+
+func TestStateStoreObserve(t *testing.T) {
+	t.Parallel()
+	s := newMemoryStateStore()
+	upstream := Upstream{Name: "backend", Kind: "http"}
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	s.observe(upstream, map[string]string{"10.0.0.1:80": "i-1", "10.0.0.2:80": "i-2"}, start)
+	s.observe(upstream, map[string]string{"10.0.0.1:80": "i-1"}, start.Add(time.Hour))
+
+	if ins := s.upstream(upstream).Instances["i-1"]; !ins.LastSeen.Equal(start.Add(time.Hour)) {
+		t.Errorf("observe() recorded last_seen %v, expected %v", ins.LastSeen, start.Add(time.Hour))
+	}
+
+	s.observe(upstream, map[string]string{"10.0.0.1:80": "i-1"}, start.Add(stateRetention+time.Minute))
+	want := map[string]string{"10.0.0.1:80": "i-1"}
+	if got := s.instanceIDs(upstream); !reflect.DeepEqual(got, want) {
+		t.Errorf("instanceIDs() returned %v after the retention, expected %v", got, want)
+	}
+}
+
+func TestValidateMassRemovalConfig(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		msg     string
+		cfg     massRemovalConfig
+		wantErr bool
+	}{
+		{cfg: massRemovalConfig{MaxPercent: 50}, msg: "default delay"},
+		{cfg: massRemovalConfig{MaxPercent: 30, Delay: time.Minute}, msg: "delay"},
+		{cfg: massRemovalConfig{}, msg: "no max_percent", wantErr: true},
+		{cfg: massRemovalConfig{MaxPercent: 100}, msg: "max_percent of 100", wantErr: true},
+		{cfg: massRemovalConfig{MaxPercent: 50, Delay: -time.Minute}, msg: "negative delay", wantErr: true},
+	}
+
+	for _, test := range tests {
+		err := validateMassRemovalConfig(&test.cfg)
+		if (err != nil) != test.wantErr {
+			t.Errorf("validateMassRemovalConfig() returned %v for the config with %v", err, test.msg)
+		}
+	}
+}
+
+func TestStateStoreCheckRemovals(t *testing.T) {
+	t.Parallel()
+	s := newMemoryStateStore()
+	upstream := Upstream{Name: "backend", Kind: "http"}
+	cfg := &massRemovalConfig{MaxPercent: 50, Delay: 10 * time.Minute}
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	all := map[string]string{"10.0.0.1:80": "i-1", "10.0.0.2:80": "i-2", "10.0.0.3:80": "i-3", "10.0.0.4:80": "i-4"}
+	half := map[string]string{"10.0.0.1:80": "i-1", "10.0.0.2:80": "i-2"}
+	one := map[string]string{"10.0.0.1:80": "i-1"}
+
+	if err := s.checkRemovals(upstream, one, cfg, start); err != nil {
+		t.Errorf("checkRemovals() failed without known instances: %v", err)
+	}
+	s.observe(upstream, all, start)
+
+	if err := s.checkRemovals(upstream, half, cfg, start.Add(time.Minute)); err != nil {
+		t.Errorf("checkRemovals() failed for the removal of half of the instances: %v", err)
+	}
+	if err := s.checkRemovals(upstream, one, cfg, start.Add(time.Minute)); err == nil {
+		t.Errorf("checkRemovals() allowed the removal of 3 of 4 instances before the delay")
+	}
+	if err := s.checkRemovals(upstream, one, nil, start.Add(time.Minute)); err != nil {
+		t.Errorf("checkRemovals() failed without mass_removal: %v", err)
+	}
+	if err := s.checkRemovals(upstream, one, cfg, start.Add(10*time.Minute)); err != nil {
+		t.Errorf("checkRemovals() failed after the delay: %v", err)
+	}
+
+	// only the instances seen in the last cycle count
+	s.observe(upstream, half, start.Add(10*time.Minute))
+	if err := s.checkRemovals(upstream, one, cfg, start.Add(11*time.Minute)); err != nil {
+		t.Errorf("checkRemovals() failed for the removal of 1 of the 2 instances seen in the last cycle: %v", err)
+	}
+}
+
+func TestStateStoreWithDrainingServers(t *testing.T) {
+	t.Parallel()
+	s := newMemoryStateStore()
+	upstream := Upstream{Name: "backend", Kind: "http", DrainTimeout: time.Minute}
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	desired := []nginx.UpstreamServer{{Server: "10.0.0.2:80"}}
+	current := []nginx.UpstreamServer{{Server: "10.0.0.1:80"}, {Server: "10.0.0.2:80"}}
+
+	got := s.withDrainingServers(upstream, desired, current, start)
+	want := []nginx.UpstreamServer{{Server: "10.0.0.2:80"}, {Server: "10.0.0.1:80", Drain: true}}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("withDrainingServers() returned %+v, expected %+v", got, want)
+	}
+
+	// the drain start is kept, so the server is still draining before the timeout
+	got = s.withDrainingServers(upstream, desired, want, start.Add(30*time.Second))
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("withDrainingServers() returned %+v before the timeout, expected %+v", got, want)
+	}
+
+	got = s.withDrainingServers(upstream, desired, want, start.Add(time.Minute))
+	if !reflect.DeepEqual(got, desired) {
+		t.Errorf("withDrainingServers() returned %+v after the timeout, expected %+v", got, desired)
+	}
+
+	// the server comes back and is no longer draining
+	desired = current
+	got = s.withDrainingServers(upstream, desired, want, start.Add(2*time.Minute))
+	if !reflect.DeepEqual(got, desired) {
+		t.Errorf("withDrainingServers() returned %+v for the returned server, expected %+v", got, desired)
+	}
+	if len(s.upstream(upstream).Draining) != 0 {
+		t.Errorf("withDrainingServers() kept the draining servers %v", s.upstream(upstream).Draining)
+	}
+}
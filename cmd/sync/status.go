@@ -0,0 +1,135 @@
+package main
+
+import (
+	"net"
+	"slices"
+	"sync"
+	"time"
+
+	nginx "github.com/nginx/nginx-plus-go-client/v3/client"
+)
+
+// upstreamStatus is the last-known state of an upstream, returned by the status API.
+type upstreamStatus struct {
+	LastSuccess        *time.Time `json:"last_success"`
+	LastFailure        *time.Time `json:"last_failure"`
+	Name               string     `json:"name"`
+	Kind               string     `json:"kind"`
+	ScalingGroup       string     `json:"scaling_group"`
+	BackupScalingGroup string     `json:"backup_scaling_group,omitempty"`
+	LastError          string     `json:"last_error,omitempty"`
+	DiscoveredIPs      []string   `json:"discovered_ips"`
+	Servers            []string   `json:"servers"`
+}
+
+// statusStore keeps the last-known state of every upstream. The methods of a nil statusStore do nothing.
+type statusStore struct {
+	upstreams []*upstreamStatus
+	mu        sync.Mutex
+}
+
+func newStatusStore(upstreams []Upstream) *statusStore {
+	s := &statusStore{}
+	s.setUpstreams(upstreams)
+	return s
+}
+
+// setUpstreams sets the upstreams whose state is kept, keeping the state of the upstreams that were already kept.
+func (s *statusStore) setUpstreams(upstreams []Upstream) {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	statuses := make([]*upstreamStatus, 0, len(upstreams))
+	for _, u := range upstreams {
+		i := slices.IndexFunc(s.upstreams, func(st *upstreamStatus) bool {
+			return st.Name == u.Name && st.Kind == u.Kind
+		})
+		if i >= 0 {
+			st := s.upstreams[i]
+			st.ScalingGroup = u.ScalingGroup
+			st.BackupScalingGroup = u.BackupScalingGroup
+			statuses = append(statuses, st)
+			continue
+		}
+		statuses = append(statuses, &upstreamStatus{
+			Name:               u.Name,
+			Kind:               u.Kind,
+			ScalingGroup:       u.ScalingGroup,
+			BackupScalingGroup: u.BackupScalingGroup,
+			DiscoveredIPs:      []string{},
+			Servers:            []string{},
+		})
+	}
+	s.upstreams = statuses
+}
+
+// setDiscovered records the IPs of the servers discovered in the scaling groups of the upstream.
+func (s *statusStore) setDiscovered(upstream Upstream, servers []nginx.UpstreamServer) {
+	s.update(upstream, func(st *upstreamStatus) {
+		ips := make([]string, 0, len(servers))
+		for _, server := range servers {
+			host, _, err := net.SplitHostPort(server.Server)
+			if err != nil {
+				host = server.Server
+			}
+			ips = append(ips, host)
+		}
+		st.DiscoveredIPs = ips
+	})
+}
+
+// setServers records the servers pushed to NGINX Plus for the upstream.
+func (s *statusStore) setServers(upstream Upstream, servers []nginx.UpstreamServer) {
+	s.update(upstream, func(st *upstreamStatus) {
+		st.Servers = getUpstreamServerAddresses(servers)
+	})
+}
+
+// setResult records the result of a sync of the upstream.
+func (s *statusStore) setResult(upstream Upstream, err error) {
+	now := time.Now().UTC()
+	s.update(upstream, func(st *upstreamStatus) {
+		if err != nil {
+			st.LastFailure = &now
+			st.LastError = err.Error()
+			return
+		}
+		st.LastSuccess = &now
+		st.LastError = ""
+	})
+}
+
+func (s *statusStore) update(upstream Upstream, fn func(st *upstreamStatus)) {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, st := range s.upstreams {
+		if st.Name == upstream.Name && st.Kind == upstream.Kind {
+			fn(st)
+			return
+		}
+	}
+}
+
+// snapshot returns a copy of the state of the upstreams.
+func (s *statusStore) snapshot() []upstreamStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	statuses := make([]upstreamStatus, 0, len(s.upstreams))
+	for _, st := range s.upstreams {
+		c := *st
+		c.DiscoveredIPs = slices.Clone(st.DiscoveredIPs)
+		c.Servers = slices.Clone(st.Servers)
+		statuses = append(statuses, c)
+	}
+	return statuses
+}
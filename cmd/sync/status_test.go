@@ -0,0 +1,63 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	nginx "github.com/nginx/nginx-plus-go-client/v3/client"
+)
+
+func TestStatusStore(t *testing.T) {
+	t.Parallel()
+	upstream := Upstream{Name: "backend", Kind: "http", ScalingGroup: "group"}
+	s := newStatusStore([]Upstream{upstream, {Name: "backend", Kind: "stream", ScalingGroup: "group"}})
+
+	s.setDiscovered(upstream, []nginx.UpstreamServer{{Server: "10.0.0.1:80"}, {Server: "10.0.0.2:80"}})
+	s.setServers(upstream, []nginx.UpstreamServer{{Server: "10.0.0.1:80"}})
+	s.setResult(upstream, errors.New("NGINX Plus is unavailable"))
+
+	statuses := s.snapshot()
+	if len(statuses) != 2 {
+		t.Fatalf("snapshot() returned %d upstreams, expected 2", len(statuses))
+	}
+	st := statuses[0]
+	if !reflect.DeepEqual(st.DiscoveredIPs, []string{"10.0.0.1", "10.0.0.2"}) || !reflect.DeepEqual(st.Servers, []string{"10.0.0.1:80"}) {
+		t.Errorf("snapshot() returned the discovered IPs %v and the servers %v", st.DiscoveredIPs, st.Servers)
+	}
+	if st.LastFailure == nil || st.LastSuccess != nil || st.LastError != "NGINX Plus is unavailable" {
+		t.Errorf("snapshot() returned %+v after a failure", st)
+	}
+	if statuses[1].LastFailure != nil {
+		t.Errorf("snapshot() returned a failure for the stream upstream with the same name")
+	}
+
+	s.setResult(upstream, nil)
+	st = s.snapshot()[0]
+	if st.LastSuccess == nil || st.LastFailure == nil || st.LastError != "" {
+		t.Errorf("snapshot() returned %+v after a success", st)
+	}
+
+	var nilStore *statusStore
+	nilStore.setResult(upstream, nil)
+}
+
+func TestStatusStoreSetUpstreams(t *testing.T) {
+	t.Parallel()
+	upstream := Upstream{Name: "backend", Kind: "http", ScalingGroup: "group"}
+	s := newStatusStore([]Upstream{upstream, {Name: "api", Kind: "http", ScalingGroup: "api-group"}})
+	s.setResult(upstream, nil)
+
+	s.setUpstreams([]Upstream{upstream, {Name: "discovered", Kind: "stream", ScalingGroup: "discovered-group"}})
+
+	statuses := s.snapshot()
+	if len(statuses) != 2 || statuses[0].Name != "backend" || statuses[1].Name != "discovered" {
+		t.Fatalf("snapshot() returned %+v after the upstreams changed", statuses)
+	}
+	if statuses[0].LastSuccess == nil {
+		t.Error("setUpstreams() didn't keep the state of the kept upstream")
+	}
+	if statuses[1].LastSuccess != nil || statuses[1].DiscoveredIPs == nil {
+		t.Errorf("setUpstreams() added the upstream with the state %+v", statuses[1])
+	}
+}
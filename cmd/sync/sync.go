@@ -0,0 +1,448 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/netip"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	nginx "github.com/nginx/nginx-plus-go-client/v3/client"
+)
+
+// syncer keeps the servers of the NGINX Plus upstreams in sync with the instances of the scaling groups.
+type syncer struct {
+	cloudProvider CloudProvider
+	upstreams     *upstreamList
+	updaters      map[string]upstreamUpdater
+	owners        ownershipStore
+	notifier      changeNotifier
+	planOutput    io.Writer
+	retry         *retryPolicy
+	status        *statusStore
+	state         *stateStore
+	prober        *healthProber
+	unhealthy     *unhealthyTracker
+	discovery     *discoveryCache
+	leader        *leadership
+	massRemoval   *massRemovalConfig
+	localZone     string
+	dryRun        bool
+}
+
+// newSyncer creates the syncer of the upstreams with the state. A nil state means the state is only kept in memory.
+// The planned changes of the dry-run mode are written to stdout.
+func newSyncer(cloudProvider CloudProvider, updaters map[string]upstreamUpdater, state *stateStore) *syncer {
+	if state == nil {
+		state = newMemoryStateStore()
+	}
+	return &syncer{cloudProvider: cloudProvider, updaters: updaters, state: state, planOutput: os.Stdout}
+}
+
+// saveState writes the state to the state file. It is called once at the end of a sync cycle, so that the file is
+// written once for all the upstreams. The state isn't written in the dry-run mode.
+func (s *syncer) saveState() {
+	if s.dryRun {
+		return
+	}
+	if err := s.state.save(); err != nil {
+		slog.Error("Couldn't save the state", "error", err)
+	}
+}
+
+// sync syncs the upstream, and logs and records the result.
+func (s *syncer) sync(ctx context.Context, upstream Upstream) error {
+	ctx, span := startSpan(ctx, "sync upstream", "upstream", upstream.Name, "kind", upstream.Kind, "scaling_group", upstream.ScalingGroup)
+	err := s.syncUpstream(ctx, upstream)
+	endSpan(span, err)
+	if err != nil {
+		slog.Error("Couldn't sync upstream", "upstream", upstream.Name, "kind", upstream.Kind, "scaling_group", upstream.ScalingGroup, "error", err)
+	}
+	s.status.setResult(upstream, err)
+	return err
+}
+
+// syncUpstream updates the servers of the upstream in NGINX Plus with the instances of its scaling group.
+func (s *syncer) syncUpstream(ctx context.Context, upstream Upstream) error {
+	now := time.Now()
+	discovered, instanceIDs, excluded, err := s.discoverServers(ctx, upstream, now)
+	if err != nil {
+		return err
+	}
+	s.status.setDiscovered(upstream, discovered)
+	if err := s.state.checkRemovals(upstream, instanceIDs, s.massRemoval, now); err != nil {
+		return err
+	}
+	s.state.observe(upstream, instanceIDs, now)
+
+	// reasons are why the servers of the known instances aren't discovered, by the addresses of the servers
+	reasons := make(map[string]string)
+	for address, id := range s.state.instanceIDs(upstream) {
+		if reason, ok := excluded[id]; ok {
+			reasons[address] = reason
+		}
+	}
+
+	if upstream.HealthProbe != nil {
+		if s.prober == nil {
+			s.prober = newHealthProber()
+		}
+		admitted := s.prober.admit(ctx, upstream, discovered)
+		for _, server := range discovered {
+			if !slices.ContainsFunc(admitted, func(a nginx.UpstreamServer) bool { return a.Server == server.Server }) {
+				reasons[server.Server] = reasonProbeFailed
+			}
+		}
+		discovered = admitted
+	}
+
+	updater, ok := s.updaters[upstream.Kind]
+	if !ok {
+		return fmt.Errorf("unsupported upstream kind %v", upstream.Kind)
+	}
+
+	servers := discovered
+	var current []nginx.UpstreamServer
+	err = s.retry.do(ctx, "get the servers of "+upstream.Name, func() error {
+		spanCtx, span := startSpan(ctx, "GetServers", "upstream", upstream.Name, "kind", upstream.Kind)
+		current, err = updater.GetServers(spanCtx, upstream.Name)
+		endSpan(span, err)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	var owned []string
+	if s.owners != nil {
+		owned, err = s.owners.Load(ctx, upstream)
+		if err != nil {
+			return fmt.Errorf("couldn't load the owned servers: %w", err)
+		}
+		servers = withForeignServers(discovered, current, owned)
+	}
+
+	if upstream.DrainTimeout > 0 {
+		servers = s.state.withDrainingServers(upstream, servers, current, now)
+	}
+	servers = normalizeServers(servers)
+
+	// the changes are computed locally, so that NGINX Plus is only called when there is something to change
+	toAdd, toRemove, toUpdate, err := planServerUpdates(servers, current)
+	if err != nil {
+		return fmt.Errorf("couldn't plan the changes to the servers: %w", err)
+	}
+	if s.dryRun {
+		if err := writePlan(s.planOutput, upstream, current, toAdd, toRemove, toUpdate); err != nil {
+			return fmt.Errorf("couldn't write the planned changes: %w", err)
+		}
+		return nil
+	}
+
+	var added, removed, updated []nginx.UpstreamServer
+	if len(toAdd) > 0 || len(toRemove) > 0 || len(toUpdate) > 0 {
+		// a failed attempt can apply some of the changes, which the next attempt doesn't see as changes, so the
+		// changes of all the attempts are collected
+		err = s.retry.do(ctx, "update the servers of "+upstream.Name, func() error {
+			// the lease can expire during a long sync cycle or the waits between the attempts
+			if err := s.leader.ensure(ctx); err != nil {
+				return err
+			}
+			spanCtx, span := startSpan(ctx, "UpdateServers", "upstream", upstream.Name, "kind", upstream.Kind, "servers", len(servers))
+			a, r, u, err := updater.UpdateServers(spanCtx, upstream.Name, servers)
+			endSpan(span, err)
+			added, removed, updated = append(added, a...), append(removed, r...), append(updated, u...)
+			return err
+		})
+		added, removed, updated = normalizeServers(added), normalizeServers(removed), normalizeServers(updated)
+	}
+
+	if s.owners != nil {
+		nowOwned := ownedServersAfterUpdate(discovered, current, owned, added, removed)
+		if saveErr := s.owners.Save(ctx, upstream, nowOwned); saveErr != nil {
+			slog.Error("Couldn't save the owned servers", "upstream", upstream.Name, "kind", upstream.Kind, "error", saveErr)
+		}
+	}
+
+	// the changes that were applied are logged and sent even if the update failed in the end
+	if len(added) > 0 || len(removed) > 0 || len(updated) > 0 {
+		slog.Info(fmt.Sprintf("Updated %v servers", kindLabel(upstream.Kind)),
+			"upstream", upstream.Name, "kind", upstream.Kind, "scaling_group", upstream.ScalingGroup,
+			"added", getUpstreamServerAddresses(added), "removed", getUpstreamServerAddresses(removed), "updated", getUpstreamServerAddresses(updated))
+	}
+
+	if s.notifier != nil && (len(added) > 0 || len(removed) > 0 || len(updated) > 0) {
+		s.notifier.Notify(newChangeEvent(upstream, s.state.instanceIDs(upstream), reasons, added, removed, updated))
+	}
+
+	if err != nil {
+		return err
+	}
+	s.status.setServers(upstream, servers)
+
+	if upstream.UnhealthyFeedback != nil {
+		s.reportUnhealthyInstances(ctx, upstream, updater, instanceIDs, now)
+	}
+
+	return nil
+}
+
+// reportUnhealthyInstances reports the instances whose servers are unhealthy in NGINX Plus to the scaling group.
+// Failures are only logged, since they don't affect the servers of the upstream.
+func (s *syncer) reportUnhealthyInstances(ctx context.Context, upstream Upstream, updater upstreamUpdater, instanceIDs map[string]string, now time.Time) {
+	if s.unhealthy == nil {
+		s.unhealthy = newUnhealthyTracker()
+	}
+
+	spanCtx, span := startSpan(ctx, "GetPeerStates", "upstream", upstream.Name, "kind", upstream.Kind)
+	states, err := updater.GetPeerStates(spanCtx, upstream.Name)
+	endSpan(span, err)
+	if err != nil {
+		slog.Error("Couldn't get the states of the servers", "upstream", upstream.Name, "kind", upstream.Kind, "error", err)
+		return
+	}
+
+	s.unhealthy.update(ctx, upstream, states, instanceIDs, now, s.cloudProvider.ReportUnhealthyInstance)
+}
+
+// discoverServers returns the servers for the instances of the scaling group of the upstream
+// and, if configured, the backup servers for the instances of its backup scaling group,
+// together with the IDs of the instances by the addresses of the servers and the reasons why the other instances of
+// the groups are left out, by the instance IDs. The instances are left out by the instance_tags, min_instance_age and
+// address_selection of the upstream.
+func (s *syncer) discoverServers(ctx context.Context, upstream Upstream, now time.Time) ([]nginx.UpstreamServer, map[string]string, map[string]string, error) {
+	instances, err := s.getInstances(ctx, upstream.ScalingGroup)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("couldn't get the instances of %v: %w", upstream.ScalingGroup, err)
+	}
+	excluded := make(map[string]string)
+	instances = selectInstances(upstream, instances, now, excluded)
+
+	placeByZone := zonePlacement(upstream.ZoneAwareness, s.localZone, instances)
+
+	servers := make([]nginx.UpstreamServer, 0, len(instances))
+	instanceIDs := make(map[string]string, len(instances))
+	for _, ins := range instances {
+		ip, _ := selectAddress(upstream.AddressSelection, ins)
+		server := newUpstreamServer(upstream, ip)
+		if _, ok := instanceIDs[server.Server]; ok {
+			continue
+		}
+		if placeByZone {
+			placeServerByZone(&server, upstream.ZoneAwareness, ins.Zone == s.localZone)
+		}
+		instanceIDs[server.Server] = ins.ID
+		servers = append(servers, server)
+	}
+
+	if upstream.BackupScalingGroup == "" {
+		return normalizeServers(servers), instanceIDs, excluded, nil
+	}
+
+	backupInstances, err := s.getInstances(ctx, upstream.BackupScalingGroup)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("couldn't get the instances of the backup group %v: %w", upstream.BackupScalingGroup, err)
+	}
+	backupInstances = selectInstances(upstream, backupInstances, now, excluded)
+
+	for _, ins := range backupInstances {
+		ip, _ := selectAddress(upstream.AddressSelection, ins)
+		server := newUpstreamServer(upstream, ip)
+		if _, ok := instanceIDs[server.Server]; ok {
+			continue
+		}
+		backup := true
+		server.Backup = &backup
+		instanceIDs[server.Server] = ins.ID
+		servers = append(servers, server)
+	}
+
+	return normalizeServers(servers), instanceIDs, excluded, nil
+}
+
+// selectInstances returns the instances that pass the instance_tags, min_instance_age and address_selection of the
+// upstream, and records the reasons why the other instances are left out in excluded, by the instance IDs.
+func selectInstances(upstream Upstream, instances []Instance, now time.Time, excluded map[string]string) []Instance {
+	filters := []struct {
+		apply  func(instances []Instance) []Instance
+		reason string
+	}{
+		{apply: func(instances []Instance) []Instance { return withInstanceTags(upstream, instances) }, reason: reasonInstanceTags},
+		{apply: func(instances []Instance) []Instance { return withoutYoungInstances(upstream, instances, now) }, reason: reasonInstanceTooYoung},
+		{apply: func(instances []Instance) []Instance { return withSelectedAddress(upstream, instances) }, reason: reasonNoAddress},
+	}
+
+	for _, filter := range filters {
+		selected := filter.apply(instances)
+		kept := make(map[string]bool, len(selected))
+		for _, ins := range selected {
+			kept[ins.ID] = true
+		}
+		for _, ins := range instances {
+			if !kept[ins.ID] {
+				excluded[ins.ID] = filter.reason
+			}
+		}
+		instances = selected
+	}
+	return instances
+}
+
+// normalizeServers sorts the servers by their addresses, with the IP addresses in numeric order, and removes the
+// duplicates, keeping the first server with an address. It makes the order of the servers, the logs and the events
+// independent of the order of the cloud provider and NGINX Plus APIs.
+func normalizeServers(servers []nginx.UpstreamServer) []nginx.UpstreamServer {
+	if len(servers) == 0 {
+		return servers
+	}
+
+	seen := make(map[string]bool, len(servers))
+	result := make([]nginx.UpstreamServer, 0, len(servers))
+	for _, server := range servers {
+		if seen[server.Server] {
+			continue
+		}
+		seen[server.Server] = true
+		result = append(result, server)
+	}
+
+	slices.SortStableFunc(result, func(a, b nginx.UpstreamServer) int {
+		return compareAddresses(a.Server, b.Server)
+	})
+	return result
+}
+
+// compareAddresses compares the addresses of two servers. The addresses that aren't IP:port are compared as strings
+// after the IP addresses.
+func compareAddresses(a, b string) int {
+	addrA, errA := netip.ParseAddrPort(a)
+	addrB, errB := netip.ParseAddrPort(b)
+	switch {
+	case errA == nil && errB == nil:
+		return addrA.Compare(addrB)
+	case errA == nil:
+		return -1
+	case errB == nil:
+		return 1
+	default:
+		return strings.Compare(a, b)
+	}
+}
+
+// withoutYoungInstances returns the instances that were launched at least the min_instance_age of the upstream ago.
+// The instances with an unknown launch time are kept.
+func withoutYoungInstances(upstream Upstream, instances []Instance, now time.Time) []Instance {
+	if upstream.MinInstanceAge <= 0 {
+		return instances
+	}
+
+	result := make([]Instance, 0, len(instances))
+	for _, ins := range instances {
+		if !ins.LaunchTime.IsZero() && now.Sub(ins.LaunchTime) < upstream.MinInstanceAge {
+			slog.Debug("Skipping an instance younger than min_instance_age", "upstream", upstream.Name, "kind", upstream.Kind,
+				"instance_id", ins.ID, "launch_time", ins.LaunchTime)
+			continue
+		}
+		result = append(result, ins)
+	}
+	return result
+}
+
+// getInstances returns the instances of the scaling group, retrying the failed calls to the cloud provider. The
+// instances are taken from the discovery cache, if possible.
+func (s *syncer) getInstances(ctx context.Context, group string) ([]Instance, error) {
+	now := time.Now()
+	if instances, ok := s.discovery.get(group, now); ok {
+		slog.Debug("Using the cached instances of the scaling group", "scaling_group", group)
+		return instances, nil
+	}
+
+	if provider, ok := s.cloudProvider.(batchCloudProvider); ok {
+		return s.getBatchInstances(ctx, provider, group, now)
+	}
+
+	var instances []Instance
+	ctx, span := startSpan(ctx, "GetInstancesForScalingGroup", "scaling_group", group)
+	err := s.retry.do(ctx, "get the instances of "+group, func() error {
+		var err error
+		instances, err = s.cloudProvider.GetInstancesForScalingGroup(ctx, group)
+		return err
+	})
+	endSpan(span, err)
+	if err != nil {
+		return nil, err
+	}
+
+	s.discovery.set(group, instances, now)
+	return instances, nil
+}
+
+// getBatchInstances returns the instances of the scaling group using a cloud provider that gets the instances of
+// several scaling groups at once. With the discovery cache, the instances of all the scaling groups of the upstreams
+// that aren't cached are got together and cached, so that a sync cycle needs a single discovery.
+func (s *syncer) getBatchInstances(ctx context.Context, provider batchCloudProvider, group string, now time.Time) ([]Instance, error) {
+	groups := []string{group}
+	if s.discovery != nil {
+		for _, upstream := range s.allUpstreams() {
+			for _, g := range []string{upstream.ScalingGroup, upstream.BackupScalingGroup} {
+				if _, ok := s.discovery.get(g, now); g != "" && !ok {
+					groups = append(groups, g)
+				}
+			}
+		}
+		groups = slices.Compact(slices.Sorted(slices.Values(groups)))
+	}
+
+	var instances map[string][]Instance
+	ctx, span := startSpan(ctx, "GetInstancesForScalingGroups", "scaling_groups", len(groups))
+	err := s.retry.do(ctx, "get the instances of "+strings.Join(groups, ", "), func() error {
+		var err error
+		instances, err = provider.GetInstancesForScalingGroups(ctx, groups)
+		return err
+	})
+	endSpan(span, err)
+	if err != nil {
+		return nil, err
+	}
+
+	for g, ins := range instances {
+		s.discovery.set(g, ins, now)
+	}
+
+	result, ok := instances[group]
+	if !ok {
+		return nil, fmt.Errorf("scaling group %v doesn't exist", group)
+	}
+	return result, nil
+}
+
+// allUpstreams returns the upstreams that are synced, including the discovered ones.
+func (s *syncer) allUpstreams() []Upstream {
+	if s.upstreams != nil {
+		return s.upstreams.get()
+	}
+	return s.cloudProvider.GetUpstreams()
+}
+
+// newUpstreamServer returns the server for the instance with the IP address, using the parameters of the upstream.
+func newUpstreamServer(upstream Upstream, ip string) nginx.UpstreamServer {
+	return nginx.UpstreamServer{
+		Server:      fmt.Sprintf("%v:%v", ip, upstream.Port),
+		MaxConns:    upstream.MaxConns,
+		MaxFails:    upstream.MaxFails,
+		FailTimeout: upstream.FailTimeout,
+		SlowStart:   upstream.SlowStart,
+	}
+}
+
+// kindLabel returns the name of the upstream kind used in log messages.
+func kindLabel(kind string) string {
+	if kind == "http" {
+		return "HTTP"
+	}
+	return "Stream"
+}
@@ -0,0 +1,730 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"maps"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	nginx "github.com/nginx/nginx-plus-go-client/v3/client"
+)
+
+type fakeCloudProvider struct {
+	ips         map[string][]string
+	zones       map[string]string
+	launchTimes map[string]time.Time
+	upstreams   []Upstream
+	reported    []string
+	calls       int
+}
+
+func (p *fakeCloudProvider) GetInstancesForScalingGroup(_ context.Context, name string) ([]Instance, error) {
+	p.calls++
+	ips, ok := p.ips[name]
+	if !ok {
+		return nil, errors.New("scaling group doesn't exist")
+	}
+	instances := make([]Instance, 0, len(ips))
+	for _, ip := range ips {
+		instances = append(instances, Instance{ID: "i-" + ip, PrivateIP: ip, Zone: p.zones[ip], LaunchTime: p.launchTimes[ip]})
+	}
+	return instances, nil
+}
+
+func (p *fakeCloudProvider) CheckIfScalingGroupExists(_ context.Context, name string) (bool, error) {
+	_, ok := p.ips[name]
+	return ok, nil
+}
+
+func (p *fakeCloudProvider) GetUpstreams() []Upstream {
+	return p.upstreams
+}
+
+func (p *fakeCloudProvider) GetLocalZone(context.Context) (string, error) {
+	return "zone-a", nil
+}
+
+func (p *fakeCloudProvider) ReportUnhealthyInstance(_ context.Context, group string, id string) error {
+	if !slices.Contains(p.ips[group], strings.TrimPrefix(id, "i-")) {
+		return errInstanceNotFound
+	}
+	p.reported = append(p.reported, id)
+	return nil
+}
+
+// fakeUpstreamUpdater keeps the servers of upstreams in memory and updates them like the NGINX Plus API client does.
+type fakeUpstreamUpdater struct {
+	servers map[string][]nginx.UpstreamServer
+	states  map[string]map[string]string
+	updates int
+}
+
+func (u *fakeUpstreamUpdater) GetServers(_ context.Context, upstream string) ([]nginx.UpstreamServer, error) {
+	return slices.Clone(u.servers[upstream]), nil
+}
+
+func (u *fakeUpstreamUpdater) GetPeerStates(_ context.Context, upstream string) (map[string]string, error) {
+	return u.states[upstream], nil
+}
+
+func (u *fakeUpstreamUpdater) UpdateServers(_ context.Context, upstream string, servers []nginx.UpstreamServer) (added, removed, updated []nginx.UpstreamServer, err error) {
+	u.updates++
+	current := u.servers[upstream]
+	for _, s := range servers {
+		i := slices.IndexFunc(current, func(c nginx.UpstreamServer) bool { return c.Server == s.Server })
+		if i < 0 {
+			added = append(added, s)
+		} else if !reflect.DeepEqual(current[i], s) {
+			updated = append(updated, s)
+		}
+	}
+	for _, c := range current {
+		if !slices.ContainsFunc(servers, func(s nginx.UpstreamServer) bool { return c.Server == s.Server }) {
+			removed = append(removed, c)
+		}
+	}
+	u.servers[upstream] = slices.Clone(servers)
+	return added, removed, updated, nil
+}
+
+func TestSyncUpstream(t *testing.T) {
+	t.Parallel()
+	upstream := Upstream{Name: "backend", Kind: "http", ScalingGroup: "group", Port: 80, FailTimeout: "10s", SlowStart: "0s"}
+	provider := &fakeCloudProvider{ips: map[string][]string{"group": {"10.0.0.1", "10.0.0.2"}}}
+	updater := &fakeUpstreamUpdater{servers: map[string][]nginx.UpstreamServer{
+		"backend": {{Server: "10.0.0.3:80"}},
+	}}
+	s := newSyncer(provider, map[string]upstreamUpdater{"http": updater}, nil)
+
+	if err := s.syncUpstream(context.Background(), upstream); err != nil {
+		t.Fatalf("syncUpstream() failed: %v", err)
+	}
+
+	got := getUpstreamServerAddresses(updater.servers["backend"])
+	want := []string{"10.0.0.1:80", "10.0.0.2:80"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("syncUpstream() set the servers %v, expected %v", got, want)
+	}
+
+	if err := s.syncUpstream(context.Background(), Upstream{Name: "other", Kind: "http", ScalingGroup: "missing"}); err == nil {
+		t.Error("syncUpstream() didn't fail for a missing scaling group")
+	}
+}
+
+func TestSyncUpstreamWithBackupGroup(t *testing.T) {
+	t.Parallel()
+	upstream := Upstream{Name: "backend", Kind: "http", ScalingGroup: "group", BackupScalingGroup: "dr-group", Port: 80}
+	provider := &fakeCloudProvider{ips: map[string][]string{
+		"group":    {"10.0.0.1", "10.0.0.2"},
+		"dr-group": {"10.1.0.1", "10.0.0.2"},
+	}}
+	updater := &fakeUpstreamUpdater{servers: map[string][]nginx.UpstreamServer{}}
+	s := newSyncer(provider, map[string]upstreamUpdater{"http": updater}, nil)
+
+	if err := s.syncUpstream(context.Background(), upstream); err != nil {
+		t.Fatalf("syncUpstream() failed: %v", err)
+	}
+
+	servers := updater.servers["backend"]
+	if len(servers) != 3 {
+		t.Fatalf("syncUpstream() set the servers %+v, expected 3 servers", servers)
+	}
+	for _, server := range servers {
+		isBackup := server.Backup != nil && *server.Backup
+		if isBackup != (server.Server == "10.1.0.1:80") {
+			t.Errorf("syncUpstream() set the server %v with backup %v", server.Server, isBackup)
+		}
+	}
+
+	delete(provider.ips, "dr-group")
+	if err := s.syncUpstream(context.Background(), upstream); err == nil {
+		t.Error("syncUpstream() didn't fail when the backup group couldn't be discovered")
+	}
+	if len(updater.servers["backend"]) != 3 {
+		t.Errorf("syncUpstream() updated the servers when the backup group couldn't be discovered")
+	}
+}
+
+func TestSyncUpstreamWithZoneAwareness(t *testing.T) {
+	t.Parallel()
+	upstream := Upstream{
+		Name:          "backend",
+		Kind:          "http",
+		ScalingGroup:  "group",
+		Port:          80,
+		ZoneAwareness: &zoneAwarenessConfig{CrossZone: crossZoneBackup, MinSameZoneServers: 2},
+	}
+	provider := &fakeCloudProvider{
+		ips:   map[string][]string{"group": {"10.0.0.1", "10.0.0.2", "10.0.1.1"}},
+		zones: map[string]string{"10.0.0.1": "zone-a", "10.0.0.2": "zone-a", "10.0.1.1": "zone-b"},
+	}
+	updater := &fakeUpstreamUpdater{servers: map[string][]nginx.UpstreamServer{}}
+	s := newSyncer(provider, map[string]upstreamUpdater{"http": updater}, nil)
+	s.localZone = "zone-a"
+
+	if err := s.syncUpstream(context.Background(), upstream); err != nil {
+		t.Fatalf("syncUpstream() failed: %v", err)
+	}
+	for _, server := range updater.servers["backend"] {
+		isBackup := server.Backup != nil && *server.Backup
+		if isBackup != (server.Server == "10.0.1.1:80") {
+			t.Errorf("syncUpstream() set the server %v with backup %v", server.Server, isBackup)
+		}
+	}
+
+	// below the threshold of servers in the local zone all the servers are primary
+	provider.ips["group"] = []string{"10.0.0.1", "10.0.1.1"}
+	if err := s.syncUpstream(context.Background(), upstream); err != nil {
+		t.Fatalf("syncUpstream() failed: %v", err)
+	}
+	for _, server := range updater.servers["backend"] {
+		if server.Backup != nil && *server.Backup {
+			t.Errorf("syncUpstream() set the server %v as backup below the threshold", server.Server)
+		}
+	}
+}
+
+func TestSyncUpstreamWithOwnership(t *testing.T) {
+	t.Parallel()
+	upstream := Upstream{Name: "backend", Kind: "stream", ScalingGroup: "group", Port: 53}
+	provider := &fakeCloudProvider{ips: map[string][]string{"group": {"10.0.0.1", "10.0.0.2"}}}
+	updater := &fakeUpstreamUpdater{servers: map[string][]nginx.UpstreamServer{
+		"backend": {{Server: "10.0.0.1:53"}, {Server: "192.168.0.1:53"}},
+	}}
+	s := newSyncer(provider, map[string]upstreamUpdater{"stream": updater}, nil)
+	s.owners = &stateOwnershipStore{state: s.state}
+	ctx := context.Background()
+
+	if err := s.syncUpstream(ctx, upstream); err != nil {
+		t.Fatalf("syncUpstream() failed: %v", err)
+	}
+
+	got := getUpstreamServerAddresses(updater.servers["backend"])
+	want := []string{"10.0.0.1:53", "10.0.0.2:53", "192.168.0.1:53"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("syncUpstream() set the servers %v, expected %v", got, want)
+	}
+
+	// the scaling group is scaled in and an operator adds a debugging backend
+	provider.ips["group"] = []string{"10.0.0.2"}
+	updater.servers["backend"] = append(updater.servers["backend"], nginx.UpstreamServer{Server: "192.168.0.2:53"})
+
+	if err := s.syncUpstream(ctx, upstream); err != nil {
+		t.Fatalf("syncUpstream() failed: %v", err)
+	}
+
+	got = getUpstreamServerAddresses(updater.servers["backend"])
+	want = []string{"10.0.0.2:53", "192.168.0.1:53", "192.168.0.2:53"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("syncUpstream() set the servers %v, expected %v", got, want)
+	}
+
+	owned, err := s.owners.Load(ctx, upstream)
+	if err != nil {
+		t.Fatalf("Load() failed: %v", err)
+	}
+	if !reflect.DeepEqual(owned, []string{"10.0.0.2:53"}) {
+		t.Errorf("syncUpstream() stored the owned servers %v, expected [10.0.0.2:53]", owned)
+	}
+}
+
+func TestSyncUpstreamRefusesMassRemoval(t *testing.T) {
+	t.Parallel()
+	upstream := Upstream{Name: "backend", Kind: "http", ScalingGroup: "group", Port: 80}
+	provider := &fakeCloudProvider{ips: map[string][]string{"group": {"10.0.0.1", "10.0.0.2", "10.0.0.3"}}}
+	updater := &fakeUpstreamUpdater{servers: map[string][]nginx.UpstreamServer{}}
+	s := newSyncer(provider, map[string]upstreamUpdater{"http": updater}, nil)
+	s.massRemoval = &massRemovalConfig{MaxPercent: 50}
+	ctx := context.Background()
+
+	if err := s.syncUpstream(ctx, upstream); err != nil {
+		t.Fatalf("syncUpstream() failed: %v", err)
+	}
+
+	provider.ips["group"] = nil
+	if err := s.syncUpstream(ctx, upstream); err == nil {
+		t.Error("syncUpstream() didn't fail when all the instances were gone at once")
+	}
+	if got := getUpstreamServerAddresses(updater.servers["backend"]); len(got) != 3 {
+		t.Errorf("syncUpstream() changed the servers to %v when all the instances were gone at once", got)
+	}
+}
+
+func TestSyncUpstreamDryRun(t *testing.T) {
+	t.Parallel()
+	upstream := Upstream{Name: "backend", Kind: "http", ScalingGroup: "group", Port: 80}
+	provider := &fakeCloudProvider{ips: map[string][]string{"group": {"10.0.0.1"}}}
+	updater := &fakeUpstreamUpdater{servers: map[string][]nginx.UpstreamServer{
+		"backend": {{Server: "10.0.0.3:80"}},
+	}}
+	s := newSyncer(provider, map[string]upstreamUpdater{"http": updater}, nil)
+	s.dryRun = true
+	var plan bytes.Buffer
+	s.planOutput = &plan
+
+	if err := s.syncUpstream(context.Background(), upstream); err != nil {
+		t.Fatalf("syncUpstream() failed: %v", err)
+	}
+
+	got := getUpstreamServerAddresses(updater.servers["backend"])
+	if !reflect.DeepEqual(got, []string{"10.0.0.3:80"}) {
+		t.Errorf("syncUpstream() changed the servers to %v in the dry-run mode", got)
+	}
+	want := "HTTP upstream backend (scaling group group):\n  + 10.0.0.1:80\n  - 10.0.0.3:80\n"
+	if plan.String() != want {
+		t.Errorf("syncUpstream() wrote the plan %q, expected %q", plan.String(), want)
+	}
+}
+
+type fakeChangeNotifier struct {
+	events []changeEvent
+}
+
+func (n *fakeChangeNotifier) Notify(event changeEvent) {
+	n.events = append(n.events, event)
+}
+
+func TestSyncUpstreamNotifiesChanges(t *testing.T) {
+	t.Parallel()
+	upstream := Upstream{Name: "backend", Kind: "http", ScalingGroup: "group", Port: 80}
+	provider := &fakeCloudProvider{ips: map[string][]string{"group": {"10.0.0.1"}}}
+	updater := &fakeUpstreamUpdater{servers: map[string][]nginx.UpstreamServer{}}
+	notifier := &fakeChangeNotifier{}
+	s := newSyncer(provider, map[string]upstreamUpdater{"http": updater}, nil)
+	s.notifier = notifier
+	ctx := context.Background()
+
+	if err := s.syncUpstream(ctx, upstream); err != nil {
+		t.Fatalf("syncUpstream() failed: %v", err)
+	}
+	// no changes, no event
+	if err := s.syncUpstream(ctx, upstream); err != nil {
+		t.Fatalf("syncUpstream() failed: %v", err)
+	}
+	provider.ips["group"] = []string{"10.0.0.2"}
+	if err := s.syncUpstream(ctx, upstream); err != nil {
+		t.Fatalf("syncUpstream() failed: %v", err)
+	}
+
+	if len(notifier.events) != 2 {
+		t.Fatalf("syncUpstream() sent %d events, expected 2", len(notifier.events))
+	}
+	want := []serverChange{{Action: "added", Address: "10.0.0.1:80", InstanceID: "i-10.0.0.1", Reason: reasonInstanceFound}}
+	if !reflect.DeepEqual(notifier.events[0].Changes, want) {
+		t.Errorf("syncUpstream() sent the changes %+v, expected %+v", notifier.events[0].Changes, want)
+	}
+	want = []serverChange{
+		{Action: "added", Address: "10.0.0.2:80", InstanceID: "i-10.0.0.2", Reason: reasonInstanceFound},
+		{Action: "removed", Address: "10.0.0.1:80", InstanceID: "i-10.0.0.1", Reason: reasonInstanceGone},
+	}
+	if !reflect.DeepEqual(notifier.events[1].Changes, want) {
+		t.Errorf("syncUpstream() sent the changes %+v, expected %+v", notifier.events[1].Changes, want)
+	}
+}
+
+func TestSyncUpstreamNotifiesReasons(t *testing.T) {
+	t.Parallel()
+	upstream := Upstream{Name: "backend", Kind: "http", ScalingGroup: "group", Port: 80, HealthProbe: &healthProbeConfig{Type: "tcp", UnhealthyThreshold: 1}}
+	provider := &fakeCloudProvider{ips: map[string][]string{"group": {"10.0.0.1", "10.0.0.2"}}}
+	updater := &fakeUpstreamUpdater{servers: map[string][]nginx.UpstreamServer{}}
+	notifier := &fakeChangeNotifier{}
+	s := newSyncer(provider, map[string]upstreamUpdater{"http": updater}, nil)
+	s.notifier = notifier
+	healthy := map[string]bool{"10.0.0.1:80": true, "10.0.0.2:80": true}
+	s.prober = newHealthProber()
+	s.prober.probe = func(_ context.Context, _ *healthProbeConfig, address string) error {
+		if !healthy[address] {
+			return errors.New("connection refused")
+		}
+		return nil
+	}
+	ctx := context.Background()
+
+	if err := s.syncUpstream(ctx, upstream); err != nil {
+		t.Fatalf("syncUpstream() failed: %v", err)
+	}
+	healthy["10.0.0.1:80"] = false
+	if err := s.syncUpstream(ctx, upstream); err != nil {
+		t.Fatalf("syncUpstream() failed: %v", err)
+	}
+
+	if len(notifier.events) != 2 {
+		t.Fatalf("syncUpstream() sent %d events, expected 2", len(notifier.events))
+	}
+	if reason := notifier.events[0].Changes[0].Reason; reason != reasonProbePassed {
+		t.Errorf("syncUpstream() sent the reason %q for the added server, expected %q", reason, reasonProbePassed)
+	}
+	want := []serverChange{{Action: "removed", Address: "10.0.0.1:80", InstanceID: "i-10.0.0.1", Reason: reasonProbeFailed}}
+	if !reflect.DeepEqual(notifier.events[1].Changes, want) {
+		t.Errorf("syncUpstream() sent the changes %+v, expected %+v", notifier.events[1].Changes, want)
+	}
+}
+
+func TestSelectInstances(t *testing.T) {
+	t.Parallel()
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	upstream := Upstream{
+		InstanceTags:   &instanceTagsConfig{Exclude: map[string]string{"maintenance": "true"}},
+		MinInstanceAge: time.Minute,
+	}
+	instances := []Instance{
+		{ID: "i-1", PrivateIP: "10.0.0.1"},
+		{ID: "i-2", PrivateIP: "10.0.0.2", Tags: map[string]string{"maintenance": "true"}},
+		{ID: "i-3", PrivateIP: "10.0.0.3", LaunchTime: now.Add(-time.Second)},
+	}
+
+	excluded := make(map[string]string)
+	selected := selectInstances(upstream, instances, now, excluded)
+
+	if len(selected) != 1 || selected[0].ID != "i-1" {
+		t.Errorf("selectInstances() returned %+v, expected the instance i-1", selected)
+	}
+	want := map[string]string{"i-2": reasonInstanceTags, "i-3": reasonInstanceTooYoung}
+	if !reflect.DeepEqual(excluded, want) {
+		t.Errorf("selectInstances() excluded %v, expected %v", excluded, want)
+	}
+}
+
+func TestSyncUpstreamWithDrain(t *testing.T) {
+	t.Parallel()
+	upstream := Upstream{Name: "backend", Kind: "http", ScalingGroup: "group", Port: 80, DrainTimeout: time.Hour}
+	provider := &fakeCloudProvider{ips: map[string][]string{"group": {"10.0.0.1", "10.0.0.2"}}}
+	updater := &fakeUpstreamUpdater{servers: map[string][]nginx.UpstreamServer{}}
+	s := newSyncer(provider, map[string]upstreamUpdater{"http": updater}, nil)
+	ctx := context.Background()
+
+	if err := s.syncUpstream(ctx, upstream); err != nil {
+		t.Fatalf("syncUpstream() failed: %v", err)
+	}
+
+	provider.ips["group"] = []string{"10.0.0.2"}
+	if err := s.syncUpstream(ctx, upstream); err != nil {
+		t.Fatalf("syncUpstream() failed: %v", err)
+	}
+
+	got := getUpstreamServerAddresses(updater.servers["backend"])
+	if !reflect.DeepEqual(got, []string{"10.0.0.1:80", "10.0.0.2:80"}) {
+		t.Fatalf("syncUpstream() set the servers %v, expected the removed server to be kept", got)
+	}
+	for _, server := range updater.servers["backend"] {
+		if server.Drain != (server.Server == "10.0.0.1:80") {
+			t.Errorf("syncUpstream() set drain=%v for the server %v", server.Drain, server.Server)
+		}
+	}
+}
+
+func TestSyncUpstreamWithMinInstanceAge(t *testing.T) {
+	t.Parallel()
+	upstream := Upstream{Name: "backend", Kind: "http", ScalingGroup: "group", Port: 80, MinInstanceAge: 5 * time.Minute}
+	provider := &fakeCloudProvider{
+		ips: map[string][]string{"group": {"10.0.0.1", "10.0.0.2", "10.0.0.3"}},
+		launchTimes: map[string]time.Time{
+			"10.0.0.1": time.Now().Add(-time.Hour),
+			"10.0.0.2": time.Now().Add(-time.Minute),
+		},
+	}
+	updater := &fakeUpstreamUpdater{servers: map[string][]nginx.UpstreamServer{}}
+	s := newSyncer(provider, map[string]upstreamUpdater{"http": updater}, nil)
+
+	if err := s.syncUpstream(context.Background(), upstream); err != nil {
+		t.Fatalf("syncUpstream() failed: %v", err)
+	}
+
+	// the instance with an unknown launch time is added
+	got := getUpstreamServerAddresses(updater.servers["backend"])
+	want := []string{"10.0.0.1:80", "10.0.0.3:80"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("syncUpstream() set the servers %v, expected %v", got, want)
+	}
+}
+
+func TestSyncUpstreamReportsUnhealthyInstances(t *testing.T) {
+	t.Parallel()
+	upstream := Upstream{
+		Name:               "backend",
+		Kind:               "http",
+		ScalingGroup:       "group",
+		BackupScalingGroup: "backup-group",
+		Port:               80,
+		UnhealthyFeedback:  &unhealthyFeedbackConfig{UnhealthyDuration: time.Nanosecond, MaxReports: 2},
+	}
+	provider := &fakeCloudProvider{ips: map[string][]string{"group": {"10.0.0.1", "10.0.0.2"}, "backup-group": {"10.0.1.1"}}}
+	updater := &fakeUpstreamUpdater{
+		servers: map[string][]nginx.UpstreamServer{},
+		states:  map[string]map[string]string{"backend": {"10.0.0.1:80": "unhealthy", "10.0.0.2:80": "up", "10.0.1.1:80": "unhealthy"}},
+	}
+	s := newSyncer(provider, map[string]upstreamUpdater{"http": updater}, nil)
+	ctx := context.Background()
+
+	// the servers become unhealthy in the first sync and are reported in the next ones
+	for range 3 {
+		if err := s.syncUpstream(ctx, upstream); err != nil {
+			t.Fatalf("syncUpstream() failed: %v", err)
+		}
+	}
+
+	want := []string{"i-10.0.0.1", "i-10.0.1.1"}
+	if !reflect.DeepEqual(provider.reported, want) {
+		t.Errorf("syncUpstream() reported the instances %v, expected %v", provider.reported, want)
+	}
+}
+
+func TestSyncUpstreamSkipsUpdateWithoutChanges(t *testing.T) {
+	t.Parallel()
+	upstream := Upstream{Name: "backend", Kind: "http", ScalingGroup: "group", Port: 80}
+	provider := &fakeCloudProvider{ips: map[string][]string{"group": {"10.0.0.10", "10.0.0.9", "10.0.0.10"}}}
+	updater := &fakeUpstreamUpdater{servers: map[string][]nginx.UpstreamServer{}}
+	s := newSyncer(provider, map[string]upstreamUpdater{"http": updater}, nil)
+	ctx := context.Background()
+
+	if err := s.syncUpstream(ctx, upstream); err != nil {
+		t.Fatalf("syncUpstream() failed: %v", err)
+	}
+	got := getUpstreamServerAddresses(updater.servers["backend"])
+	want := []string{"10.0.0.9:80", "10.0.0.10:80"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("syncUpstream() set the servers %v, expected %v", got, want)
+	}
+
+	// the same instances in another order
+	provider.ips["group"] = []string{"10.0.0.9", "10.0.0.10"}
+	if err := s.syncUpstream(ctx, upstream); err != nil {
+		t.Fatalf("syncUpstream() failed: %v", err)
+	}
+	if updater.updates != 1 {
+		t.Errorf("syncUpstream() updated the servers %d times, expected 1", updater.updates)
+	}
+}
+
+func TestSyncUpstreamStopsWithoutLeadership(t *testing.T) {
+	t.Parallel()
+	upstream := Upstream{Name: "backend", Kind: "http", ScalingGroup: "group", Port: 80}
+	provider := &fakeCloudProvider{ips: map[string][]string{"group": {"10.0.0.1"}}}
+	updater := &fakeUpstreamUpdater{servers: map[string][]nginx.UpstreamServer{}}
+	elector := &fakeLeaderElector{leader: true}
+	now := time.Now()
+	s := newSyncer(provider, map[string]upstreamUpdater{"http": updater}, nil)
+	s.leader = &leadership{elector: elector, now: func() time.Time { return now }, lease: 30 * time.Second}
+	ctx := context.Background()
+	s.leader.check(ctx)
+
+	// another replica took over the lease while the sync cycle lasted longer than the lease
+	elector.leader = false
+	now = now.Add(time.Minute)
+	if err := s.syncUpstream(ctx, upstream); !errors.Is(err, errNotLeader) {
+		t.Errorf("syncUpstream() returned %v after the lease expired, expected %v", err, errNotLeader)
+	}
+	if updater.updates != 0 {
+		t.Errorf("syncUpstream() updated the servers %d times without the leadership", updater.updates)
+	}
+}
+
+// failingUpstreamUpdater fails the first update after adding only the first new server, like the NGINX Plus API client
+// does when some of the changes fail.
+type failingUpstreamUpdater struct {
+	*fakeUpstreamUpdater
+	failed bool
+}
+
+func (u *failingUpstreamUpdater) UpdateServers(ctx context.Context, upstream string, servers []nginx.UpstreamServer) (added, removed, updated []nginx.UpstreamServer, err error) {
+	if u.failed {
+		return u.fakeUpstreamUpdater.UpdateServers(ctx, upstream, servers)
+	}
+	u.failed = true
+
+	current := u.servers[upstream]
+	for _, s := range servers {
+		if !slices.ContainsFunc(current, func(c nginx.UpstreamServer) bool { return c.Server == s.Server }) {
+			u.servers[upstream] = append(current, s)
+			return []nginx.UpstreamServer{s}, nil, nil, &fakeStatusError{status: http.StatusBadGateway}
+		}
+	}
+	return nil, nil, nil, &fakeStatusError{status: http.StatusBadGateway}
+}
+
+func TestSyncUpstreamKeepsChangesOfFailedAttempts(t *testing.T) {
+	t.Parallel()
+	upstream := Upstream{Name: "backend", Kind: "http", ScalingGroup: "group", Port: 80}
+	provider := &fakeCloudProvider{ips: map[string][]string{"group": {"10.0.0.1", "10.0.0.2"}}}
+	updater := &failingUpstreamUpdater{fakeUpstreamUpdater: &fakeUpstreamUpdater{servers: map[string][]nginx.UpstreamServer{}}}
+	notifier := &fakeChangeNotifier{}
+	var delays []time.Duration
+	s := newSyncer(provider, map[string]upstreamUpdater{"http": updater}, nil)
+	s.owners = &stateOwnershipStore{state: s.state}
+	s.notifier = notifier
+	s.retry = newTestRetryPolicy(3, &delays)
+	ctx := context.Background()
+
+	if err := s.syncUpstream(ctx, upstream); err != nil {
+		t.Fatalf("syncUpstream() failed: %v", err)
+	}
+	if len(delays) != 1 {
+		t.Fatalf("syncUpstream() retried the update %d times, expected once", len(delays))
+	}
+
+	want := []string{"10.0.0.1:80", "10.0.0.2:80"}
+	owned, err := s.owners.Load(ctx, upstream)
+	if err != nil {
+		t.Fatalf("Load() failed: %v", err)
+	}
+	if !reflect.DeepEqual(owned, want) {
+		t.Errorf("syncUpstream() stored the owned servers %v, expected %v", owned, want)
+	}
+
+	if len(notifier.events) != 1 {
+		t.Fatalf("syncUpstream() sent %d events, expected 1", len(notifier.events))
+	}
+	var added []string
+	for _, change := range notifier.events[0].Changes {
+		if change.Action == "added" {
+			added = append(added, change.Address)
+		}
+	}
+	if !reflect.DeepEqual(added, want) {
+		t.Errorf("syncUpstream() sent the added servers %v, expected %v", added, want)
+	}
+}
+
+func TestNormalizeServers(t *testing.T) {
+	t.Parallel()
+	servers := []nginx.UpstreamServer{
+		{Server: "backend.example.com:80"},
+		{Server: "10.0.0.10:80"},
+		{Server: "10.0.0.9:80"},
+		{Server: "10.0.0.10:80", MaxFails: new(int)},
+		{Server: "10.0.0.9:443"},
+		{Server: "[2001:db8::1]:80"},
+	}
+
+	got := normalizeServers(servers)
+	want := []nginx.UpstreamServer{
+		{Server: "10.0.0.9:80"},
+		{Server: "10.0.0.9:443"},
+		{Server: "10.0.0.10:80"},
+		{Server: "[2001:db8::1]:80"},
+		{Server: "backend.example.com:80"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("normalizeServers() returned %+v, expected %+v", got, want)
+	}
+}
+
+func TestSyncUpstreamsShareDiscovery(t *testing.T) {
+	t.Parallel()
+	httpUpstream := Upstream{Name: "backend", Kind: "http", ScalingGroup: "group", Port: 80}
+	streamUpstream := Upstream{Name: "backend", Kind: "stream", ScalingGroup: "group", Port: 53}
+	provider := &fakeCloudProvider{ips: map[string][]string{"group": {"10.0.0.1"}}}
+	s := newSyncer(provider, map[string]upstreamUpdater{
+		"http":   &fakeUpstreamUpdater{servers: map[string][]nginx.UpstreamServer{}},
+		"stream": &fakeUpstreamUpdater{servers: map[string][]nginx.UpstreamServer{}},
+	}, nil)
+	s.discovery = newDiscoveryCache(0)
+	ctx := context.Background()
+
+	for range 2 {
+		s.discovery.newCycle(false)
+		for _, upstream := range []Upstream{httpUpstream, streamUpstream} {
+			if err := s.syncUpstream(ctx, upstream); err != nil {
+				t.Fatalf("syncUpstream() failed: %v", err)
+			}
+		}
+	}
+
+	if provider.calls != 2 {
+		t.Errorf("syncUpstream() got the instances %d times in 2 cycles, expected 2", provider.calls)
+	}
+}
+
+// fakeBatchCloudProvider is a fakeCloudProvider that gets the instances of several scaling groups at once.
+type fakeBatchCloudProvider struct {
+	batches [][]string
+	fakeCloudProvider
+}
+
+func (p *fakeBatchCloudProvider) GetInstancesForScalingGroups(ctx context.Context, names []string) (map[string][]Instance, error) {
+	p.batches = append(p.batches, names)
+	result := make(map[string][]Instance, len(names))
+	for _, name := range names {
+		if instances, err := p.fakeCloudProvider.GetInstancesForScalingGroup(ctx, name); err == nil {
+			result[name] = instances
+		}
+	}
+	return result, nil
+}
+
+func TestSyncUpstreamsBatchDiscovery(t *testing.T) {
+	t.Parallel()
+	upstreams := []Upstream{
+		{Name: "backend", Kind: "http", ScalingGroup: "group-a", BackupScalingGroup: "group-b", Port: 80},
+		{Name: "api", Kind: "http", ScalingGroup: "group-c", Port: 8080},
+		{Name: "dns", Kind: "stream", ScalingGroup: "group-d", Port: 53},
+	}
+	provider := &fakeBatchCloudProvider{fakeCloudProvider: fakeCloudProvider{
+		ips:       map[string][]string{"group-a": {"10.0.0.1"}, "group-b": {"10.0.0.2"}, "group-c": {"10.0.0.3"}},
+		upstreams: upstreams,
+	}}
+	updater := &fakeUpstreamUpdater{servers: map[string][]nginx.UpstreamServer{}}
+	s := newSyncer(provider, map[string]upstreamUpdater{"http": updater, "stream": updater}, nil)
+	s.discovery = newDiscoveryCache(0)
+	ctx := context.Background()
+
+	s.discovery.newCycle(false)
+	for _, upstream := range upstreams[:2] {
+		if err := s.syncUpstream(ctx, upstream); err != nil {
+			t.Fatalf("syncUpstream() failed: %v", err)
+		}
+	}
+	if err := s.syncUpstream(ctx, upstreams[2]); err == nil {
+		t.Error("syncUpstream() succeeded for a scaling group that doesn't exist")
+	}
+
+	expected := [][]string{{"group-a", "group-b", "group-c", "group-d"}, {"group-d"}}
+	if !reflect.DeepEqual(provider.batches, expected) {
+		t.Errorf("syncUpstream() got the instances of %v, expected %v", provider.batches, expected)
+	}
+	if got := getUpstreamServerAddresses(updater.servers["api"]); !reflect.DeepEqual(got, []string{"10.0.0.3:8080"}) {
+		t.Errorf("syncUpstream() set the servers %v, expected [10.0.0.3:8080]", got)
+	}
+}
+
+func TestSyncerSavesStateOncePerCycle(t *testing.T) {
+	t.Parallel()
+	path := filepath.Join(t.TempDir(), "state.json")
+	state, err := newStateStore(path)
+	if err != nil {
+		t.Fatalf("newStateStore() failed: %v", err)
+	}
+	provider := &fakeCloudProvider{ips: map[string][]string{"group": {"10.0.0.1"}, "other-group": {"10.0.1.1"}}}
+	updater := &fakeUpstreamUpdater{servers: map[string][]nginx.UpstreamServer{}}
+	s := newSyncer(provider, map[string]upstreamUpdater{"http": updater}, state)
+	ctx := context.Background()
+
+	for _, upstream := range []Upstream{
+		{Name: "backend", Kind: "http", ScalingGroup: "group", Port: 80},
+		{Name: "other", Kind: "http", ScalingGroup: "other-group", Port: 80},
+	} {
+		if err := s.syncUpstream(ctx, upstream); err != nil {
+			t.Fatalf("syncUpstream() failed: %v", err)
+		}
+	}
+	if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("syncUpstream() wrote the state file before the end of the cycle: %v", err)
+	}
+
+	s.saveState()
+	loaded, err := newStateStore(path)
+	if err != nil {
+		t.Fatalf("newStateStore() failed: %v", err)
+	}
+	if len(loaded.state.Upstreams) != 2 {
+		t.Errorf("saveState() saved the upstreams %v, expected 2 upstreams", slices.Collect(maps.Keys(loaded.state.Upstreams)))
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+)
+
+// instanceTagsConfig filters the instances of the scaling groups of an upstream by their tags, so that a scaling group
+// can host several roles and an instance can be taken out of rotation by tagging it. An empty value matches any value
+// of the tag.
+type instanceTagsConfig struct {
+	// Include are the tags that an instance must all have.
+	Include map[string]string `yaml:"include,omitempty"`
+	// Exclude are the tags that an instance must have none of.
+	Exclude map[string]string `yaml:"exclude,omitempty"`
+}
+
+func validateInstanceTagsConfig(cfg *instanceTagsConfig, upstreamName string) error {
+	if len(cfg.Include) == 0 && len(cfg.Exclude) == 0 {
+		return fmt.Errorf(upstreamInstanceTagsErrorMsgFmt, upstreamName)
+	}
+
+	for _, tags := range []map[string]string{cfg.Include, cfg.Exclude} {
+		if _, ok := tags[""]; ok {
+			return fmt.Errorf(upstreamInstanceTagsErrorMsgFmt, upstreamName)
+		}
+	}
+
+	return nil
+}
+
+// matchesInstanceTags reports whether an instance with the tags passes the filter.
+func matchesInstanceTags(cfg *instanceTagsConfig, tags map[string]string) bool {
+	for key, value := range cfg.Include {
+		if !hasTag(tags, key, value) {
+			return false
+		}
+	}
+	for key, value := range cfg.Exclude {
+		if hasTag(tags, key, value) {
+			return false
+		}
+	}
+	return true
+}
+
+func hasTag(tags map[string]string, key, value string) bool {
+	v, ok := tags[key]
+	return ok && (value == "" || v == value)
+}
+
+// withInstanceTags returns the instances that pass the instance_tags filter of the upstream. The instances are filtered
+// after the discovery, because the instances of a scaling group are discovered once for all the upstreams.
+func withInstanceTags(upstream Upstream, instances []Instance) []Instance {
+	if upstream.InstanceTags == nil {
+		return instances
+	}
+
+	result := make([]Instance, 0, len(instances))
+	for _, ins := range instances {
+		if !matchesInstanceTags(upstream.InstanceTags, ins.Tags) {
+			slog.Debug("Skipping an instance filtered out by instance_tags", "upstream", upstream.Name, "kind", upstream.Kind,
+				"instance_id", ins.ID)
+			continue
+		}
+		result = append(result, ins)
+	}
+	return result
+}
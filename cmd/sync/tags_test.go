@@ -0,0 +1,65 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestValidateInstanceTagsConfig(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		cfg     instanceTagsConfig
+		msg     string
+		wantErr bool
+	}{
+		{cfg: instanceTagsConfig{Include: map[string]string{"role": "web"}}, msg: "include"},
+		{cfg: instanceTagsConfig{Exclude: map[string]string{"nginx-exclude": "true"}}, msg: "exclude"},
+		{cfg: instanceTagsConfig{}, msg: "no tags", wantErr: true},
+		{cfg: instanceTagsConfig{Include: map[string]string{"": "web"}}, msg: "empty key", wantErr: true},
+	}
+
+	for _, test := range tests {
+		err := validateInstanceTagsConfig(&test.cfg, "backend")
+		if (err != nil) != test.wantErr {
+			t.Errorf("validateInstanceTagsConfig() returned %v for the case of %v", err, test.msg)
+		}
+	}
+}
+
+func TestWithInstanceTags(t *testing.T) {
+	t.Parallel()
+	instances := []Instance{
+		{ID: "i-1", Tags: map[string]string{"role": "web"}},
+		{ID: "i-2", Tags: map[string]string{"role": "web", "nginx-exclude": "true"}},
+		{ID: "i-3", Tags: map[string]string{"role": "web", "nginx-exclude": "false"}},
+		{ID: "i-4", Tags: map[string]string{"role": "api"}},
+		{ID: "i-5"},
+	}
+
+	tests := []struct {
+		cfg      *instanceTagsConfig
+		msg      string
+		expected []string
+	}{
+		{cfg: nil, msg: "no filter", expected: []string{"i-1", "i-2", "i-3", "i-4", "i-5"}},
+		{cfg: &instanceTagsConfig{Include: map[string]string{"role": "web"}}, msg: "include", expected: []string{"i-1", "i-2", "i-3"}},
+		{cfg: &instanceTagsConfig{Include: map[string]string{"role": ""}}, msg: "include any value", expected: []string{"i-1", "i-2", "i-3", "i-4"}},
+		{cfg: &instanceTagsConfig{Exclude: map[string]string{"nginx-exclude": "true"}}, msg: "exclude", expected: []string{"i-1", "i-3", "i-4", "i-5"}},
+		{
+			cfg:      &instanceTagsConfig{Include: map[string]string{"role": "web"}, Exclude: map[string]string{"nginx-exclude": ""}},
+			msg:      "include and exclude any value",
+			expected: []string{"i-1"},
+		},
+	}
+
+	for _, test := range tests {
+		upstream := Upstream{Name: "backend", Kind: "http", InstanceTags: test.cfg}
+		var ids []string
+		for _, ins := range withInstanceTags(upstream, instances) {
+			ids = append(ids, ins.ID)
+		}
+		if !reflect.DeepEqual(ids, test.expected) {
+			t.Errorf("withInstanceTags() returned %v for the case of %v, expected %v", ids, test.msg, test.expected)
+		}
+	}
+}
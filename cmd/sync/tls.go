@@ -0,0 +1,209 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+)
+
+// tlsVersions are the supported values of min_version.
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// apiTLSConfig configures TLS for the connections to the NGINX Plus API.
+type apiTLSConfig struct {
+	CAFile             string `yaml:"ca_file,omitempty"`
+	CertFile           string `yaml:"cert_file,omitempty"`
+	KeyFile            string `yaml:"key_file,omitempty"`
+	ServerName         string `yaml:"server_name,omitempty"`
+	MinVersion         string `yaml:"min_version,omitempty"`
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify,omitempty"`
+}
+
+func validateAPITLSConfig(cfg *apiTLSConfig) error {
+	if (cfg.CertFile == "") != (cfg.KeyFile == "") {
+		return errors.New(apiTLSCertErrorMsg)
+	}
+
+	if _, ok := tlsVersions[cfg.MinVersion]; cfg.MinVersion != "" && !ok {
+		return fmt.Errorf(apiTLSMinVersionErrorMsgFmt, cfg.MinVersion)
+	}
+
+	return nil
+}
+
+// newTLSConfig builds the TLS config of the NGINX Plus API client for the API endpoint. The CA and the client
+// certificate files are loaded immediately, so that a wrong file is reported at startup, and reloaded when they change
+// on disk.
+func newTLSConfig(cfg *apiTLSConfig, apiEndpoint string) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		ServerName:         cfg.ServerName,
+		InsecureSkipVerify: cfg.InsecureSkipVerify, //nolint:gosec // explicitly enabled by the user
+		MinVersion:         tls.VersionTLS12,
+	}
+	if cfg.MinVersion != "" {
+		tlsConfig.MinVersion = tlsVersions[cfg.MinVersion]
+	}
+
+	files := &tlsFiles{cfg: cfg}
+	if err := files.load(); err != nil {
+		return nil, err
+	}
+
+	if cfg.CertFile != "" {
+		tlsConfig.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			cert, _ := files.current()
+			return cert, nil
+		}
+	}
+
+	if cfg.CAFile != "" && !cfg.InsecureSkipVerify {
+		// The connection state doesn't have the name of a server with an IP address, because IP addresses aren't sent
+		// in SNI, so the name is taken from the config.
+		host := cfg.ServerName
+		if host == "" {
+			u, err := url.Parse(apiEndpoint)
+			if err != nil || u.Hostname() == "" {
+				return nil, fmt.Errorf("couldn't get the host of the NGINX Plus API endpoint %q to verify its certificate", apiEndpoint)
+			}
+			host = u.Hostname()
+		}
+
+		// the server certificate is verified in VerifyConnection, so that the reloaded CA is used
+		tlsConfig.InsecureSkipVerify = true //nolint:gosec // the verification is done by verifyServerCertificate
+		tlsConfig.VerifyConnection = func(cs tls.ConnectionState) error {
+			_, roots := files.current()
+			return verifyServerCertificate(cs, roots, host)
+		}
+	}
+
+	return tlsConfig, nil
+}
+
+// verifyServerCertificate verifies the certificate chain and the host of the server, a DNS name or an IP address, like
+// crypto/tls does by default.
+func verifyServerCertificate(cs tls.ConnectionState, roots *x509.CertPool, host string) error {
+	if len(cs.PeerCertificates) == 0 {
+		return errors.New("the server didn't present a certificate")
+	}
+
+	opts := x509.VerifyOptions{
+		Roots:         roots,
+		DNSName:       host,
+		Intermediates: x509.NewCertPool(),
+	}
+	for _, cert := range cs.PeerCertificates[1:] {
+		opts.Intermediates.AddCert(cert)
+	}
+
+	_, err := cs.PeerCertificates[0].Verify(opts)
+	if err != nil {
+		return fmt.Errorf("couldn't verify the certificate of the NGINX Plus API: %w", err)
+	}
+	return nil
+}
+
+// tlsFiles keeps the CA and the client certificate loaded from the files of apiTLSConfig and reloads them when the
+// modification time of any of the files changes, for example after a certificate rotation.
+type tlsFiles struct {
+	cfg      *apiTLSConfig
+	cert     *tls.Certificate
+	roots    *x509.CertPool
+	modTimes map[string]time.Time
+	mu       sync.Mutex
+}
+
+// current returns the certificate and the CA, reloading them if the files changed. If the reload fails, the
+// previously loaded files are kept and the error is logged.
+func (f *tlsFiles) current() (*tls.Certificate, *x509.CertPool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.changed() {
+		if err := f.loadLocked(); err != nil {
+			slog.Warn("Couldn't reload the TLS files of the NGINX Plus API, using the previous ones", "error", err)
+		}
+	}
+
+	return f.cert, f.roots
+}
+
+func (f *tlsFiles) load() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.loadLocked()
+}
+
+func (f *tlsFiles) loadLocked() error {
+	modTimes, err := f.statFiles()
+	if err != nil {
+		return err
+	}
+
+	var roots *x509.CertPool
+	if f.cfg.CAFile != "" {
+		data, err := os.ReadFile(f.cfg.CAFile)
+		if err != nil {
+			return fmt.Errorf("couldn't read the CA file: %w", err)
+		}
+		roots = x509.NewCertPool()
+		if !roots.AppendCertsFromPEM(data) {
+			return fmt.Errorf("no certificates found in the CA file %v", f.cfg.CAFile)
+		}
+	}
+
+	var cert *tls.Certificate
+	if f.cfg.CertFile != "" {
+		c, err := tls.LoadX509KeyPair(f.cfg.CertFile, f.cfg.KeyFile)
+		if err != nil {
+			return fmt.Errorf("couldn't load the client certificate: %w", err)
+		}
+		cert = &c
+	}
+
+	f.roots = roots
+	f.cert = cert
+	f.modTimes = modTimes
+	return nil
+}
+
+func (f *tlsFiles) changed() bool {
+	modTimes, err := f.statFiles()
+	if err != nil {
+		// the file is being replaced, keep the loaded one
+		return false
+	}
+
+	for path, modTime := range modTimes {
+		if !modTime.Equal(f.modTimes[path]) {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *tlsFiles) statFiles() (map[string]time.Time, error) {
+	modTimes := make(map[string]time.Time, 3)
+	for _, path := range []string{f.cfg.CAFile, f.cfg.CertFile, f.cfg.KeyFile} {
+		if path == "" {
+			continue
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't access the TLS file: %w", err)
+		}
+		modTimes[path] = info.ModTime()
+	}
+	return modTimes, nil
+}
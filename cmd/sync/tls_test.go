@@ -0,0 +1,251 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestValidateAPITLSConfig(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		msg     string
+		cfg     apiTLSConfig
+		wantErr bool
+	}{
+		{cfg: apiTLSConfig{CAFile: "ca.pem", ServerName: "nginx.internal"}, msg: "CA file"},
+		{cfg: apiTLSConfig{CertFile: "cert.pem", KeyFile: "key.pem", MinVersion: "1.3"}, msg: "client certificate"},
+		{cfg: apiTLSConfig{CertFile: "cert.pem"}, msg: "cert_file without key_file", wantErr: true},
+		{cfg: apiTLSConfig{KeyFile: "key.pem"}, msg: "key_file without cert_file", wantErr: true},
+		{cfg: apiTLSConfig{MinVersion: "1.4"}, msg: "invalid min_version", wantErr: true},
+	}
+
+	for _, test := range tests {
+		err := validateAPITLSConfig(&test.cfg)
+		if (err != nil) != test.wantErr {
+			t.Errorf("validateAPITLSConfig() returned %v for the config with %v", err, test.msg)
+		}
+	}
+}
+
+func TestNewHTTPClientWithCA(t *testing.T) {
+	t.Parallel()
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	writePEM(t, caFile, "CERTIFICATE", server.Certificate().Raw)
+
+	client, err := NewHTTPClient(&commonConfig{APITLS: &apiTLSConfig{CAFile: caFile, ServerName: "example.com"}})
+	if err != nil {
+		t.Fatalf("NewHTTPClient() failed: %v", err)
+	}
+	if err := get(t, client, server.URL); err != nil {
+		t.Errorf("request to the server with the trusted certificate failed: %v", err)
+	}
+
+	client, err = NewHTTPClient(&commonConfig{APITLS: &apiTLSConfig{CAFile: caFile, ServerName: "other.com"}})
+	if err != nil {
+		t.Fatalf("NewHTTPClient() failed: %v", err)
+	}
+	if err := get(t, client, server.URL); err == nil {
+		t.Error("request to the server with a certificate for another name didn't fail")
+	}
+
+	if _, err := NewHTTPClient(&commonConfig{APITLS: &apiTLSConfig{CAFile: filepath.Join(t.TempDir(), "missing.pem")}}); err == nil {
+		t.Error("NewHTTPClient() didn't fail for a missing CA file")
+	}
+}
+
+func TestNewHTTPClientWithCAAndIPEndpoint(t *testing.T) {
+	t.Parallel()
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	ca, caKey := writeCA(t, caFile)
+
+	tests := []struct {
+		cert    *x509.Certificate
+		msg     string
+		wantErr bool
+	}{
+		{cert: &x509.Certificate{IPAddresses: []net.IP{net.IPv4(127, 0, 0, 1)}}, msg: "the IP address of the endpoint"},
+		{cert: &x509.Certificate{DNSNames: []string{"nginx.internal"}}, msg: "another host", wantErr: true},
+	}
+
+	for _, test := range tests {
+		server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			w.WriteHeader(http.StatusNoContent)
+		}))
+		server.TLS = &tls.Config{Certificates: []tls.Certificate{newServerCertificate(t, test.cert, ca, caKey)}, MinVersion: tls.VersionTLS12}
+		server.StartTLS()
+
+		client, err := NewHTTPClient(&commonConfig{APIEndpoint: server.URL + "/api", APITLS: &apiTLSConfig{CAFile: caFile}})
+		if err != nil {
+			t.Fatalf("NewHTTPClient() failed: %v", err)
+		}
+		if err := get(t, client, server.URL); (err != nil) != test.wantErr {
+			t.Errorf("request to the IP endpoint with a certificate for %v returned %v", test.msg, err)
+		}
+		server.Close()
+	}
+}
+
+func TestNewHTTPClientReloadsClientCertificate(t *testing.T) {
+	t.Parallel()
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, r.TLS.PeerCertificates[0].Subject.CommonName)
+	}))
+	server.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert, MinVersion: tls.VersionTLS12}
+	server.StartTLS()
+	defer server.Close()
+
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	writeClientCertificate(t, certFile, keyFile, "first")
+
+	client, err := NewHTTPClient(&commonConfig{APITLS: &apiTLSConfig{CertFile: certFile, KeyFile: keyFile, InsecureSkipVerify: true}})
+	if err != nil {
+		t.Fatalf("NewHTTPClient() failed: %v", err)
+	}
+	if name := getCommonName(t, client, server.URL); name != "first" {
+		t.Errorf("the server got the client certificate %q, expected %q", name, "first")
+	}
+
+	writeClientCertificate(t, certFile, keyFile, "second")
+	later := time.Now().Add(time.Minute)
+	for _, path := range []string{certFile, keyFile} {
+		if err := os.Chtimes(path, later, later); err != nil {
+			t.Fatal(err)
+		}
+	}
+	client.CloseIdleConnections()
+
+	if name := getCommonName(t, client, server.URL); name != "second" {
+		t.Errorf("the server got the client certificate %q after the rotation, expected %q", name, "second")
+	}
+}
+
+func get(t *testing.T, client *http.Client, url string) error {
+	t.Helper()
+	req, err := http.NewRequestWithContext(t.Context(), http.MethodGet, url, nil)
+	if err != nil {
+		t.Fatalf("failed to create request: %v", err)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+func getCommonName(t *testing.T, client *http.Client, url string) string {
+	t.Helper()
+	req, err := http.NewRequestWithContext(t.Context(), http.MethodGet, url, nil)
+	if err != nil {
+		t.Fatalf("failed to create request: %v", err)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("couldn't read the response: %v", err)
+	}
+	return string(body)
+}
+
+func writeClientCertificate(t *testing.T, certFile, keyFile, commonName string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	cert, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writePEM(t, certFile, "CERTIFICATE", cert)
+	writePEM(t, keyFile, "EC PRIVATE KEY", keyDER)
+}
+
+// writeCA writes the certificate of a new CA to the file and returns the certificate and its key.
+func writeCA(t *testing.T, path string) (*x509.Certificate, *ecdsa.PrivateKey) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writePEM(t, path, "CERTIFICATE", der)
+	return cert, key
+}
+
+// newServerCertificate returns a server certificate with the names of the template, signed by the CA.
+func newServerCertificate(t *testing.T, template, ca *x509.Certificate, caKey *ecdsa.PrivateKey) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template.SerialNumber = big.NewInt(2)
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(time.Hour)
+	template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca, &key.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func writePEM(t *testing.T, path, blockType string, data []byte) {
+	t.Helper()
+	err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: data}), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
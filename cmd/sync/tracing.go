@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracer creates the spans of nginx-asg-sync. Until the tracer provider is set, the spans are not recorded.
+var tracer = otel.Tracer("github.com/nginx/nginx-asg-sync")
+
+// tracingConfig configures the export of the traces of the sync cycles over OTLP.
+type tracingConfig struct {
+	SampleRatio *float64 `yaml:"sample_ratio,omitempty"`
+	Endpoint    string   `yaml:"endpoint"`
+}
+
+func validateTracingConfig(cfg *tracingConfig) error {
+	if cfg.Endpoint == "" {
+		return fmt.Errorf(errorMsgFormat, "endpoint of tracing")
+	}
+	u, err := url.Parse(cfg.Endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf(tracingEndpointErrorMsgFmt, cfg.Endpoint)
+	}
+	if cfg.SampleRatio != nil && (*cfg.SampleRatio < 0 || *cfg.SampleRatio > 1) {
+		return fmt.Errorf(tracingSampleRatioErrorMsgFmt, *cfg.SampleRatio)
+	}
+	return nil
+}
+
+// newTracerProvider creates the tracer provider that exports the spans to the OTLP/HTTP endpoint of the config. If the
+// endpoint has no path, the spans are sent to the default path /v1/traces.
+func newTracerProvider(ctx context.Context, cfg *tracingConfig) (*sdktrace.TracerProvider, error) {
+	u, err := url.Parse(cfg.Endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't parse the endpoint of tracing: %w", err)
+	}
+	opts := []otlptracehttp.Option{otlptracehttp.WithEndpoint(u.Host)}
+	if u.Scheme == "http" {
+		opts = append(opts, otlptracehttp.WithInsecure())
+	}
+	if u.Path != "" && u.Path != "/" {
+		opts = append(opts, otlptracehttp.WithURLPath(u.Path))
+	}
+
+	exporter, err := otlptracehttp.New(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't create the OTLP exporter: %w", err)
+	}
+
+	ratio := 1.0
+	if cfg.SampleRatio != nil {
+		ratio = *cfg.SampleRatio
+	}
+
+	return sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(ratio))),
+		sdktrace.WithResource(resource.NewSchemaless(
+			attribute.String("service.name", "nginx-asg-sync"),
+			attribute.String("service.version", version),
+		)),
+	), nil
+}
+
+// startSpan starts a span with the attributes from the key-value pairs, like the arguments of slog.
+func startSpan(ctx context.Context, name string, args ...any) (context.Context, trace.Span) {
+	attrs := make([]attribute.KeyValue, 0, len(args)/2)
+	for i := 0; i+1 < len(args); i += 2 {
+		attrs = append(attrs, attribute.String(fmt.Sprint(args[i]), fmt.Sprint(args[i+1])))
+	}
+	return tracer.Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// endSpan records the error, if any, and ends the span.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// startCloudAPICall starts the span of a call to the API of the cloud provider. The returned function ends the span
+// and logs the call with its duration at the debug level.
+func startCloudAPICall(ctx context.Context, operation string, args ...any) (context.Context, func(err error)) {
+	start := time.Now()
+	ctx, span := startSpan(ctx, operation, args...)
+
+	return ctx, func(err error) {
+		endSpan(span, err)
+
+		args = append(args, "operation", operation, "duration", time.Since(start))
+		if err != nil {
+			args = append(args, "error", err)
+		}
+		slog.Debug("Called the cloud provider API", args...)
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	nginx "github.com/nginx/nginx-plus-go-client/v3/client"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestSyncSpans(t *testing.T) {
+	t.Parallel()
+	exporter := tracetest.NewInMemoryExporter()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)))
+
+	upstream := Upstream{Name: "backend", Kind: "http", ScalingGroup: "group", Port: 80}
+	provider := &fakeCloudProvider{ips: map[string][]string{"group": {"10.0.0.1"}}}
+	updater := &fakeUpstreamUpdater{servers: map[string][]nginx.UpstreamServer{}}
+	s := newSyncer(provider, map[string]upstreamUpdater{"http": updater}, nil)
+
+	if err := s.sync(context.Background(), upstream); err != nil {
+		t.Fatalf("sync() failed: %v", err)
+	}
+	_, done := startCloudAPICall(context.Background(), "DescribeInstances", "scaling_group", "group")
+	done(errors.New("throttled"))
+
+	spans := exporter.GetSpans()
+	byName := make(map[string]tracetest.SpanStub, len(spans))
+	for _, span := range spans {
+		byName[span.Name] = span
+	}
+
+	root, ok := byName["sync upstream"]
+	if !ok {
+		t.Fatalf("sync() didn't create the span of the upstream, got %v spans", len(spans))
+	}
+	for _, name := range []string{"GetInstancesForScalingGroup", "UpdateServers"} {
+		span, ok := byName[name]
+		if !ok {
+			t.Errorf("sync() didn't create the span %v", name)
+			continue
+		}
+		if span.Parent.SpanID() != root.SpanContext.SpanID() {
+			t.Errorf("the span %v isn't a child of the span of the upstream", name)
+		}
+	}
+
+	call, ok := byName["DescribeInstances"]
+	if !ok || call.Status.Code != codes.Error {
+		t.Errorf("startCloudAPICall() didn't record the failed call in a span")
+	}
+}
+
+func TestValidateTracingConfig(t *testing.T) {
+	t.Parallel()
+	ratio := 0.1
+	invalidRatio := 2.0
+	tests := []struct {
+		msg     string
+		cfg     tracingConfig
+		wantErr bool
+	}{
+		{cfg: tracingConfig{Endpoint: "http://otel-collector:4318", SampleRatio: &ratio}, msg: "valid config"},
+		{cfg: tracingConfig{}, msg: "missing endpoint", wantErr: true},
+		{cfg: tracingConfig{Endpoint: "otel-collector:4318"}, msg: "endpoint without a scheme", wantErr: true},
+		{cfg: tracingConfig{Endpoint: "https://otel-collector:4318", SampleRatio: &invalidRatio}, msg: "sample_ratio above 1", wantErr: true},
+	}
+
+	for _, test := range tests {
+		err := validateTracingConfig(&test.cfg)
+		if (err != nil) != test.wantErr {
+			t.Errorf("validateTracingConfig() returned %v for the config with %v", err, test.msg)
+		}
+	}
+}
@@ -0,0 +1,301 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	nginx "github.com/nginx/nginx-plus-go-client/v3/client"
+	yaml "gopkg.in/yaml.v3"
+)
+
+const validateCommand = "validate"
+
+var (
+	yamlLineError    = regexp.MustCompile(`^(?:yaml: )?line (\d+): (.*)$`)
+	yamlUnknownField = regexp.MustCompile(`^field (\S+) not found in type \S+$`)
+)
+
+// scalingGroupFields are the fields of the scaling groups of an upstream for every cloud provider.
+var scalingGroupFields = map[string][2]string{
+	"AWS":   {"autoscaling_group", "backup_autoscaling_group"},
+	"Azure": {"virtual_machine_scale_set", "backup_virtual_machine_scale_set"},
+}
+
+// configProblem is a problem found in the config file. Line is 0 if the problem can't be tied to a line.
+type configProblem struct {
+	msg  string
+	line int
+}
+
+func (p configProblem) String() string {
+	if p.line == 0 {
+		return p.msg
+	}
+	return fmt.Sprintf("%d: %s", p.line, p.msg)
+}
+
+// awsFileConfig and azureFileConfig describe the whole config file, so that it can be decoded strictly.
+type awsFileConfig struct {
+	awsConfig    `yaml:",inline"`
+	commonConfig `yaml:",inline"`
+}
+
+type azureFileConfig struct {
+	azureConfig  `yaml:",inline"`
+	commonConfig `yaml:",inline"`
+}
+
+// runValidate runs the validate command with its arguments and returns the exit status: 0 if the config file is
+// valid, 1 if it has problems and 2 if the arguments are invalid.
+func runValidate(args []string, out io.Writer) int {
+	fs := flag.NewFlagSet(validateCommand, flag.ContinueOnError)
+	configPath := fs.String("config_path", "/etc/nginx/config.yaml", "Path to the config file")
+	live := fs.Bool("live", false, "Also check the upstreams in NGINX Plus and the scaling groups in the cloud provider")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	data, err := os.ReadFile(*configPath)
+	if err != nil {
+		fmt.Fprintf(out, "Couldn't read the config file %v: %v\n", *configPath, err)
+		return 1
+	}
+
+	problems := validateConfigData(data)
+	if len(problems) == 0 && *live {
+		problems = checkConfigLive(context.TODO(), data)
+	}
+
+	for _, p := range problems {
+		fmt.Fprintf(out, "%v:%v\n", *configPath, p)
+	}
+	if len(problems) > 0 {
+		fmt.Fprintf(out, "Found %d problem(s) in the config file %v\n", len(problems), *configPath)
+		return 1
+	}
+
+	fmt.Fprintf(out, "The config file %v is valid\n", *configPath)
+	return 0
+}
+
+// validateConfigData returns all the problems of the config file sorted by line: syntax errors, unknown fields,
+// invalid types and invalid values of the fields.
+func validateConfigData(data []byte) []configProblem {
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return problemsFromYAMLError(err)
+	}
+	if root.Kind == 0 {
+		return []configProblem{{msg: "the config file is empty"}}
+	}
+
+	common := &commonConfig{}
+	// the type errors are reported by the strict decoding below
+	_ = root.Decode(common)
+
+	var problems []configProblem
+	var errs []error
+	switch common.CloudProvider {
+	case "AWS", "":
+		cfg := &awsFileConfig{}
+		problems = decodeStrict(data, cfg)
+		errs = append(errs, validateCommonConfig(&cfg.commonConfig), validateAWSConfig(&cfg.awsConfig))
+	case "Azure":
+		cfg := &azureFileConfig{}
+		problems = decodeStrict(data, cfg)
+		errs = append(errs, validateCommonConfig(&cfg.commonConfig), validateAzureConfig(&cfg.azureConfig))
+	default:
+		errs = append(errs, validateCommonConfig(common))
+	}
+
+	decodingLines := make(map[int]bool, len(problems))
+	for _, p := range problems {
+		decodingLines[p.line] = true
+	}
+	for _, err := range splitJoinedErrors(errs) {
+		p := configProblem{msg: err.Error()}
+		var cfgErr *configError
+		if errors.As(err, &cfgErr) {
+			p.line = fieldLine(&root, cfgErr.field)
+		}
+		// a field that couldn't be decoded is also reported as empty or invalid
+		if p.line != 0 && decodingLines[p.line] {
+			continue
+		}
+		problems = append(problems, p)
+	}
+
+	slices.SortStableFunc(problems, func(a, b configProblem) int {
+		return a.line - b.line
+	})
+	return problems
+}
+
+// decodeStrict decodes the config file rejecting unknown fields and returns the problems found while decoding.
+func decodeStrict(data []byte, out any) []configProblem {
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	err := dec.Decode(out)
+	if err == nil || errors.Is(err, io.EOF) {
+		return nil
+	}
+	return problemsFromYAMLError(err)
+}
+
+func problemsFromYAMLError(err error) []configProblem {
+	msgs := []string{err.Error()}
+	var typeErr *yaml.TypeError
+	if errors.As(err, &typeErr) {
+		msgs = typeErr.Errors
+	}
+
+	problems := make([]configProblem, 0, len(msgs))
+	for _, msg := range msgs {
+		p := configProblem{msg: msg}
+		if m := yamlLineError.FindStringSubmatch(msg); m != nil {
+			p.line, _ = strconv.Atoi(m[1])
+			p.msg = m[2]
+		}
+		if m := yamlUnknownField.FindStringSubmatch(p.msg); m != nil {
+			p.msg = fmt.Sprintf("unknown field %v", m[1])
+		}
+		problems = append(problems, p)
+	}
+	return problems
+}
+
+func splitJoinedErrors(errs []error) []error {
+	var result []error
+	for _, err := range errs {
+		if err == nil {
+			continue
+		}
+		if joined, ok := err.(interface{ Unwrap() []error }); ok {
+			result = append(result, joined.Unwrap()...)
+		} else {
+			result = append(result, err)
+		}
+	}
+	return result
+}
+
+// fieldLine returns the line of a field of the config file identified by its path, for example upstreams.0.port.
+// If the field is missing, it returns the line of its closest parent, or 0 for a missing top level field.
+func fieldLine(root *yaml.Node, field string) int {
+	node := root
+	if node.Kind == yaml.DocumentNode && len(node.Content) > 0 {
+		node = node.Content[0]
+	}
+
+	line := 0
+	for _, part := range strings.Split(field, ".") {
+		switch node.Kind {
+		case yaml.MappingNode:
+			var value *yaml.Node
+			for i := 0; i+1 < len(node.Content); i += 2 {
+				if node.Content[i].Value == part {
+					line = node.Content[i].Line
+					value = node.Content[i+1]
+				}
+			}
+			if value == nil {
+				return line
+			}
+			node = value
+		case yaml.SequenceNode:
+			i, err := strconv.Atoi(part)
+			if err != nil || i < 0 || i >= len(node.Content) {
+				return line
+			}
+			node = node.Content[i]
+			line = node.Line
+		default:
+			return line
+		}
+	}
+	return line
+}
+
+// checkConfigLive checks a valid config file against NGINX Plus and the cloud provider: the upstreams must exist in
+// NGINX Plus, the scaling groups in the cloud provider and the key-value zones for ownership and leader election in NGINX Plus.
+func checkConfigLive(ctx context.Context, data []byte) []configProblem {
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return problemsFromYAMLError(err)
+	}
+
+	commonConfig, err := parseCommonConfig(data)
+	if err != nil {
+		return []configProblem{{msg: err.Error()}}
+	}
+
+	cloudProviderClient, err := newCloudProvider(commonConfig, data)
+	if err != nil {
+		return []configProblem{{msg: fmt.Sprintf("couldn't create cloud provider client for %v: %v", commonConfig.CloudProvider, err)}}
+	}
+
+	httpClient, err := NewHTTPClient(commonConfig)
+	if err != nil {
+		return []configProblem{{msg: fmt.Sprintf("couldn't create the HTTP client of NGINX: %v", err), line: fieldLine(&root, "api_tls")}}
+	}
+
+	nginxClient, err := nginx.NewNginxClient(commonConfig.APIEndpoint, nginx.WithHTTPClient(httpClient))
+	if err != nil {
+		return []configProblem{{msg: fmt.Sprintf("couldn't create NGINX client: %v", err), line: fieldLine(&root, "api_endpoint")}}
+	}
+
+	var problems []configProblem
+	groupFields := scalingGroupFields[commonConfig.CloudProvider]
+	for i, ups := range cloudProviderClient.GetUpstreams() {
+		if ups.Kind == "http" {
+			err = nginxClient.CheckIfUpstreamExists(ctx, ups.Name)
+		} else {
+			err = nginxClient.CheckIfStreamUpstreamExists(ctx, ups.Name)
+		}
+		if err != nil {
+			problems = append(problems, configProblem{msg: err.Error(), line: fieldLine(&root, upstreamField(i, "name"))})
+		}
+
+		for j, group := range []string{ups.ScalingGroup, ups.BackupScalingGroup} {
+			if group == "" {
+				continue
+			}
+			line := fieldLine(&root, upstreamField(i, groupFields[j]))
+			exists, err := cloudProviderClient.CheckIfScalingGroupExists(ctx, group)
+			if err != nil {
+				problems = append(problems, configProblem{msg: fmt.Sprintf("couldn't check if the scaling group %v exists: %v", group, err), line: line})
+			} else if !exists {
+				problems = append(problems, configProblem{msg: fmt.Sprintf("the scaling group %v doesn't exist in the cloud provider", group), line: line})
+			}
+		}
+	}
+
+	if commonConfig.Ownership != nil && commonConfig.Ownership.KeyvalZone != "" {
+		if _, err := nginxClient.GetKeyValPairs(ctx, commonConfig.Ownership.KeyvalZone); err != nil {
+			problems = append(problems, configProblem{msg: err.Error(), line: fieldLine(&root, "ownership.keyval_zone")})
+		}
+	}
+
+	if commonConfig.LeaderElection != nil && commonConfig.LeaderElection.KeyvalZone != "" {
+		if _, err := nginxClient.GetKeyValPairs(ctx, commonConfig.LeaderElection.KeyvalZone); err != nil {
+			problems = append(problems, configProblem{msg: err.Error(), line: fieldLine(&root, "leader_election.keyval_zone")})
+		}
+	}
+
+	if isZoneAwarenessEnabled(cloudProviderClient.GetUpstreams()) && (commonConfig.Zone == "" || commonConfig.Zone == "self") {
+		if _, err := cloudProviderClient.GetLocalZone(ctx); err != nil {
+			problems = append(problems, configProblem{msg: fmt.Sprintf("couldn't get the zone of nginx-asg-sync: %v", err), line: fieldLine(&root, "zone")})
+		}
+	}
+
+	return problems
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	yaml "gopkg.in/yaml.v3"
+)
+
+func TestValidateConfigData(t *testing.T) {
+	t.Parallel()
+	data := []byte(`api_endpoint: http://127.0.0.1:8080/api
+sync_interval: 5s
+region: us-west-2
+unknown: true
+upstreams:
+  - name: backend
+    autoscaling_group: backend-group
+    port: 80
+    kind: tcp
+  - name: backend
+    autoscaling_group: backend-group
+    port: abc
+    kind: tcp
+  - name: other
+    port: 80
+    kind: http
+`)
+
+	problems := validateConfigData(data)
+
+	lines := make([]int, 0, len(problems))
+	for _, p := range problems {
+		lines = append(lines, p.line)
+	}
+	expected := []int{4, 9, 10, 12, 13, 14}
+	if !reflect.DeepEqual(lines, expected) {
+		t.Errorf("validateConfigData() returned problems on the lines %v, expected %v: %v", lines, expected, problems)
+	}
+	if len(problems) > 0 && problems[0].msg != "unknown field unknown" {
+		t.Errorf("validateConfigData() returned %q for the unknown field", problems[0].msg)
+	}
+}
+
+func TestValidateConfigDataValid(t *testing.T) {
+	t.Parallel()
+	data := []byte(`api_endpoint: http://127.0.0.1:8080/api
+sync_interval: 5s
+cloud_provider: Azure
+subscription_id: subscription
+resource_group_name: group
+upstreams:
+  - name: backend
+    virtual_machine_scale_set: backend-set
+    port: 80
+    kind: stream
+`)
+
+	if problems := validateConfigData(data); len(problems) != 0 {
+		t.Errorf("validateConfigData() returned problems for the valid config: %v", problems)
+	}
+}
+
+func TestValidateConfigDataSyntaxError(t *testing.T) {
+	t.Parallel()
+	problems := validateConfigData([]byte("api_endpoint: http://127.0.0.1:8080/api\nsync_interval: [5s\n"))
+	if len(problems) != 1 || problems[0].line == 0 {
+		t.Errorf("validateConfigData() returned %v for the config with a syntax error", problems)
+	}
+}
+
+func TestFieldLine(t *testing.T) {
+	t.Parallel()
+	var root yaml.Node
+	data := "region: us-west-2\nupstreams:\n  - name: backend\n    port: 80\n"
+	if err := yaml.Unmarshal([]byte(data), &root); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		field    string
+		expected int
+	}{
+		{field: "region", expected: 1},
+		{field: "upstreams.0.port", expected: 4},
+		{field: "upstreams.0.kind", expected: 3},
+		{field: "upstreams.1.name", expected: 2},
+		{field: "api_endpoint", expected: 0},
+	}
+
+	for _, test := range tests {
+		if line := fieldLine(&root, test.field); line != test.expected {
+			t.Errorf("fieldLine() returned %v for %v, expected %v", line, test.field, test.expected)
+		}
+	}
+}
+
+func TestRunValidate(t *testing.T) {
+	t.Parallel()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte("sync_interval: 5s\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	if status := runValidate([]string{"-config_path", path}, &out); status != 1 {
+		t.Errorf("runValidate() returned %v for the invalid config, expected 1", status)
+	}
+	if !strings.Contains(out.String(), "api_endpoint") {
+		t.Errorf("runValidate() didn't report the missing api_endpoint: %v", out.String())
+	}
+}
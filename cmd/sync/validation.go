@@ -31,3 +31,41 @@ func isValidTime(time string) bool {
 
 	return validNginxTime.MatchString(time)
 }
+
+// https://nginx.org/en/docs/http/ngx_http_upstream_module.html
+// https://nginx.org/en/docs/stream/ngx_stream_upstream_module.html
+var loadBalancingMethods = map[string]map[string]bool{
+	"http": {
+		"round_robin": true,
+		"least_conn":  true,
+		"least_time":  true,
+		"hash":        true,
+		"ip_hash":     true,
+		"random":      true,
+	},
+	"stream": {
+		"round_robin": true,
+		"least_conn":  true,
+		"least_time":  true,
+		"hash":        true,
+		"random":      true,
+	},
+}
+
+func isValidLoadBalancingMethod(kind string, method string) bool {
+	if method == "" {
+		return true
+	}
+
+	return loadBalancingMethods[kind][method]
+}
+
+// allowsBackupServers reports whether backup servers can be used with the load balancing method.
+func allowsBackupServers(method string) bool {
+	switch method {
+	case "hash", "ip_hash", "random":
+		return false
+	default:
+		return true
+	}
+}
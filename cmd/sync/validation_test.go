@@ -20,3 +20,39 @@ func TestIsValidTime(t *testing.T) {
 		}
 	}
 }
+
+func TestIsValidLoadBalancingMethod(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		kind   string
+		method string
+		valid  bool
+	}{
+		{kind: "http", method: "", valid: true},
+		{kind: "http", method: "least_conn", valid: true},
+		{kind: "http", method: "ip_hash", valid: true},
+		{kind: "stream", method: "random", valid: true},
+		{kind: "stream", method: "ip_hash", valid: false},
+		{kind: "http", method: "fastest", valid: false},
+	}
+
+	for _, test := range tests {
+		if valid := isValidLoadBalancingMethod(test.kind, test.method); valid != test.valid {
+			t.Errorf("isValidLoadBalancingMethod(%q, %q) returned %v, expected %v", test.kind, test.method, valid, test.valid)
+		}
+	}
+}
+
+func TestAllowsBackupServers(t *testing.T) {
+	t.Parallel()
+	for _, method := range []string{"", "round_robin", "least_conn", "least_time"} {
+		if !allowsBackupServers(method) {
+			t.Errorf("allowsBackupServers(%q) returned false", method)
+		}
+	}
+	for _, method := range []string{"hash", "ip_hash", "random"} {
+		if allowsBackupServers(method) {
+			t.Errorf("allowsBackupServers(%q) returned true", method)
+		}
+	}
+}
@@ -0,0 +1,362 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	nginx "github.com/nginx/nginx-plus-go-client/v3/client"
+)
+
+const (
+	webhookFormatGeneric = "generic"
+	webhookFormatSlack   = "slack"
+	// webhookQueueSize is the number of change events waiting for the delivery to a webhook. When the queue is full,
+	// the new events are dropped.
+	webhookQueueSize = 100
+	// webhookShutdownTimeout is how long nginx-asg-sync waits for the queued events to be delivered before it exits.
+	webhookShutdownTimeout = 30 * time.Second
+
+	// the reasons of the changes of the servers
+	reasonInstanceFound     = "the instance is in the scaling group"
+	reasonProbePassed       = "the instance passed the health probe"
+	reasonInstanceGone      = "the instance is no longer in the scaling group"
+	reasonInstanceTags      = "the instance is excluded by instance_tags"
+	reasonInstanceTooYoung  = "the instance is younger than min_instance_age"
+	reasonNoAddress         = "the instance has no address selected by address_selection"
+	reasonProbeFailed       = "the instance failed the health probe"
+	reasonParametersChanged = "the parameters of the server changed"
+	reasonDraining          = "the server is draining"
+	reasonDrainTimeout      = "the drain timeout expired"
+	reasonUpstreamUntagged  = "the scaling group is no longer tagged, the servers of the upstream are left as they are"
+)
+
+// webhookConfig configures a webhook that receives an event every time nginx-asg-sync changes the servers of an
+// upstream.
+type webhookConfig struct {
+	Headers map[string]string `yaml:"headers,omitempty"`
+	Retry   *retryConfig      `yaml:"retry,omitempty"`
+	URL     string            `yaml:"url"`
+	Format  string            `yaml:"format,omitempty"`
+}
+
+func validateWebhookConfig(cfg webhookConfig, i int) error {
+	field := func(name string) string { return fmt.Sprintf("webhooks.%d.%s", i, name) }
+
+	if cfg.URL == "" {
+		return newConfigError(field("url"), errorMsgFormat, "url of the webhook")
+	}
+	u, err := url.Parse(cfg.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return newConfigError(field("url"), webhookURLErrorMsg)
+	}
+
+	if cfg.Format != "" && cfg.Format != webhookFormatGeneric && cfg.Format != webhookFormatSlack {
+		return newConfigError(field("format"), webhookFormatErrorMsgFmt, cfg.Format)
+	}
+
+	if cfg.Retry != nil {
+		if err := validateRetryConfig(cfg.Retry); err != nil {
+			return &configError{field: field("retry"), err: err}
+		}
+	}
+
+	return nil
+}
+
+// changeEvent describes the changes nginx-asg-sync made to the servers of an upstream. The event of a discovered
+// upstream that is no longer synced has no changes and the reason why the upstream is no longer synced.
+type changeEvent struct {
+	Timestamp          time.Time      `json:"timestamp"`
+	Upstream           string         `json:"upstream"`
+	Kind               string         `json:"kind"`
+	ScalingGroup       string         `json:"scaling_group"`
+	BackupScalingGroup string         `json:"backup_scaling_group,omitempty"`
+	Reason             string         `json:"reason,omitempty"`
+	Changes            []serverChange `json:"changes"`
+}
+
+// serverChange is a server that was added, removed or updated.
+type serverChange struct {
+	Action     string `json:"action"`
+	Address    string `json:"address"`
+	InstanceID string `json:"instance_id,omitempty"`
+	Reason     string `json:"reason"`
+}
+
+// changeNotifier sends the change events, for example to webhooks.
+type changeNotifier interface {
+	Notify(event changeEvent)
+}
+
+// newChangeEvent creates the event for the servers added, removed and updated in the upstream. The instance IDs are
+// looked up by the addresses of the servers. The reasons are why the servers aren't among the discovered servers of the
+// upstream, by the addresses of the servers. They are used for the removed and draining servers, which are otherwise
+// removed because their instances are no longer in the scaling groups.
+func newChangeEvent(upstream Upstream, instanceIDs, reasons map[string]string, added, removed, updated []nginx.UpstreamServer) changeEvent {
+	goneReason := func(server nginx.UpstreamServer) string {
+		if reason, ok := reasons[server.Server]; ok {
+			return reason
+		}
+		return reasonInstanceGone
+	}
+
+	changes := make([]serverChange, 0, len(added)+len(removed)+len(updated))
+	for _, group := range []struct {
+		reason  func(server nginx.UpstreamServer) string
+		action  string
+		servers []nginx.UpstreamServer
+	}{
+		{action: "added", servers: added, reason: func(nginx.UpstreamServer) string {
+			if upstream.HealthProbe != nil {
+				return reasonProbePassed
+			}
+			return reasonInstanceFound
+		}},
+		{action: "removed", servers: removed, reason: func(server nginx.UpstreamServer) string {
+			if upstream.DrainTimeout > 0 {
+				return goneReason(server) + ", " + reasonDrainTimeout
+			}
+			return goneReason(server)
+		}},
+		{action: "updated", servers: updated, reason: func(server nginx.UpstreamServer) string {
+			if server.Drain {
+				return goneReason(server) + ", " + reasonDraining
+			}
+			return reasonParametersChanged
+		}},
+	} {
+		for _, server := range group.servers {
+			changes = append(changes, serverChange{
+				Action:     group.action,
+				Address:    server.Server,
+				InstanceID: instanceIDs[server.Server],
+				Reason:     group.reason(server),
+			})
+		}
+	}
+
+	return changeEvent{
+		Timestamp:          time.Now().UTC(),
+		Upstream:           upstream.Name,
+		Kind:               upstream.Kind,
+		ScalingGroup:       upstream.ScalingGroup,
+		BackupScalingGroup: upstream.BackupScalingGroup,
+		Changes:            changes,
+	}
+}
+
+// newUpstreamRemovedEvent creates the event for the discovered upstream that is no longer synced.
+func newUpstreamRemovedEvent(upstream Upstream, reason string) changeEvent {
+	return changeEvent{
+		Timestamp:          time.Now().UTC(),
+		Upstream:           upstream.Name,
+		Kind:               upstream.Kind,
+		ScalingGroup:       upstream.ScalingGroup,
+		BackupScalingGroup: upstream.BackupScalingGroup,
+		Reason:             reason,
+		Changes:            []serverChange{},
+	}
+}
+
+// webhookPayload returns the body of the request to a webhook with the format for the event.
+func webhookPayload(format string, event changeEvent) ([]byte, error) {
+	if format != webhookFormatSlack {
+		return json.Marshal(event)
+	}
+
+	var text strings.Builder
+	if event.Reason != "" {
+		fmt.Fprintf(&text, "nginx-asg-sync stopped syncing the %v upstream *%v* (scaling group %v): %v", kindLabel(event.Kind), event.Upstream, event.ScalingGroup, event.Reason)
+		return json.Marshal(map[string]string{"text": text.String()})
+	}
+	fmt.Fprintf(&text, "nginx-asg-sync updated the %v upstream *%v* (scaling group %v):", kindLabel(event.Kind), event.Upstream, event.ScalingGroup)
+	for _, c := range event.Changes {
+		fmt.Fprintf(&text, "\n• %v %v", c.Action, c.Address)
+		if c.InstanceID != "" {
+			fmt.Fprintf(&text, " (%v)", c.InstanceID)
+		}
+		fmt.Fprintf(&text, ": %v", c.Reason)
+	}
+
+	return json.Marshal(map[string]string{"text": text.String()})
+}
+
+// webhookStatusError is the response of a webhook with a status other than 2xx.
+type webhookStatusError struct {
+	status int
+}
+
+func (e *webhookStatusError) Error() string {
+	return fmt.Sprintf("the webhook responded with the status %d", e.status)
+}
+
+// webhookNotifier delivers the change events to the webhooks. Every webhook has a queue of events delivered in the
+// background, so that a slow or failing webhook doesn't delay the sync.
+type webhookNotifier struct {
+	cancel   context.CancelFunc
+	webhooks []*webhook
+	wg       sync.WaitGroup
+}
+
+type webhook struct {
+	client  *http.Client
+	retry   *retryPolicy
+	headers http.Header
+	queue   chan changeEvent
+	url     string
+	host    string
+	format  string
+}
+
+// newWebhookNotifier creates the notifier for the webhooks and starts the deliveries. The webhooks without a retry
+// config use the retry config of nginx-asg-sync.
+func newWebhookNotifier(cfgs []webhookConfig, defaultRetry *retryConfig) (*webhookNotifier, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	n := &webhookNotifier{cancel: cancel}
+
+	for _, cfg := range cfgs {
+		headers := http.Header{}
+		for key, value := range cfg.Headers {
+			expanded, err := expandEnv(value)
+			if err != nil {
+				cancel()
+				return nil, fmt.Errorf("couldn't expand the value of the header %v of the webhook: %w", key, err)
+			}
+			headers.Set(key, expanded)
+		}
+
+		retry := cfg.Retry
+		if retry == nil {
+			retry = defaultRetry
+		}
+
+		u, err := url.Parse(cfg.URL)
+		if err != nil {
+			cancel()
+			return nil, fmt.Errorf("couldn't parse the URL of the webhook: %w", err)
+		}
+
+		n.webhooks = append(n.webhooks, &webhook{
+			client:  &http.Client{Timeout: connTimeoutInSecs * time.Second},
+			retry:   newRetryPolicy(retry),
+			headers: headers,
+			queue:   make(chan changeEvent, webhookQueueSize),
+			url:     cfg.URL,
+			// the URL of a webhook often includes a token, only the host is logged
+			host:   u.Host,
+			format: cfg.Format,
+		})
+	}
+
+	for _, w := range n.webhooks {
+		n.wg.Add(1)
+		go func() {
+			defer n.wg.Done()
+			w.run(ctx)
+		}()
+	}
+
+	return n, nil
+}
+
+// Notify queues the event for the delivery to every webhook. The delivery is best-effort: an event that doesn't fit
+// in the queue of a webhook is dropped and logged with its payload.
+func (n *webhookNotifier) Notify(event changeEvent) {
+	for _, w := range n.webhooks {
+		select {
+		case w.queue <- event:
+		default:
+			logDroppedEvent("Dropped the change event because the queue of the webhook is full", w.host, event, nil)
+		}
+	}
+}
+
+// logDroppedEvent logs the event that won't be delivered to the webhook with its full payload, so that the change
+// can still be found in the log.
+func logDroppedEvent(msg string, host string, event changeEvent, err error) {
+	payload, marshalErr := json.Marshal(event)
+	if marshalErr != nil {
+		payload = []byte(fmt.Sprintf("%+v", event))
+	}
+	attrs := []any{"webhook", host, "upstream", event.Upstream, "kind", event.Kind, "event", string(payload)}
+	if err != nil {
+		attrs = append(attrs, "error", err)
+	}
+	slog.Error(msg, attrs...)
+}
+
+// Close waits for the queued events to be delivered, up to the timeout.
+func (n *webhookNotifier) Close(timeout time.Duration) {
+	for _, w := range n.webhooks {
+		close(w.queue)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		n.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		slog.Warn("Stopped delivering the change events to the webhooks before all of them were delivered", "timeout", timeout)
+		n.cancel()
+		<-done
+	}
+	n.cancel()
+}
+
+func (w *webhook) run(ctx context.Context) {
+	for event := range w.queue {
+		err := w.retry.do(ctx, "deliver the change event to the webhook "+w.host, func() error {
+			return w.deliver(ctx, event)
+		})
+		if err != nil {
+			logDroppedEvent("Couldn't deliver the change event to the webhook", w.host, event, err)
+			continue
+		}
+		slog.Debug("Delivered the change event to the webhook", "webhook", w.host, "upstream", event.Upstream, "kind", event.Kind)
+	}
+}
+
+func (w *webhook) deliver(ctx context.Context, event changeEvent) error {
+	body, err := webhookPayload(w.format, event)
+	if err != nil {
+		return fmt.Errorf("couldn't marshal the change event: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("couldn't create the request to the webhook: %w", err)
+	}
+	for key, values := range w.headers {
+		req.Header[key] = values
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		// url.Error includes the URL, which must not be logged
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return fmt.Errorf("couldn't send the request to the webhook: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices {
+		return &webhookStatusError{status: resp.StatusCode}
+	}
+	return nil
+}
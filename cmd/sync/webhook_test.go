@@ -0,0 +1,196 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	nginx "github.com/nginx/nginx-plus-go-client/v3/client"
+)
+
+func TestNewChangeEvent(t *testing.T) {
+	t.Parallel()
+	upstream := Upstream{Name: "backend", Kind: "http", ScalingGroup: "group"}
+	instanceIDs := map[string]string{"10.0.0.1:80": "i-1", "10.0.0.2:80": "i-2"}
+
+	event := newChangeEvent(upstream, instanceIDs, nil,
+		[]nginx.UpstreamServer{{Server: "10.0.0.1:80"}}, []nginx.UpstreamServer{{Server: "10.0.0.2:80"}}, []nginx.UpstreamServer{{Server: "10.0.0.3:80"}})
+
+	expected := []serverChange{
+		{Action: "added", Address: "10.0.0.1:80", InstanceID: "i-1", Reason: reasonInstanceFound},
+		{Action: "removed", Address: "10.0.0.2:80", InstanceID: "i-2", Reason: reasonInstanceGone},
+		{Action: "updated", Address: "10.0.0.3:80", Reason: reasonParametersChanged},
+	}
+	if event.Upstream != "backend" || event.Kind != "http" || event.ScalingGroup != "group" || event.Timestamp.IsZero() {
+		t.Errorf("newChangeEvent() returned %+v, expected the fields of the upstream and a timestamp", event)
+	}
+	if len(event.Changes) != len(expected) {
+		t.Fatalf("newChangeEvent() returned %+v, expected %+v", event.Changes, expected)
+	}
+	for i := range expected {
+		if event.Changes[i] != expected[i] {
+			t.Errorf("newChangeEvent() returned the change %+v, expected %+v", event.Changes[i], expected[i])
+		}
+	}
+}
+
+func TestNewChangeEventWithReasons(t *testing.T) {
+	t.Parallel()
+	upstream := Upstream{Name: "backend", Kind: "http", ScalingGroup: "group", DrainTimeout: time.Minute, HealthProbe: &healthProbeConfig{}}
+	reasons := map[string]string{"10.0.0.2:80": reasonInstanceTags, "10.0.0.3:80": reasonProbeFailed}
+
+	event := newChangeEvent(upstream, nil, reasons,
+		[]nginx.UpstreamServer{{Server: "10.0.0.1:80"}},
+		[]nginx.UpstreamServer{{Server: "10.0.0.2:80"}, {Server: "10.0.0.4:80"}},
+		[]nginx.UpstreamServer{{Server: "10.0.0.3:80", Drain: true}},
+	)
+
+	expected := []string{
+		reasonProbePassed,
+		reasonInstanceTags + ", " + reasonDrainTimeout,
+		reasonInstanceGone + ", " + reasonDrainTimeout,
+		reasonProbeFailed + ", " + reasonDraining,
+	}
+	if len(event.Changes) != len(expected) {
+		t.Fatalf("newChangeEvent() returned %+v, expected %d changes", event.Changes, len(expected))
+	}
+	for i, reason := range expected {
+		if event.Changes[i].Reason != reason {
+			t.Errorf("newChangeEvent() returned the reason %q for %v, expected %q", event.Changes[i].Reason, event.Changes[i].Address, reason)
+		}
+	}
+}
+
+func TestWebhookPayload(t *testing.T) {
+	t.Parallel()
+	event := changeEvent{
+		Timestamp:    time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+		Upstream:     "backend",
+		Kind:         "http",
+		ScalingGroup: "group",
+		Changes:      []serverChange{{Action: "added", Address: "10.0.0.1:80", InstanceID: "i-1", Reason: reasonInstanceFound}},
+	}
+
+	body, err := webhookPayload(webhookFormatGeneric, event)
+	if err != nil {
+		t.Fatalf("webhookPayload() returned an error: %v", err)
+	}
+	var generic map[string]any
+	if err := json.Unmarshal(body, &generic); err != nil {
+		t.Fatalf("webhookPayload() returned invalid JSON: %v", err)
+	}
+	if generic["upstream"] != "backend" || generic["scaling_group"] != "group" || generic["timestamp"] != "2024-01-01T00:00:00Z" {
+		t.Errorf("webhookPayload() returned %s for the generic format", body)
+	}
+
+	body, err = webhookPayload(webhookFormatSlack, event)
+	if err != nil {
+		t.Fatalf("webhookPayload() returned an error: %v", err)
+	}
+	var slack map[string]string
+	if err := json.Unmarshal(body, &slack); err != nil {
+		t.Fatalf("webhookPayload() returned invalid JSON: %v", err)
+	}
+	if !strings.Contains(slack["text"], "*backend*") || !strings.Contains(slack["text"], "added 10.0.0.1:80 (i-1)") {
+		t.Errorf("webhookPayload() returned %s for the slack format", body)
+	}
+}
+
+func TestWebhookPayloadForRemovedUpstream(t *testing.T) {
+	t.Parallel()
+	event := newUpstreamRemovedEvent(Upstream{Name: "backend", Kind: "http", ScalingGroup: "group"}, reasonUpstreamUntagged)
+
+	body, err := webhookPayload(webhookFormatGeneric, event)
+	if err != nil {
+		t.Fatalf("webhookPayload() returned an error: %v", err)
+	}
+	var generic map[string]any
+	if err := json.Unmarshal(body, &generic); err != nil {
+		t.Fatalf("webhookPayload() returned invalid JSON: %v", err)
+	}
+	if generic["reason"] != reasonUpstreamUntagged || !reflect.DeepEqual(generic["changes"], []any{}) {
+		t.Errorf("webhookPayload() returned %s for the generic format", body)
+	}
+
+	body, err = webhookPayload(webhookFormatSlack, event)
+	if err != nil {
+		t.Fatalf("webhookPayload() returned an error: %v", err)
+	}
+	var slack map[string]string
+	if err := json.Unmarshal(body, &slack); err != nil {
+		t.Fatalf("webhookPayload() returned invalid JSON: %v", err)
+	}
+	if !strings.Contains(slack["text"], "stopped syncing the HTTP upstream *backend*") {
+		t.Errorf("webhookPayload() returned %s for the slack format", body)
+	}
+}
+
+func TestWebhookNotifierRetriesDelivery(t *testing.T) {
+	t.Parallel()
+	var mu sync.Mutex
+	var bodies []string
+	attempts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		attempts++
+		if attempts == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		bodies = append(bodies, string(body))
+		if r.Header.Get("X-Token") != "secret" {
+			t.Errorf("the webhook received the header X-Token %q, expected %q", r.Header.Get("X-Token"), "secret")
+		}
+	}))
+	defer server.Close()
+
+	cfg := webhookConfig{
+		URL:     server.URL,
+		Headers: map[string]string{"X-Token": "secret"},
+		Retry:   &retryConfig{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond},
+	}
+	n, err := newWebhookNotifier([]webhookConfig{cfg}, nil)
+	if err != nil {
+		t.Fatalf("newWebhookNotifier() returned an error: %v", err)
+	}
+
+	n.Notify(changeEvent{Upstream: "backend", Kind: "http"})
+	n.Close(5 * time.Second)
+
+	mu.Lock()
+	defer mu.Unlock()
+	if attempts != 2 || len(bodies) != 1 || !strings.Contains(bodies[0], `"upstream":"backend"`) {
+		t.Errorf("the webhook received %v after %d attempts, expected the event after 2 attempts", bodies, attempts)
+	}
+}
+
+func TestValidateWebhookConfig(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		msg     string
+		cfg     webhookConfig
+		wantErr bool
+	}{
+		{cfg: webhookConfig{URL: "https://hooks.example.com/services/token", Format: webhookFormatSlack}, msg: "slack webhook"},
+		{cfg: webhookConfig{URL: "http://127.0.0.1:9000/events"}, msg: "generic webhook"},
+		{cfg: webhookConfig{}, msg: "missing url", wantErr: true},
+		{cfg: webhookConfig{URL: "hooks.example.com"}, msg: "url without a scheme", wantErr: true},
+		{cfg: webhookConfig{URL: "https://hooks.example.com", Format: "teams"}, msg: "invalid format", wantErr: true},
+		{cfg: webhookConfig{URL: "https://hooks.example.com", Retry: &retryConfig{MaxAttempts: -1}}, msg: "invalid retry", wantErr: true},
+	}
+
+	for _, test := range tests {
+		err := validateWebhookConfig(test.cfg, 0)
+		if (err != nil) != test.wantErr {
+			t.Errorf("validateWebhookConfig() returned %v for the config with %v", err, test.msg)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+
+	nginx "github.com/nginx/nginx-plus-go-client/v3/client"
+)
+
+const (
+	crossZoneBackup = "backup"
+	crossZoneWeight = "weight"
+)
+
+// zoneAwarenessConfig configures how the instances in zones other than the zone of nginx-asg-sync are synced.
+type zoneAwarenessConfig struct {
+	CrossZone          string `yaml:"cross_zone"`
+	SameZoneWeight     int    `yaml:"same_zone_weight"`
+	MinSameZoneServers int    `yaml:"min_same_zone_servers"`
+}
+
+func validateZoneAwarenessConfig(cfg *zoneAwarenessConfig, upstreamName string, loadBalancingMethod string) error {
+	switch cfg.CrossZone {
+	case crossZoneBackup:
+		if !allowsBackupServers(loadBalancingMethod) {
+			return fmt.Errorf(upstreamBackupErrorMsgFmt, loadBalancingMethod, upstreamName)
+		}
+	case crossZoneWeight:
+		if cfg.SameZoneWeight < 2 {
+			return fmt.Errorf(upstreamSameZoneWeightErrorMsgFmt, cfg.SameZoneWeight, upstreamName)
+		}
+	default:
+		return fmt.Errorf(upstreamCrossZoneErrorMsgFmt, cfg.CrossZone, upstreamName)
+	}
+
+	if cfg.MinSameZoneServers < 0 {
+		return fmt.Errorf(upstreamMinSameZoneErrorMsgFmt, cfg.MinSameZoneServers, upstreamName)
+	}
+
+	return nil
+}
+
+// isZoneAwarenessEnabled reports whether any of the upstreams is zone aware.
+func isZoneAwarenessEnabled(upstreams []Upstream) bool {
+	for _, ups := range upstreams {
+		if ups.ZoneAwareness != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// zonePlacement decides whether the servers of an upstream are placed according to the zone of their instances.
+// The placement is skipped when fewer than min_same_zone_servers (at least one) instances are in the local zone,
+// so that the upstream is never left with only backup or only low weight servers in its own zone.
+func zonePlacement(cfg *zoneAwarenessConfig, localZone string, instances []Instance) bool {
+	if cfg == nil {
+		return false
+	}
+
+	sameZone := 0
+	for _, ins := range instances {
+		if ins.Zone == localZone {
+			sameZone++
+		}
+	}
+
+	return sameZone >= max(1, cfg.MinSameZoneServers)
+}
+
+// placeServerByZone marks a server of an instance in another zone as backup, or gives a server of an instance in
+// the local zone a higher weight, depending on the cross_zone setting.
+func placeServerByZone(server *nginx.UpstreamServer, cfg *zoneAwarenessConfig, sameZone bool) {
+	switch cfg.CrossZone {
+	case crossZoneBackup:
+		if !sameZone {
+			backup := true
+			server.Backup = &backup
+		}
+	case crossZoneWeight:
+		if sameZone {
+			weight := cfg.SameZoneWeight
+			server.Weight = &weight
+		}
+	}
+}
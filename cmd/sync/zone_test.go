@@ -0,0 +1,89 @@
+package main
+
+import (
+	"testing"
+
+	nginx "github.com/nginx/nginx-plus-go-client/v3/client"
+)
+
+func TestValidateZoneAwarenessConfig(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		lbMethod string
+		msg      string
+		cfg      zoneAwarenessConfig
+		wantErr  bool
+	}{
+		{cfg: zoneAwarenessConfig{CrossZone: "backup"}, msg: "backup"},
+		{cfg: zoneAwarenessConfig{CrossZone: "weight", SameZoneWeight: 5, MinSameZoneServers: 2}, msg: "weight"},
+		{cfg: zoneAwarenessConfig{CrossZone: "weight", SameZoneWeight: 5}, lbMethod: "hash", msg: "weight with hash"},
+		{cfg: zoneAwarenessConfig{CrossZone: "backup"}, lbMethod: "ip_hash", msg: "backup with ip_hash", wantErr: true},
+		{cfg: zoneAwarenessConfig{CrossZone: "weight", SameZoneWeight: 1}, msg: "same_zone_weight of 1", wantErr: true},
+		{cfg: zoneAwarenessConfig{CrossZone: "drop"}, msg: "invalid cross_zone", wantErr: true},
+		{cfg: zoneAwarenessConfig{CrossZone: "backup", MinSameZoneServers: -1}, msg: "negative min_same_zone_servers", wantErr: true},
+	}
+
+	for _, test := range tests {
+		err := validateZoneAwarenessConfig(&test.cfg, "backend", test.lbMethod)
+		if (err != nil) != test.wantErr {
+			t.Errorf("validateZoneAwarenessConfig() returned %v for the config with %v", err, test.msg)
+		}
+	}
+}
+
+func TestZonePlacement(t *testing.T) {
+	t.Parallel()
+	instances := []Instance{
+		{PrivateIP: "10.0.0.1", Zone: "zone-a"},
+		{PrivateIP: "10.0.0.2", Zone: "zone-a"},
+		{PrivateIP: "10.0.1.1", Zone: "zone-b"},
+	}
+	tests := []struct {
+		cfg       *zoneAwarenessConfig
+		localZone string
+		msg       string
+		expected  bool
+	}{
+		{cfg: nil, localZone: "zone-a", msg: "zone awareness disabled", expected: false},
+		{cfg: &zoneAwarenessConfig{}, localZone: "zone-a", msg: "default threshold", expected: true},
+		{cfg: &zoneAwarenessConfig{MinSameZoneServers: 2}, localZone: "zone-a", msg: "threshold reached", expected: true},
+		{cfg: &zoneAwarenessConfig{MinSameZoneServers: 3}, localZone: "zone-a", msg: "threshold not reached", expected: false},
+		{cfg: &zoneAwarenessConfig{}, localZone: "zone-c", msg: "no instances in the local zone", expected: false},
+	}
+
+	for _, test := range tests {
+		if result := zonePlacement(test.cfg, test.localZone, instances); result != test.expected {
+			t.Errorf("zonePlacement() returned %v for %v, expected %v", result, test.msg, test.expected)
+		}
+	}
+}
+
+func TestPlaceServerByZone(t *testing.T) {
+	t.Parallel()
+	backupCfg := &zoneAwarenessConfig{CrossZone: crossZoneBackup}
+	weightCfg := &zoneAwarenessConfig{CrossZone: crossZoneWeight, SameZoneWeight: 4}
+
+	var server nginx.UpstreamServer
+	placeServerByZone(&server, backupCfg, true)
+	if server.Backup != nil || server.Weight != nil {
+		t.Errorf("placeServerByZone() changed the server in the local zone to %+v", server)
+	}
+
+	server = nginx.UpstreamServer{}
+	placeServerByZone(&server, backupCfg, false)
+	if server.Backup == nil || !*server.Backup {
+		t.Errorf("placeServerByZone() didn't mark the server in another zone as backup: %+v", server)
+	}
+
+	server = nginx.UpstreamServer{}
+	placeServerByZone(&server, weightCfg, true)
+	if server.Weight == nil || *server.Weight != 4 {
+		t.Errorf("placeServerByZone() didn't set the weight of the server in the local zone: %+v", server)
+	}
+
+	server = nginx.UpstreamServer{}
+	placeServerByZone(&server, weightCfg, false)
+	if server.Backup != nil || server.Weight != nil {
+		t.Errorf("placeServerByZone() changed the server in another zone to %+v", server)
+	}
+}